@@ -90,17 +90,27 @@ func runCompiler(source, inputFile string, cfg *config.CompilerConfig) error {
 		return err
 	}
 
+	err = ana.TypeCheck()
+	if err != nil {
+		return err
+	}
+
 	err = ana.LabelLoops()
 	if err != nil {
 		return err
 	}
 
+	err = ana.ResolveGotoLabels()
+	if err != nil {
+		return err
+	}
+
 	if cfg.StopAfterValidate {
 		return nil
 	}
 
 	// Generate TAC
-	tacGen := ir.NewTACGenerator(ana.TempVarCounter)
+	tacGen := ir.NewTACGenerator(ana.TempVarCounter, ana.Symbols)
 	tacProgram, err := tacGen.Generate(ast)
 	if err != nil {
 		return err
@@ -111,7 +121,7 @@ func runCompiler(source, inputFile string, cfg *config.CompilerConfig) error {
 	}
 
 	// Generate assembly
-	asmGen := codegen.NewASMGenerator()
+	asmGen := codegen.NewASMGenerator(ana.Symbols)
 	err = asmGen.Generate(tacProgram)
 	if err != nil {
 		return err
@@ -132,7 +142,13 @@ func runCompiler(source, inputFile string, cfg *config.CompilerConfig) error {
 	}
 
 	// Assemble with GCC
-	cmd := exec.Command("gcc", asmFile, "-o", basePath)
+	args := []string{asmFile, "-o", basePath}
+	if cfg.CompileOnly {
+		args = []string{"-c", asmFile, "-o", fmt.Sprintf("%s.o", basePath)}
+	} else if cfg.LinkMath {
+		args = append(args, "-lm")
+	}
+	cmd := exec.Command("gcc", args...)
 	output, err := cmd.CombinedOutput()
 	os.Remove(asmFile)
 
@@ -0,0 +1,271 @@
+package codegen
+
+import (
+	"acc/internal/common/symbols"
+	"acc/internal/common/types"
+	"acc/internal/ir"
+)
+
+// eightbyteClass is how the System V ABI passes one eightbyte of a struct
+type eightbyteClass int
+
+const (
+	classInteger eightbyteClass = iota
+	classSSE
+	classMemory
+)
+
+// returnRegisters and doubleReturnRegisters hold the registers a value is returned in, in order
+var returnRegisters = []Register{regAX, regDX}
+var doubleReturnRegisters = []Register{regXMM0, regXMM1}
+
+// classifyStruct returns the class of each eightbyte of a struct. A struct larger than 16 bytes is
+// passed in memory; otherwise an eightbyte goes in an XMM register if it holds nothing but doubles,
+// and in a general purpose register if it holds anything else.
+func classifyStruct(t types.Structure) []eightbyteClass {
+	classes := make([]eightbyteClass, (t.Def.Size+7)/8)
+	if t.Def.Size > 16 {
+		for i := range classes {
+			classes[i] = classMemory
+		}
+		return classes
+	}
+
+	for i := range classes {
+		classes[i] = classSSE
+	}
+	markIntegerEightbytes(t, 0, classes)
+	return classes
+}
+
+// markIntegerEightbytes classifies every eightbyte containing a scalar of t other than a double as INTEGER
+func markIntegerEightbytes(t types.Type, offset int, classes []eightbyteClass) {
+	switch t := t.(type) {
+	case types.Structure:
+		for _, member := range t.Def.Members {
+			markIntegerEightbytes(member.Type, offset+member.Offset, classes)
+		}
+	case types.Array:
+		for i := 0; i < t.Length; i++ {
+			markIntegerEightbytes(t.Element, offset+i*types.Size(t.Element), classes)
+		}
+	case types.Double:
+	default:
+		classes[offset/8] = classInteger
+	}
+}
+
+// returnsInMemory reports whether a value of type t is returned through a pointer the caller passes
+// in rdi, rather than in registers
+func returnsInMemory(t types.Type) bool {
+	structure, isStruct := t.(types.Structure)
+	return isStruct && classifyStruct(structure)[0] == classMemory
+}
+
+// argPart is one piece of an argument as it's passed: either a whole scalar, or one eightbyte of a
+// struct. Size is the number of bytes that belong to the argument, which is less than eight only for
+// the last eightbyte of a struct whose size isn't a multiple of eight.
+type argPart struct {
+	Operand Operand
+	Type    AsmType
+	Size    int
+}
+
+// isIrregular reports whether the part can't be moved with a single instruction because it's too short
+func (p argPart) isIrregular() bool {
+	return p.Type == Quadword && p.Size < 8
+}
+
+// structParts splits a struct operand into its eightbytes, given their classes
+func structParts(op Operand, t types.Structure, classes []eightbyteClass) []argPart {
+	parts := make([]argPart, len(classes))
+	for i, class := range classes {
+		partType := Quadword
+		if class == classSSE {
+			partType = Double
+		}
+		parts[i] = argPart{Operand: offsetOperand(op, 8*i), Type: partType, Size: min(8, t.Def.Size-8*i)}
+	}
+	return parts
+}
+
+// classifyArgs splits arguments into the parts passed in general purpose registers, the parts passed
+// in XMM registers, and the rest, which are passed on the stack. A struct goes entirely on the stack
+// unless there are enough registers left for all of its eightbytes. When the function returns in
+// memory, rdi holds the return pointer and isn't available for arguments.
+func (g *AsmGenerator) classifyArgs(args []ir.Value, returnInMemory bool) (intArgs, doubleArgs, stackArgs []argPart) {
+	intRegisters := len(argRegisters)
+	if returnInMemory {
+		intRegisters--
+	}
+
+	for _, arg := range args {
+		op := g.convertOperand(arg)
+		t := g.valueType(arg)
+		structure, isStruct := t.(types.Structure)
+		if !isStruct {
+			part := argPart{Operand: op, Type: asmType(t), Size: types.Size(t)}
+			if part.Type == Double {
+				if len(doubleArgs) < len(doubleArgRegisters) {
+					doubleArgs = append(doubleArgs, part)
+					continue
+				}
+			} else if len(intArgs) < intRegisters {
+				intArgs = append(intArgs, part)
+				continue
+			}
+			stackArgs = append(stackArgs, part)
+			continue
+		}
+
+		classes := classifyStruct(structure)
+		parts := structParts(op, structure, classes)
+		if classes[0] != classMemory {
+			var ints, doubles []argPart
+			for _, part := range parts {
+				if part.Type == Double {
+					doubles = append(doubles, part)
+				} else {
+					ints = append(ints, part)
+				}
+			}
+			if len(intArgs)+len(ints) <= intRegisters && len(doubleArgs)+len(doubles) <= len(doubleArgRegisters) {
+				intArgs = append(intArgs, ints...)
+				doubleArgs = append(doubleArgs, doubles...)
+				continue
+			}
+		}
+		for _, part := range parts {
+			// On the stack a struct is copied byte for byte, whatever its eightbytes' classes
+			part.Type = Quadword
+			stackArgs = append(stackArgs, part)
+		}
+	}
+	return intArgs, doubleArgs, stackArgs
+}
+
+// moveToRegister loads an argument part into reg
+func moveToRegister(part argPart, reg Register) []Instruction {
+	if !part.isIrregular() {
+		return []Instruction{&Mov{Type: part.Type, Src: part.Operand, Dst: &Reg{Reg: reg}}}
+	}
+
+	// Reading a whole eightbyte could run past the end of the struct, so the bytes are
+	// loaded one at a time, starting from the last
+	var instructions []Instruction
+	for offset := part.Size - 1; offset >= 0; offset-- {
+		instructions = append(instructions, &Mov{Type: Byte, Src: offsetOperand(part.Operand, offset), Dst: &Reg{Reg: reg}})
+		if offset > 0 {
+			instructions = append(instructions, &Binary{Type: Quadword, Operator: opSal, Operand1: &Imn{Val: 8}, Operand2: &Reg{Reg: reg}})
+		}
+	}
+	return instructions
+}
+
+// moveFromRegister stores reg into an argument part, clobbering reg if the part is irregular
+func moveFromRegister(reg Register, part argPart) []Instruction {
+	if !part.isIrregular() {
+		return []Instruction{&Mov{Type: part.Type, Src: &Reg{Reg: reg}, Dst: part.Operand}}
+	}
+
+	var instructions []Instruction
+	for offset := 0; offset < part.Size; offset++ {
+		instructions = append(instructions, &Mov{Type: Byte, Src: &Reg{Reg: reg}, Dst: offsetOperand(part.Operand, offset)})
+		if offset < part.Size-1 {
+			instructions = append(instructions, &Binary{Type: Quadword, Operator: opShr, Operand1: &Imn{Val: 8}, Operand2: &Reg{Reg: reg}})
+		}
+	}
+	return instructions
+}
+
+// pushArg pushes an argument part onto the stack
+func pushArg(part argPart) []Instruction {
+	switch {
+	case part.isIrregular():
+		// pushq would read past the end of the struct, so make room and copy the bytes that are there
+		return append([]Instruction{&AllocateStack{Val: 8}}, copyBytes(part.Operand, &Memory{Reg: regSP, Offset: 0}, part.Size)...)
+	case part.Type == Quadword || part.Type == Double:
+		return []Instruction{&Push{Operand: part.Operand}}
+	}
+	if _, isConst := part.Operand.(*Imn); isConst {
+		return []Instruction{&Push{Operand: part.Operand}}
+	}
+	// pushq reads 8 bytes, so move shorter values through a register first
+	return []Instruction{&Mov{Type: part.Type, Src: part.Operand, Dst: &Reg{Reg: regAX}}, &Push{Operand: &Reg{Reg: regAX}}}
+}
+
+// returnParts splits a value returned in registers into the parts returned in general purpose
+// registers and the parts returned in XMM registers
+func (g *AsmGenerator) returnParts(value ir.Value) (intParts, doubleParts []argPart) {
+	op := g.convertOperand(value)
+	t := g.valueType(value)
+	var parts []argPart
+	if structure, isStruct := t.(types.Structure); isStruct {
+		parts = structParts(op, structure, classifyStruct(structure))
+	} else {
+		parts = []argPart{{Operand: op, Type: asmType(t), Size: types.Size(t)}}
+	}
+
+	for _, part := range parts {
+		if part.Type == Double {
+			doubleParts = append(doubleParts, part)
+		} else {
+			intParts = append(intParts, part)
+		}
+	}
+	return intParts, doubleParts
+}
+
+// vaListStart is what va_start initializes a va_list to: the offsets into the register save area of
+// the first general purpose and XMM registers the named parameters leave free, the first stack
+// argument after them, and the pseudoregister holding the register save area
+type vaListStart struct {
+	gpOffset     int
+	fpOffset     int
+	overflowArea Operand
+	saveArea     string
+}
+
+// saveArgRegisters stores every argument register in the register save area of a variadic function,
+// before the named parameters are copied out of them, so that va_arg can find the variadic arguments
+// passed in registers. The counts are of the registers and stack eightbytes the named parameters use.
+func (g *AsmGenerator) saveArgRegisters(function string, usedIntRegisters, usedDoubleRegisters, stackParams int) []Instruction {
+	name := function + ".reg_save_area"
+	saveAreaType := types.Array{Element: types.Char{}, Length: types.RegSaveAreaSize}
+	g.symbols.Add(name, &symbols.Symbol{Type: saveAreaType, Attrs: symbols.LocalAttrs{}})
+
+	var instructions []Instruction
+	for i, reg := range argRegisters {
+		instructions = append(instructions, &Mov{Type: Quadword, Src: &Reg{Reg: reg}, Dst: &PseudoMem{Identifier: name, Offset: 8 * i}})
+	}
+	for i, reg := range doubleArgRegisters {
+		dst := &PseudoMem{Identifier: name, Offset: types.GPSaveAreaSize + 16*i}
+		instructions = append(instructions, &Mov{Type: Double, Src: &Reg{Reg: reg}, Dst: dst})
+	}
+
+	g.vaStart = &vaListStart{
+		gpOffset:     8 * usedIntRegisters,
+		fpOffset:     types.GPSaveAreaSize + 16*usedDoubleRegisters,
+		overflowArea: &Stack{Val: 16 + 8*stackParams},
+		saveArea:     name,
+	}
+	return instructions
+}
+
+func (g *AsmGenerator) VisitVaStartInstr(node *ir.VaStartInstr) any {
+	tag := types.VaListTag().Def
+	gpOffset, _ := tag.Member("gp_offset")
+	fpOffset, _ := tag.Member("fp_offset")
+	overflowArea, _ := tag.Member("overflow_arg_area")
+	saveArea, _ := tag.Member("reg_save_area")
+
+	return []Instruction{
+		&Mov{Type: Quadword, Src: g.convertOperand(node.List), Dst: &Reg{Reg: regAX}},
+		&Mov{Type: Longword, Src: &Imn{Val: int64(g.vaStart.gpOffset)}, Dst: &Memory{Reg: regAX, Offset: gpOffset.Offset}},
+		&Mov{Type: Longword, Src: &Imn{Val: int64(g.vaStart.fpOffset)}, Dst: &Memory{Reg: regAX, Offset: fpOffset.Offset}},
+		&Lea{Src: g.vaStart.overflowArea, Dst: &Reg{Reg: regDX}},
+		&Mov{Type: Quadword, Src: &Reg{Reg: regDX}, Dst: &Memory{Reg: regAX, Offset: overflowArea.Offset}},
+		&Lea{Src: &PseudoMem{Identifier: g.vaStart.saveArea, Offset: 0}, Dst: &Reg{Reg: regDX}},
+		&Mov{Type: Quadword, Src: &Reg{Reg: regDX}, Dst: &Memory{Reg: regAX, Offset: saveArea.Offset}},
+	}
+}
@@ -1,12 +1,48 @@
 package codegen
 
+import (
+	"acc/internal/common/symbols"
+)
+
 type Register int
 
 const (
 	regAX Register = iota
+	regCX
 	regDX
+	regDI
+	regSI
+	regR8
+	regR9
 	regR10
 	regR11
+	regSP
+	regXMM0
+	regXMM1
+	regXMM2
+	regXMM3
+	regXMM4
+	regXMM5
+	regXMM6
+	regXMM7
+	regXMM14
+	regXMM15
+)
+
+// argRegisters holds the registers used for the first six integer arguments, in order
+var argRegisters = []Register{regDI, regSI, regDX, regCX, regR8, regR9}
+
+// doubleArgRegisters holds the registers used for the first eight double arguments, in order
+var doubleArgRegisters = []Register{regXMM0, regXMM1, regXMM2, regXMM3, regXMM4, regXMM5, regXMM6, regXMM7}
+
+// AsmType is the operand size of an instruction
+type AsmType int
+
+const (
+	Byte AsmType = iota
+	Longword
+	Quadword
+	Double
 )
 
 type UnaryOp int
@@ -22,6 +58,13 @@ const (
 	opAdd BinaryOp = iota
 	opSub
 	opMult
+	opDivDouble
+	opAnd
+	opOr
+	opXor
+	opShr
+	opSal
+	opSar
 )
 
 type CondCode int
@@ -33,8 +76,18 @@ const (
 	CondGE
 	CondL
 	CondLE
+	CondA
+	CondAE
+	CondB
+	CondBE
+	CondP
 )
 
+type TopLevel interface {
+	topLevel()
+	EmitAsm() string
+}
+
 type Instruction interface {
 	instr()
 	EmitAsm() string
@@ -45,46 +98,121 @@ type Operand interface {
 }
 
 type Program struct {
-	Function Function
+	TopLevel []TopLevel
 }
 
 type Function struct {
 	Name         string
+	Global       bool
 	Instructions []Instruction
 }
 
+type StaticVariable struct {
+	Name      string
+	Global    bool
+	Alignment int
+	Init      []symbols.StaticInit
+}
+
+// StaticConstant is a read-only value, such as a floating point constant or string literal, that lives in .rodata
+type StaticConstant struct {
+	Name      string
+	Alignment int
+	Init      symbols.StaticInit
+}
+
+// JumpTable is a table in .rodata of the offset of each target label from the start of the table
+type JumpTable struct {
+	Name    string
+	Targets []string
+}
+
 type Mov struct {
+	Type AsmType
+	Src  Operand
+	Dst  Operand
+}
+
+// Movsx sign extends a source into a wider destination
+type Movsx struct {
+	SrcType AsmType
+	DstType AsmType
+	Src     Operand
+	Dst     Operand
+}
+
+// Lea loads the address of Src, which must be in memory, into Dst
+type Lea struct {
 	Src Operand
 	Dst Operand
 }
 
+// MovZeroExtend zero extends a source into a wider destination
+type MovZeroExtend struct {
+	SrcType AsmType
+	DstType AsmType
+	Src     Operand
+	Dst     Operand
+}
+
 type Unary struct {
+	Type     AsmType
 	Operator UnaryOp
 	Operand  Operand
 }
 
 type Binary struct {
+	Type     AsmType
 	Operator BinaryOp
 	Operand1 Operand
 	Operand2 Operand
 }
 
 type Cmp struct {
+	Type     AsmType
 	Operand1 Operand
 	Operand2 Operand
 }
 
 type Idiv struct {
+	Type    AsmType
 	Operand Operand
 }
 
+// Cvttsd2si truncates a double to an integer of the given type
+type Cvttsd2si struct {
+	Type AsmType
+	Src  Operand
+	Dst  Operand
+}
+
+// Cvtsi2sd converts a signed integer of the given type to a double
+type Cvtsi2sd struct {
+	Type AsmType
+	Src  Operand
+	Dst  Operand
+}
+
+// Div is an unsigned divide of dx:ax by its operand
+type Div struct {
+	Type    AsmType
+	Operand Operand
+}
+
+// Cdq sign extends eax into edx, or rax into rdx (cqo) for quadwords
 type Cdq struct {
+	Type AsmType
 }
 
 type Jmp struct {
 	Identifier string
 }
 
+// JmpIndirect jumps to the address held in Operand
+type JmpIndirect struct {
+	Operand Operand
+}
+
 type JmpCC struct {
 	Condition  CondCode
 	Identifier string
@@ -106,10 +234,23 @@ type AllocateStack struct {
 	Val int
 }
 
-type Imn struct {
+type DeallocateStack struct {
 	Val int
 }
 
+type Push struct {
+	Operand Operand
+}
+
+type Call struct {
+	Identifier string
+	External   bool
+}
+
+type Imn struct {
+	Val int64
+}
+
 type Reg struct {
 	Reg Register
 }
@@ -118,24 +259,71 @@ type Pseudo struct {
 	Identifier string
 }
 
+// PseudoMem is a byte offset into a pseudoregister that holds an aggregate, such as a struct member
+type PseudoMem struct {
+	Identifier string
+	Offset     int
+}
+
+// Stack is an offset from the base pointer; locals are negative and stack arguments positive
 type Stack struct {
 	Val int
 }
 
-func (i *Mov) instr()           {}
-func (i *AllocateStack) instr() {}
-func (i *Unary) instr()         {}
-func (i *Binary) instr()        {}
-func (i *Cmp) instr()           {}
-func (i *Jmp) instr()           {}
-func (i *JmpCC) instr()         {}
-func (i *SetCC) instr()         {}
-func (i *Label) instr()         {}
-func (i *Idiv) instr()          {}
-func (i *Cdq) instr()           {}
-func (i *Ret) instr()           {}
-
-func (o *Imn) op()    {}
-func (o *Reg) op()    {}
-func (o *Pseudo) op() {}
-func (o *Stack) op()  {}
+// Memory is an offset from the address held in a register
+type Memory struct {
+	Reg    Register
+	Offset int
+}
+
+// Indexed addresses Base + Index * Scale, where Scale is 1, 2, 4 or 8
+type Indexed struct {
+	Base  Register
+	Index Register
+	Scale int
+}
+
+// Data is a RIP-relative reference to a static variable, or to a static constant if Local is set,
+// Offset bytes from its start
+type Data struct {
+	Identifier string
+	Local      bool
+	Offset     int
+}
+
+func (f *Function) topLevel()       {}
+func (v *StaticVariable) topLevel() {}
+func (c *StaticConstant) topLevel() {}
+func (t *JumpTable) topLevel()      {}
+
+func (i *Mov) instr()             {}
+func (i *Movsx) instr()           {}
+func (i *Lea) instr()             {}
+func (i *AllocateStack) instr()   {}
+func (i *DeallocateStack) instr() {}
+func (i *Push) instr()            {}
+func (i *Call) instr()            {}
+func (i *Unary) instr()           {}
+func (i *Binary) instr()          {}
+func (i *Cmp) instr()             {}
+func (i *Jmp) instr()             {}
+func (i *JmpIndirect) instr()     {}
+func (i *JmpCC) instr()           {}
+func (i *SetCC) instr()           {}
+func (i *Label) instr()           {}
+func (i *Idiv) instr()            {}
+func (i *Div) instr()             {}
+func (i *MovZeroExtend) instr()   {}
+func (i *Cvttsd2si) instr()       {}
+func (i *Cvtsi2sd) instr()        {}
+func (i *Cdq) instr()             {}
+func (i *Ret) instr()             {}
+
+func (o *Imn) op()       {}
+func (o *Reg) op()       {}
+func (o *Pseudo) op()    {}
+func (o *PseudoMem) op() {}
+func (o *Stack) op()     {}
+func (o *Memory) op()    {}
+func (o *Indexed) op()   {}
+func (o *Data) op()      {}
@@ -1,7 +1,10 @@
 package codegen
 
 import (
+	"acc/internal/common/symbols"
+	"acc/internal/common/types"
 	"fmt"
+	"math"
 	"runtime"
 )
 
@@ -10,7 +13,11 @@ func (program *Program) EmitAsm() string {
 	if runtime.GOOS == "linux" {
 		ending = "\n\t.section .note.GNU-stack,\"\",@progbits"
 	}
-	return fmt.Sprint(program.Function.EmitAsm(), ending)
+	items := ""
+	for _, item := range program.TopLevel {
+		items += item.EmitAsm()
+	}
+	return fmt.Sprint(items, ending)
 }
 
 func (function *Function) EmitAsm() string {
@@ -18,79 +25,282 @@ func (function *Function) EmitAsm() string {
 	for _, in := range function.Instructions {
 		i += in.EmitAsm()
 	}
-	name := function.Name
+	name := symbolName(function.Name)
+	global := ""
+	if function.Global {
+		global = fmt.Sprintf("\t.globl %s\n", name)
+	}
+	return fmt.Sprintf("%s\t.text\n%s:\n\tpushq\t%%rbp\n\tmovq\t%%rsp, %%rbp\n%s", global, name, i)
+}
+
+func (v *StaticVariable) EmitAsm() string {
+	name := symbolName(v.Name)
+	global := ""
+	if v.Global {
+		global = fmt.Sprintf("\t.globl %s\n", name)
+	}
+
+	// Zero-initialized variables go in .bss so they take no space in the object file
+	if isZeroInit(v.Init) {
+		size := 0
+		for _, init := range v.Init {
+			size += staticInitSize(init)
+		}
+		return fmt.Sprintf("%s\t.bss\n\t.balign %d\n%s:\n\t.zero %d\n", global, v.Alignment, name, size)
+	}
+
+	directives := ""
+	for _, init := range v.Init {
+		directives += fmt.Sprintf("\t%s\n", emitStaticInit(init))
+	}
+	return fmt.Sprintf("%s\t.data\n\t.balign %d\n%s:\n%s", global, v.Alignment, name, directives)
+}
+
+// isZeroInit reports whether every byte of a static initializer is zero
+func isZeroInit(inits []symbols.StaticInit) bool {
+	for _, init := range inits {
+		switch init := init.(type) {
+		case symbols.ConstInit:
+			if !types.IsZero(init.Value) {
+				return false
+			}
+		case symbols.StringInit, symbols.PointerInit:
+			return false
+		}
+	}
+	return true
+}
+
+func staticInitSize(init symbols.StaticInit) int {
+	switch init := init.(type) {
+	case symbols.ConstInit:
+		return types.Size(init.Value.Type())
+	case symbols.StringInit:
+		if init.NullTerminated {
+			return len(init.Value) + 1
+		}
+		return len(init.Value)
+	case symbols.PointerInit:
+		return 8
+	case symbols.ZeroInit:
+		return init.Bytes
+	default:
+		panic(fmt.Sprintf("invalid static initializer: %T", init))
+	}
+}
+
+func (c *StaticConstant) EmitAsm() string {
+	name := localLabel(c.Name)
+	init := emitStaticInit(c.Init)
+	if runtime.GOOS == "darwin" {
+		if _, isString := c.Init.(symbols.StringInit); isString {
+			return fmt.Sprintf("\t.cstring\n%s:\n\t%s\n", name, init)
+		}
+		// The literal sections have a fixed entry size, so 16-byte constants need padding
+		if c.Alignment == 16 {
+			return fmt.Sprintf("\t.literal16\n\t.balign 16\n%s:\n\t%s\n\t.quad 0\n", name, init)
+		}
+		return fmt.Sprintf("\t.literal8\n\t.balign 8\n%s:\n\t%s\n", name, init)
+	}
+	return fmt.Sprintf("\t.section .rodata\n\t.balign %d\n%s:\n\t%s\n", c.Alignment, name, init)
+}
+
+func (t *JumpTable) EmitAsm() string {
+	name := localLabel(t.Name)
+	entries := ""
+	for _, target := range t.Targets {
+		entries += fmt.Sprintf("\t.long %s - %s\n", localLabel(target), name)
+	}
+	// Mach-O can't express the difference between labels in different sections, so the table stays
+	// next to the code it jumps into
+	section := "\t.section .rodata\n"
+	if runtime.GOOS == "darwin" {
+		section = "\t.text\n"
+	}
+	return fmt.Sprintf("%s\t.balign 4\n%s:\n%s", section, name, entries)
+}
+
+// emitStaticInit emits the data directive for one piece of a static initializer
+func emitStaticInit(init symbols.StaticInit) string {
+	switch init := init.(type) {
+	case symbols.ConstInit:
+		return emitConst(init.Value)
+	case symbols.StringInit:
+		if init.NullTerminated {
+			return fmt.Sprintf(".asciz \"%s\"", escapeString(init.Value))
+		}
+		return fmt.Sprintf(".ascii \"%s\"", escapeString(init.Value))
+	case symbols.PointerInit:
+		return fmt.Sprintf(".quad %s", localLabel(init.Name))
+	case symbols.ZeroInit:
+		return fmt.Sprintf(".zero %d", init.Bytes)
+	default:
+		panic(fmt.Sprintf("invalid static initializer: %T", init))
+	}
+}
+
+// escapeString escapes a string for an .ascii directive, writing anything other than printable ASCII in octal
+func escapeString(value string) string {
+	escaped := ""
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			escaped += "\\" + string(c)
+		case c >= ' ' && c <= '~':
+			escaped += string(c)
+		default:
+			escaped += fmt.Sprintf("\\%03o", c)
+		}
+	}
+	return escaped
+}
+
+// emitConst emits the data directive that stores c; doubles are written as their exact bit pattern
+func emitConst(c types.Const) string {
+	if d, ok := c.(types.ConstDouble); ok {
+		return fmt.Sprintf(".quad %d", math.Float64bits(d.Value))
+	}
+	switch types.Size(c.Type()) {
+	case 1:
+		return fmt.Sprintf(".byte %s", c)
+	case 8:
+		return fmt.Sprintf(".quad %s", c)
+	default:
+		return fmt.Sprintf(".long %s", c)
+	}
+}
+
+// symbolName applies the platform's name mangling to a global symbol
+func symbolName(name string) string {
+	if runtime.GOOS == "darwin" {
+		return fmt.Sprint("_", name)
+	}
+	return name
+}
+
+// localLabel applies the platform's prefix for labels that stay out of the symbol table
+func localLabel(name string) string {
 	if runtime.GOOS == "darwin" {
-		name = fmt.Sprint("_", name)
+		return fmt.Sprint("L", name)
 	}
-	return fmt.Sprintf("\t.global %s\n%s:\n\tpushq\t%%rbp\n\tmovq\t%%rsp, %%rbp\n%s", name, name, i)
+	return fmt.Sprint(".L", name)
+}
+
+// emitOperand emits op, naming registers at the width given by t
+func emitOperand(op Operand, t AsmType) string {
+	if reg, isReg := op.(*Reg); isReg {
+		return reg.EmitAsmSized(t)
+	}
+	return op.EmitAsm()
 }
 
 func (move *Mov) EmitAsm() string {
-	return fmt.Sprintf("\tmovl\t%s, %s\n", move.Src.EmitAsm(), move.Dst.EmitAsm())
+	return fmt.Sprintf("\tmov%s\t%s, %s\n", move.Type.suffix(), emitOperand(move.Src, move.Type), emitOperand(move.Dst, move.Type))
+}
+
+func (move *Movsx) EmitAsm() string {
+	return fmt.Sprintf("\tmovs%s%s\t%s, %s\n", move.SrcType.suffix(), move.DstType.suffix(), emitOperand(move.Src, move.SrcType), emitOperand(move.Dst, move.DstType))
+}
+
+func (i *Lea) EmitAsm() string {
+	return fmt.Sprintf("\tleaq\t%s, %s\n", emitOperand(i.Src, Quadword), emitOperand(i.Dst, Quadword))
+}
+
+func (move *MovZeroExtend) EmitAsm() string {
+	// Zero extending a longword is a plain movl, which the fix-up pass has already rewritten it to
+	if move.SrcType != Byte {
+		panic("zero extension of a longword must be rewritten before emitting")
+	}
+	return fmt.Sprintf("\tmovzb%s\t%s, %s\n", move.DstType.suffix(), emitOperand(move.Src, Byte), emitOperand(move.Dst, move.DstType))
 }
 
 func (r *Unary) EmitAsm() string {
-	return fmt.Sprintf("\t%s\t%s\n", r.Operator.EmitAsm(), r.Operand.EmitAsm())
+	return fmt.Sprintf("\t%s%s\t%s\n", r.Operator.EmitAsm(), r.Type.suffix(), emitOperand(r.Operand, r.Type))
 }
 
 func (r *Binary) EmitAsm() string {
-	return fmt.Sprintf("\t%s\t%s, %s\n", r.Operator.EmitAsm(), r.Operand1.EmitAsm(), r.Operand2.EmitAsm())
+	var operator string
+	if r.Type == Double {
+		operator = r.Operator.EmitAsmDouble()
+	} else {
+		operator = r.Operator.EmitAsm() + r.Type.suffix()
+	}
+	// A shift count in a register is always in %cl
+	countType := r.Type
+	if r.Operator.isShift() {
+		countType = Byte
+	}
+	return fmt.Sprintf("\t%s\t%s, %s\n", operator, emitOperand(r.Operand1, countType), emitOperand(r.Operand2, r.Type))
 }
 
 func (i *Cmp) EmitAsm() string {
-	return fmt.Sprintf("\tcmpl\t%s, %s\n", i.Operand1.EmitAsm(), i.Operand2.EmitAsm())
+	if i.Type == Double {
+		return fmt.Sprintf("\tcomisd\t%s, %s\n", emitOperand(i.Operand1, i.Type), emitOperand(i.Operand2, i.Type))
+	}
+	return fmt.Sprintf("\tcmp%s\t%s, %s\n", i.Type.suffix(), emitOperand(i.Operand1, i.Type), emitOperand(i.Operand2, i.Type))
+}
+
+func (i *Cvttsd2si) EmitAsm() string {
+	return fmt.Sprintf("\tcvttsd2si%s\t%s, %s\n", i.Type.suffix(), emitOperand(i.Src, Double), emitOperand(i.Dst, i.Type))
+}
+
+func (i *Cvtsi2sd) EmitAsm() string {
+	return fmt.Sprintf("\tcvtsi2sd%s\t%s, %s\n", i.Type.suffix(), emitOperand(i.Src, i.Type), emitOperand(i.Dst, Double))
 }
 
 func (r *Idiv) EmitAsm() string {
-	return fmt.Sprintf("\tidivl\t%s\n", r.Operand.EmitAsm())
+	return fmt.Sprintf("\tidiv%s\t%s\n", r.Type.suffix(), emitOperand(r.Operand, r.Type))
+}
+
+func (r *Div) EmitAsm() string {
+	return fmt.Sprintf("\tdiv%s\t%s\n", r.Type.suffix(), emitOperand(r.Operand, r.Type))
 }
 
 func (r *Cdq) EmitAsm() string {
+	if r.Type == Quadword {
+		return "\tcqo\n"
+	}
 	return "\tcdq\n"
 }
 
 func (i *Jmp) EmitAsm() string {
-	identifier := i.Identifier
-	if runtime.GOOS == "darwin" {
-		identifier = fmt.Sprint("L", identifier)
-	} else {
-		// Linux
-		identifier = fmt.Sprint(".L", identifier)
-	}
-
-	return fmt.Sprintf("\tjmp\t%s\n", identifier)
+	return fmt.Sprintf("\tjmp\t%s\n", localLabel(i.Identifier))
+}
+func (i *JmpIndirect) EmitAsm() string {
+	return fmt.Sprintf("\tjmp\t*%s\n", emitOperand(i.Operand, Quadword))
 }
 func (i *JmpCC) EmitAsm() string {
-	identifier := i.Identifier
-	if runtime.GOOS == "darwin" {
-		identifier = fmt.Sprint("L", identifier)
-	} else {
-		// Linux
-		identifier = fmt.Sprint(".L", identifier)
-	}
-
-	return fmt.Sprintf("\tj%s\t%s\n", i.Condition.EmitAsm(), identifier)
+	return fmt.Sprintf("\tj%s\t%s\n", i.Condition.EmitAsm(), localLabel(i.Identifier))
 }
 func (i *SetCC) EmitAsm() string {
-	op := i.Operand.EmitAsm()
-	if reg, opIsReg := i.Operand.(*Reg); opIsReg {
-		op = reg.EmitAsm1Bit()
-	}
-	return fmt.Sprintf("\tset%s\t%s\n", i.Condition.EmitAsm(), op)
+	return fmt.Sprintf("\tset%s\t%s\n", i.Condition.EmitAsm(), emitOperand(i.Operand, Byte))
 }
 func (i *Label) EmitAsm() string {
-	if runtime.GOOS == "darwin" {
-		return fmt.Sprintf("L%s:\n", i.Identifier)
-	} else {
-		// Linux
-		return fmt.Sprintf(".L%s:\n", i.Identifier)
-	}
+	return fmt.Sprintf("%s:\n", localLabel(i.Identifier))
 }
 
 func (r *AllocateStack) EmitAsm() string {
 	return fmt.Sprintf("\tsubq\t$%d, %%rsp\n", r.Val)
 }
 
+func (r *DeallocateStack) EmitAsm() string {
+	return fmt.Sprintf("\taddq\t$%d, %%rsp\n", r.Val)
+}
+
+func (i *Push) EmitAsm() string {
+	return fmt.Sprintf("\tpushq\t%s\n", emitOperand(i.Operand, Quadword))
+}
+
+func (i *Call) EmitAsm() string {
+	name := symbolName(i.Identifier)
+	if runtime.GOOS != "darwin" && i.External {
+		// Linux
+		name = fmt.Sprint(name, "@PLT")
+	}
+	return fmt.Sprintf("\tcall\t%s\n", name)
+}
+
 func (r *Ret) EmitAsm() string {
 	return "\tmovq\t%rbp, %rsp\n\tpopq\t%rbp\n\tret\n"
 }
@@ -99,33 +309,51 @@ func (r *Imn) EmitAsm() string {
 	return fmt.Sprintf("$%d", r.Val)
 }
 
-func (r *Reg) EmitAsm() string {
-	switch r.Reg {
-	case regAX:
-		return "%eax"
-	case regDX:
-		return "%edx"
-	case regR10:
-		return "%r10d"
-	case regR11:
-		return "%r11d"
-	default:
+// registerNames holds the 1, 4 and 8 byte names of each register
+var registerNames = map[Register][3]string{
+	regAX:  {"%al", "%eax", "%rax"},
+	regCX:  {"%cl", "%ecx", "%rcx"},
+	regDX:  {"%dl", "%edx", "%rdx"},
+	regDI:  {"%dil", "%edi", "%rdi"},
+	regSI:  {"%sil", "%esi", "%rsi"},
+	regR8:  {"%r8b", "%r8d", "%r8"},
+	regR9:  {"%r9b", "%r9d", "%r9"},
+	regR10: {"%r10b", "%r10d", "%r10"},
+	regR11: {"%r11b", "%r11d", "%r11"},
+	regSP:  {"%spl", "%esp", "%rsp"},
+
+	regXMM0:  {"%xmm0", "%xmm0", "%xmm0"},
+	regXMM1:  {"%xmm1", "%xmm1", "%xmm1"},
+	regXMM2:  {"%xmm2", "%xmm2", "%xmm2"},
+	regXMM3:  {"%xmm3", "%xmm3", "%xmm3"},
+	regXMM4:  {"%xmm4", "%xmm4", "%xmm4"},
+	regXMM5:  {"%xmm5", "%xmm5", "%xmm5"},
+	regXMM6:  {"%xmm6", "%xmm6", "%xmm6"},
+	regXMM7:  {"%xmm7", "%xmm7", "%xmm7"},
+	regXMM14: {"%xmm14", "%xmm14", "%xmm14"},
+	regXMM15: {"%xmm15", "%xmm15", "%xmm15"},
+}
+
+func (r *Reg) name(index int) string {
+	names, ok := registerNames[r.Reg]
+	if !ok {
 		panic(fmt.Sprintf("invalid register type: %d", r.Reg))
 	}
+	return names[index]
 }
 
-func (r *Reg) EmitAsm1Bit() string {
-	switch r.Reg {
-	case regAX:
-		return "%al"
-	case regDX:
-		return "%dl"
-	case regR10:
-		return "%r10b"
-	case regR11:
-		return "%r11b"
+func (r *Reg) EmitAsm() string {
+	return r.name(1)
+}
+
+func (r *Reg) EmitAsmSized(t AsmType) string {
+	switch t {
+	case Byte:
+		return r.name(0)
+	case Quadword, Double:
+		return r.name(2)
 	default:
-		panic(fmt.Sprintf("invalid 1-bit register type: %d", r.Reg))
+		return r.name(1)
 	}
 }
 
@@ -134,29 +362,101 @@ func (r *Pseudo) EmitAsm() string {
 }
 
 func (o *Stack) EmitAsm() string {
-	return fmt.Sprintf("-%d(%%rbp)", o.Val)
+	return fmt.Sprintf("%d(%%rbp)", o.Val)
+}
+
+func (o *Memory) EmitAsm() string {
+	return fmt.Sprintf("%d(%s)", o.Offset, (&Reg{Reg: o.Reg}).name(2))
+}
+
+func (o *Indexed) EmitAsm() string {
+	return fmt.Sprintf("(%s, %s, %d)", (&Reg{Reg: o.Base}).name(2), (&Reg{Reg: o.Index}).name(2), o.Scale)
+}
+
+func (r *PseudoMem) EmitAsm() string {
+	panic("pseudo registers not allowed in final asm")
+}
+
+func (o *Data) EmitAsm() string {
+	name := symbolName(o.Identifier)
+	if o.Local {
+		name = localLabel(o.Identifier)
+	}
+	if o.Offset != 0 {
+		return fmt.Sprintf("%s+%d(%%rip)", name, o.Offset)
+	}
+	return fmt.Sprintf("%s(%%rip)", name)
+}
+
+func (t AsmType) suffix() string {
+	switch t {
+	case Byte:
+		return "b"
+	case Longword:
+		return "l"
+	case Quadword:
+		return "q"
+	case Double:
+		return "sd"
+	default:
+		panic(fmt.Sprintf("invalid assembly type: %d", t))
+	}
 }
+
 func (o UnaryOp) EmitAsm() string {
 	switch o {
 	case opNeg:
-		return "negl"
+		return "neg"
 	case opNot:
-		return "notl"
+		return "not"
 	default:
-		panic(fmt.Sprintf("invalid unary operator type: %d", 0))
+		panic(fmt.Sprintf("invalid unary operator type: %d", o))
 	}
 }
 
+func (o BinaryOp) isShift() bool {
+	return o == opShr || o == opSal || o == opSar
+}
+
 func (o BinaryOp) EmitAsm() string {
 	switch o {
 	case opAdd:
-		return "addl"
+		return "add"
+	case opSub:
+		return "sub"
+	case opMult:
+		return "imul"
+	case opAnd:
+		return "and"
+	case opOr:
+		return "or"
+	case opXor:
+		return "xor"
+	case opShr:
+		return "shr"
+	case opSal:
+		return "sal"
+	case opSar:
+		return "sar"
+	default:
+		panic(fmt.Sprintf("invalid binary operator type: %d", o))
+	}
+}
+
+func (o BinaryOp) EmitAsmDouble() string {
+	switch o {
+	case opAdd:
+		return "addsd"
 	case opSub:
-		return "subl"
+		return "subsd"
 	case opMult:
-		return "imull"
+		return "mulsd"
+	case opDivDouble:
+		return "divsd"
+	case opXor:
+		return "xorpd"
 	default:
-		panic(fmt.Sprintf("invalid binary operator type: %d", 0))
+		panic(fmt.Sprintf("invalid double operator type: %d", o))
 	}
 }
 
@@ -174,7 +474,17 @@ func (o CondCode) EmitAsm() string {
 		return "g"
 	case CondGE:
 		return "ge"
+	case CondA:
+		return "a"
+	case CondAE:
+		return "ae"
+	case CondB:
+		return "b"
+	case CondBE:
+		return "be"
+	case CondP:
+		return "p"
 	default:
-		panic(fmt.Sprintf("invalid binary operator type: %d", 0))
+		panic(fmt.Sprintf("invalid condition code: %d", o))
 	}
 }
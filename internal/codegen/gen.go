@@ -2,23 +2,56 @@ package codegen
 
 import (
 	"acc/internal/common/errors"
+	"acc/internal/common/symbols"
+	"acc/internal/common/types"
 	"acc/internal/ir"
 	"acc/internal/parser"
 	"fmt"
+	"math"
 )
 
 type AsmGenerator struct {
-	Program    *Program
-	stackAlloc *stackAllocator
+	Program          *Program
+	definedFunctions map[string]bool
+	symbols          *symbols.Table
+	constants        map[staticConstantKey]*StaticConstant
+	constantOrder    []*StaticConstant
+	jumpTables       []*JumpTable
+	labelCounter     int
+	// returnPtr holds the address to return a struct through, in functions that return in memory
+	returnPtr Operand
+	// vaStart is what va_start sets a va_list to, in variadic functions
+	vaStart *vaListStart
 }
 
-func NewASMGenerator() *AsmGenerator {
+// staticConstantKey identifies a constant in .rodata, so each value is only emitted once per alignment
+type staticConstantKey struct {
+	bits      uint64
+	alignment int
+}
+
+func NewASMGenerator(symbolTable *symbols.Table) *AsmGenerator {
 	return &AsmGenerator{
-		stackAlloc: &stackAllocator{
-			Variables:    make(map[string]int),
-			CurrentIndex: 4,
-		},
+		symbols:   symbolTable,
+		constants: make(map[staticConstantKey]*StaticConstant),
+	}
+}
+
+func (g *AsmGenerator) makeLabel(prefix string) string {
+	g.labelCounter++
+	return fmt.Sprintf("%s.%d", prefix, g.labelCounter)
+}
+
+// doubleConstant returns an operand referring to a read-only copy of value, creating it if needed
+func (g *AsmGenerator) doubleConstant(value float64, alignment int) *Data {
+	key := staticConstantKey{bits: math.Float64bits(value), alignment: alignment}
+	constant, ok := g.constants[key]
+	if !ok {
+		constant = &StaticConstant{Name: g.makeLabel("const.double"), Alignment: alignment, Init: symbols.ConstInit{Value: types.ConstDouble{Value: value}}}
+		g.constants[key] = constant
+		g.constantOrder = append(g.constantOrder, constant)
 	}
+	return &Data{Identifier: constant.Name, Local: true}
 }
 
 func (g *AsmGenerator) Generate(node *ir.Program) error {
@@ -35,14 +68,79 @@ func (g *AsmGenerator) Generate(node *ir.Program) error {
 }
 
 func (g *AsmGenerator) VisitProgram(node *ir.Program) any {
-	function := node.Function.Accept(g).(Function)
-	return &Program{Function: function}
+	g.definedFunctions = make(map[string]bool)
+	for _, item := range node.TopLevel {
+		if function, ok := item.(*ir.Function); ok {
+			g.definedFunctions[function.Identifier] = true
+		}
+	}
+
+	program := &Program{}
+	for _, item := range node.TopLevel {
+		switch item := item.(type) {
+		case *ir.Function:
+			program.TopLevel = append(program.TopLevel, item.Accept(g).(*Function))
+		case *ir.StaticVariable:
+			program.TopLevel = append(program.TopLevel, item.Accept(g).(*StaticVariable))
+		case *ir.StaticConstant:
+			program.TopLevel = append(program.TopLevel, item.Accept(g).(*StaticConstant))
+		default:
+			panic(fmt.Sprintf("invalid top level type: %T", item))
+		}
+	}
+	for _, constant := range g.constantOrder {
+		program.TopLevel = append(program.TopLevel, constant)
+	}
+	for _, table := range g.jumpTables {
+		program.TopLevel = append(program.TopLevel, table)
+	}
+	return program
 }
 
 func (g *AsmGenerator) VisitFunction(node *ir.Function) any {
-	function := Function{Name: node.Identifier}
+	function := &Function{Name: node.Identifier, Global: node.Global}
 	var instructions []Instruction
 
+	// A struct returned in memory is written through a pointer the caller passes in rdi
+	symbol, _ := g.symbols.Get(node.Identifier)
+	returnInMemory := returnsInMemory(symbol.Type.(types.FunType).Ret)
+	intRegisters := argRegisters
+	g.returnPtr = nil
+	if returnInMemory {
+		name := node.Identifier + ".return_ptr"
+		g.symbols.Add(name, &symbols.Symbol{Type: types.Pointer{Referenced: types.Void{}}, Attrs: symbols.LocalAttrs{}})
+		g.returnPtr = &Pseudo{Identifier: name}
+		instructions = append(instructions, &Mov{Type: Quadword, Src: &Reg{Reg: regDI}, Dst: g.returnPtr})
+		intRegisters = argRegisters[1:]
+	}
+
+	// Copy parameters out of their registers and stack slots into pseudoregisters
+	params := make([]ir.Value, len(node.Params))
+	for i, param := range node.Params {
+		params[i] = &ir.Variable{Identifier: param}
+	}
+	intParams, doubleParams, stackParams := g.classifyArgs(params, returnInMemory)
+	g.vaStart = nil
+	if symbol.Type.(types.FunType).Variadic {
+		usedIntRegisters := len(argRegisters) - len(intRegisters) + len(intParams)
+		instructions = append(instructions, g.saveArgRegisters(node.Identifier, usedIntRegisters, len(doubleParams), len(stackParams))...)
+	}
+	for i, param := range intParams {
+		instructions = append(instructions, moveFromRegister(intRegisters[i], param)...)
+	}
+	for i, param := range doubleParams {
+		instructions = append(instructions, moveFromRegister(doubleArgRegisters[i], param)...)
+	}
+	for i, param := range stackParams {
+		// Return address and saved rbp sit between rbp and the first stack argument
+		src := &Stack{Val: 16 + 8*i}
+		if param.isIrregular() {
+			instructions = append(instructions, copyBytes(src, param.Operand, param.Size)...)
+		} else {
+			instructions = append(instructions, &Mov{Type: param.Type, Src: src, Dst: param.Operand})
+		}
+	}
+
 	for _, i := range node.Body {
 		switch instr := i.(type) {
 		case *ir.ReturnInstr:
@@ -52,7 +150,7 @@ func (g *AsmGenerator) VisitFunction(node *ir.Function) any {
 		case *ir.BinaryInstr:
 			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
 		case *ir.CopyInstr:
-			instructions = append(instructions, instr.Accept(g).(Instruction))
+			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
 		case *ir.JumpInstr:
 			instructions = append(instructions, instr.Accept(g).(Instruction))
 		case *ir.JumpIfZeroInstr:
@@ -61,6 +159,38 @@ func (g *AsmGenerator) VisitFunction(node *ir.Function) any {
 			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
 		case *ir.LabelInstr:
 			instructions = append(instructions, instr.Accept(g).(Instruction))
+		case *ir.JumpTableInstr:
+			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
+		case *ir.FunCallInstr:
+			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
+		case *ir.SignExtendInstr:
+			instructions = append(instructions, instr.Accept(g).(Instruction))
+		case *ir.TruncateInstr:
+			instructions = append(instructions, instr.Accept(g).(Instruction))
+		case *ir.ZeroExtendInstr:
+			instructions = append(instructions, instr.Accept(g).(Instruction))
+		case *ir.IntToDoubleInstr:
+			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
+		case *ir.DoubleToIntInstr:
+			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
+		case *ir.UIntToDoubleInstr:
+			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
+		case *ir.DoubleToUIntInstr:
+			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
+		case *ir.GetAddressInstr:
+			instructions = append(instructions, instr.Accept(g).(Instruction))
+		case *ir.LoadInstr:
+			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
+		case *ir.StoreInstr:
+			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
+		case *ir.AddPtrInstr:
+			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
+		case *ir.CopyToOffsetInstr:
+			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
+		case *ir.CopyFromOffsetInstr:
+			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
+		case *ir.VaStartInstr:
+			instructions = append(instructions, instr.Accept(g).([]Instruction)...)
 		default:
 			panic(fmt.Sprintf("invalid instruction type: %T", i))
 		}
@@ -69,27 +199,63 @@ func (g *AsmGenerator) VisitFunction(node *ir.Function) any {
 	return function
 }
 
+func (g *AsmGenerator) VisitStaticVariable(node *ir.StaticVariable) any {
+	return &StaticVariable{Name: node.Identifier, Global: node.Global, Alignment: types.VarAlignment(node.Type), Init: node.Init}
+}
+
+func (g *AsmGenerator) VisitStaticConstant(node *ir.StaticConstant) any {
+	return &StaticConstant{Name: node.Identifier, Alignment: types.VarAlignment(node.Type), Init: node.Init}
+}
+
 func (g *AsmGenerator) VisitReturnInstr(node *ir.ReturnInstr) any {
-	src := g.convertOperand(node.Value)
-	return []Instruction{&Mov{Src: src, Dst: &Reg{Reg: regAX}}, &Ret{}}
+	if node.Value == nil {
+		return []Instruction{&Ret{}}
+	}
+
+	// The caller expects the return pointer back in rax
+	if g.returnPtr != nil {
+		instructions := []Instruction{&Mov{Type: Quadword, Src: g.returnPtr, Dst: &Reg{Reg: regAX}}}
+		instructions = append(instructions, copyBytes(g.convertOperand(node.Value), &Memory{Reg: regAX, Offset: 0}, types.Size(g.valueType(node.Value)))...)
+		return append(instructions, &Ret{})
+	}
+
+	var instructions []Instruction
+	intParts, doubleParts := g.returnParts(node.Value)
+	for i, part := range intParts {
+		instructions = append(instructions, moveToRegister(part, returnRegisters[i])...)
+	}
+	for i, part := range doubleParts {
+		instructions = append(instructions, moveToRegister(part, doubleReturnRegisters[i])...)
+	}
+	return append(instructions, &Ret{})
 }
 
 func (g *AsmGenerator) VisitUnaryInstr(node *ir.UnaryInstr) interface{} {
 	src := g.convertOperand(node.Src)
 
 	dst := g.convertOperand(node.Dst)
+	srcType := g.operandType(node.Src)
+
+	if srcType == Double {
+		return g.doubleUnary(node.Operator, src, dst)
+	}
 
 	if node.Operator == parser.UnopNot {
-		return []Instruction{&Cmp{Operand1: &Imn{0}, Operand2: src}, &Mov{Src: &Imn{0}, Dst: dst}, &SetCC{Condition: CondE, Operand: dst}}
+		return []Instruction{&Cmp{Type: srcType, Operand1: &Imn{0}, Operand2: src}, &Mov{Type: g.operandType(node.Dst), Src: &Imn{0}, Dst: dst}, &SetCC{Condition: CondE, Operand: dst}}
 	}
 
 	op := convertUnOp(node.Operator)
 
-	return []Instruction{&Mov{Src: src, Dst: dst}, &Unary{Operator: op, Operand: dst}}
+	return []Instruction{&Mov{Type: srcType, Src: src, Dst: dst}, &Unary{Type: srcType, Operator: op, Operand: dst}}
 }
 
 func (g *AsmGenerator) VisitBinaryInstr(node *ir.BinaryInstr) any {
 	instructions := []Instruction{}
+	t := g.operandType(node.Src1)
+
+	if t == Double {
+		return g.doubleBinary(node)
+	}
 
 	switch node.Operator {
 	case parser.BinopDivide:
@@ -97,48 +263,74 @@ func (g *AsmGenerator) VisitBinaryInstr(node *ir.BinaryInstr) any {
 		src2 := g.convertOperand(node.Src2)
 		dst := g.convertOperand(node.Dst)
 
-		instructions = append(instructions, &Mov{Src: src1, Dst: &Reg{Reg: regAX}})
-		instructions = append(instructions, &Cdq{})
-		instructions = append(instructions, &Idiv{Operand: src2})
-		instructions = append(instructions, &Mov{Src: &Reg{Reg: regAX}, Dst: dst})
+		instructions = append(instructions, &Mov{Type: t, Src: src1, Dst: &Reg{Reg: regAX}})
+		instructions = append(instructions, g.divide(node.Src1, src2, t)...)
+		instructions = append(instructions, &Mov{Type: t, Src: &Reg{Reg: regAX}, Dst: dst})
 	case parser.BinopRemainder:
 		src1 := g.convertOperand(node.Src1)
 		src2 := g.convertOperand(node.Src2)
 		dst := g.convertOperand(node.Dst)
 
-		instructions = append(instructions, &Mov{Src: src1, Dst: &Reg{Reg: regAX}})
-		instructions = append(instructions, &Cdq{})
-		instructions = append(instructions, &Idiv{Operand: src2})
-		instructions = append(instructions, &Mov{Src: &Reg{Reg: regDX}, Dst: dst})
+		instructions = append(instructions, &Mov{Type: t, Src: src1, Dst: &Reg{Reg: regAX}})
+		instructions = append(instructions, g.divide(node.Src1, src2, t)...)
+		instructions = append(instructions, &Mov{Type: t, Src: &Reg{Reg: regDX}, Dst: dst})
 	case parser.BinopGreaterThan, parser.BinopGreaterOrEqual, parser.BinopLessThan, parser.BinopLessOrEqual, parser.BinopEqual, parser.BinopNotEqual:
 		instructions = append(instructions, g.handleRelationalOp(node)...)
+	case parser.BinopShiftLeft, parser.BinopShiftRight:
+		// The count can only be an immediate or %cl, and it keeps its own type, which may differ from t
+		op := opSal
+		if node.Operator == parser.BinopShiftRight {
+			op = opShr
+			if g.isSigned(node.Src1) {
+				op = opSar
+			}
+		}
+		dst := g.convertOperand(node.Dst)
+		instructions = append(instructions,
+			&Mov{Type: t, Src: g.convertOperand(node.Src1), Dst: dst},
+			&Mov{Type: g.operandType(node.Src2), Src: g.convertOperand(node.Src2), Dst: &Reg{Reg: regCX}},
+			&Binary{Type: t, Operator: op, Operand1: &Reg{Reg: regCX}, Operand2: dst},
+		)
 	default:
 		src1 := g.convertOperand(node.Src1)
 		src2 := g.convertOperand(node.Src2)
 		dst := g.convertOperand(node.Dst)
 		op := convertBinOp(node.Operator)
 
-		instructions = append(instructions, &Mov{Src: src1, Dst: dst})
-		instructions = append(instructions, &Binary{Operator: op, Operand1: src2, Operand2: dst})
+		instructions = append(instructions, &Mov{Type: t, Src: src1, Dst: dst})
+		instructions = append(instructions, &Binary{Type: t, Operator: op, Operand1: src2, Operand2: dst})
 	}
 	return instructions
 }
 
+// divide divides ax by divisor, sign extending into dx for signed operands and zeroing dx otherwise
+func (g *AsmGenerator) divide(dividend ir.Value, divisor Operand, t AsmType) []Instruction {
+	if g.isSigned(dividend) {
+		return []Instruction{&Cdq{Type: t}, &Idiv{Type: t, Operand: divisor}}
+	}
+	zeroDX := &Binary{Type: Longword, Operator: opXor, Operand1: &Reg{Reg: regDX}, Operand2: &Reg{Reg: regDX}}
+	return []Instruction{zeroDX, &Div{Type: t, Operand: divisor}}
+}
+
 func (g *AsmGenerator) handleRelationalOp(node *ir.BinaryInstr) []Instruction {
 	src1 := g.convertOperand(node.Src1)
 	src2 := g.convertOperand(node.Src2)
 	dst := g.convertOperand(node.Dst)
-	instructions := []Instruction{&Cmp{Operand1: src2, Operand2: src1}, &Mov{Src: &Imn{Val: 0}, Dst: dst}}
+	instructions := []Instruction{
+		&Cmp{Type: g.operandType(node.Src1), Operand1: src2, Operand2: src1},
+		&Mov{Type: g.operandType(node.Dst), Src: &Imn{Val: 0}, Dst: dst},
+	}
 
+	signed := g.isSigned(node.Src1)
 	switch node.Operator {
 	case parser.BinopLessThan:
-		instructions = append(instructions, &SetCC{Condition: CondL, Operand: dst})
+		instructions = append(instructions, &SetCC{Condition: pickCond(signed, CondL, CondB), Operand: dst})
 	case parser.BinopLessOrEqual:
-		instructions = append(instructions, &SetCC{Condition: CondLE, Operand: dst})
+		instructions = append(instructions, &SetCC{Condition: pickCond(signed, CondLE, CondBE), Operand: dst})
 	case parser.BinopGreaterThan:
-		instructions = append(instructions, &SetCC{Condition: CondG, Operand: dst})
+		instructions = append(instructions, &SetCC{Condition: pickCond(signed, CondG, CondA), Operand: dst})
 	case parser.BinopGreaterOrEqual:
-		instructions = append(instructions, &SetCC{Condition: CondGE, Operand: dst})
+		instructions = append(instructions, &SetCC{Condition: pickCond(signed, CondGE, CondAE), Operand: dst})
 	case parser.BinopEqual:
 		instructions = append(instructions, &SetCC{Condition: CondE, Operand: dst})
 	case parser.BinopNotEqual:
@@ -149,44 +341,487 @@ func (g *AsmGenerator) handleRelationalOp(node *ir.BinaryInstr) []Instruction {
 	return instructions
 }
 
-func (g *AsmGenerator) VisitCopyInstr(node *ir.CopyInstr) any {
-	src := g.convertOperand(node.Src)
+// pickCond chooses between the signed and unsigned forms of a condition code
+func pickCond(signed bool, signedCond, unsignedCond CondCode) CondCode {
+	if signed {
+		return signedCond
+	}
+	return unsignedCond
+}
+
+// doubleUnary lowers a unary operation on a double; there is no negate instruction, so the sign bit is flipped with xorpd
+func (g *AsmGenerator) doubleUnary(operator parser.UnopType, src, dst Operand) []Instruction {
+	switch operator {
+	case parser.UnopNegate:
+		// xorpd needs its memory operand 16-byte aligned
+		return []Instruction{
+			&Mov{Type: Double, Src: src, Dst: dst},
+			&Binary{Type: Double, Operator: opXor, Operand1: g.doubleConstant(math.Copysign(0, -1), 16), Operand2: dst},
+		}
+	case parser.UnopNot:
+		zero := &Reg{Reg: regXMM0}
+		end := g.makeLabel("not_double.end")
+		// NaN compares unordered with zero and counts as true, so !NaN is 0
+		return []Instruction{
+			&Binary{Type: Double, Operator: opXor, Operand1: zero, Operand2: zero},
+			&Cmp{Type: Double, Operand1: src, Operand2: zero},
+			&Mov{Type: Longword, Src: &Imn{Val: 0}, Dst: dst},
+			&JmpCC{Condition: CondP, Identifier: end},
+			&SetCC{Condition: CondE, Operand: dst},
+			&Label{Identifier: end},
+		}
+	default:
+		panic("invalid unary operation on a double")
+	}
+}
+
+// doubleBinary lowers a binary operation on doubles
+func (g *AsmGenerator) doubleBinary(node *ir.BinaryInstr) []Instruction {
+	src1 := g.convertOperand(node.Src1)
+	src2 := g.convertOperand(node.Src2)
 	dst := g.convertOperand(node.Dst)
-	return &Mov{Src: src, Dst: dst}
+
+	var op BinaryOp
+	switch node.Operator {
+	case parser.BinopAdd, parser.BinopSubtract, parser.BinopMultiply:
+		op = convertBinOp(node.Operator)
+	case parser.BinopDivide:
+		op = opDivDouble
+	default:
+		return g.doubleComparison(node.Operator, src1, src2, dst)
+	}
+	return []Instruction{&Mov{Type: Double, Src: src1, Dst: dst}, &Binary{Type: Double, Operator: op, Operand1: src2, Operand2: dst}}
+}
+
+// doubleComparison lowers a relational operation on doubles. comisd sets CF, ZF and PF together for NaN,
+// so less-than tests swap their operands to use A/AE, which are false for NaN, and equality tests check PF.
+func (g *AsmGenerator) doubleComparison(operator parser.BinopType, src1, src2, dst Operand) []Instruction {
+	zeroDst := &Mov{Type: Longword, Src: &Imn{Val: 0}, Dst: dst}
+	switch operator {
+	case parser.BinopGreaterThan:
+		return []Instruction{&Cmp{Type: Double, Operand1: src2, Operand2: src1}, zeroDst, &SetCC{Condition: CondA, Operand: dst}}
+	case parser.BinopGreaterOrEqual:
+		return []Instruction{&Cmp{Type: Double, Operand1: src2, Operand2: src1}, zeroDst, &SetCC{Condition: CondAE, Operand: dst}}
+	case parser.BinopLessThan:
+		return []Instruction{&Cmp{Type: Double, Operand1: src1, Operand2: src2}, zeroDst, &SetCC{Condition: CondA, Operand: dst}}
+	case parser.BinopLessOrEqual:
+		return []Instruction{&Cmp{Type: Double, Operand1: src1, Operand2: src2}, zeroDst, &SetCC{Condition: CondAE, Operand: dst}}
+	case parser.BinopEqual:
+		end := g.makeLabel("cmp_double.end")
+		return []Instruction{
+			&Cmp{Type: Double, Operand1: src2, Operand2: src1},
+			zeroDst,
+			&JmpCC{Condition: CondP, Identifier: end},
+			&SetCC{Condition: CondE, Operand: dst},
+			&Label{Identifier: end},
+		}
+	case parser.BinopNotEqual:
+		end := g.makeLabel("cmp_double.end")
+		return []Instruction{
+			&Cmp{Type: Double, Operand1: src2, Operand2: src1},
+			&Mov{Type: Longword, Src: &Imn{Val: 1}, Dst: dst},
+			&JmpCC{Condition: CondP, Identifier: end},
+			&SetCC{Condition: CondNE, Operand: dst},
+			&Label{Identifier: end},
+		}
+	default:
+		panic("invalid binary operation on doubles")
+	}
+}
+
+func (g *AsmGenerator) VisitCopyInstr(node *ir.CopyInstr) any {
+	return g.copyValue(node.Src, g.convertOperand(node.Src), g.convertOperand(node.Dst))
+}
+
+// copyValue moves value from src to dst, a byte at a time if it's a struct
+func (g *AsmGenerator) copyValue(value ir.Value, src, dst Operand) []Instruction {
+	if t, isStruct := g.valueType(value).(types.Structure); isStruct {
+		return copyBytes(src, dst, types.Size(t))
+	}
+	return []Instruction{&Mov{Type: g.operandType(value), Src: src, Dst: dst}}
+}
+
+// copyBytes copies size bytes from src to dst, eight or four at a time where possible
+func copyBytes(src, dst Operand, size int) []Instruction {
+	var instructions []Instruction
+	for offset := 0; offset < size; {
+		t, chunk := Byte, 1
+		switch {
+		case size-offset >= 8:
+			t, chunk = Quadword, 8
+		case size-offset >= 4:
+			t, chunk = Longword, 4
+		}
+		instructions = append(instructions, &Mov{Type: t, Src: offsetOperand(src, offset), Dst: offsetOperand(dst, offset)})
+		offset += chunk
+	}
+	return instructions
+}
+
+// offsetOperand returns the memory operand offset bytes past op
+func offsetOperand(op Operand, offset int) Operand {
+	switch op := op.(type) {
+	case *Pseudo:
+		return &PseudoMem{Identifier: op.Identifier, Offset: offset}
+	case *PseudoMem:
+		return &PseudoMem{Identifier: op.Identifier, Offset: op.Offset + offset}
+	case *Stack:
+		return &Stack{Val: op.Val + offset}
+	case *Memory:
+		return &Memory{Reg: op.Reg, Offset: op.Offset + offset}
+	case *Data:
+		return &Data{Identifier: op.Identifier, Local: op.Local, Offset: op.Offset + offset}
+	default:
+		panic(fmt.Sprintf("operand %T has no offset", op))
+	}
 }
 func (g *AsmGenerator) VisitJumpInstr(node *ir.JumpInstr) any {
 	return &Jmp{Identifier: node.Identifier}
 }
 func (g *AsmGenerator) VisitJumpIfZeroInstr(node *ir.JumpIfZeroInstr) any {
-	return []Instruction{&Cmp{Operand1: &Imn{Val: 0}, Operand2: g.convertOperand(node.Condition)}, &JmpCC{Condition: CondE, Identifier: node.Target}}
+	if g.operandType(node.Condition) == Double {
+		// NaN is nonzero, so an unordered result must not take the jump
+		skip := g.makeLabel("jump_double.skip")
+		return append(g.compareDoubleToZero(node.Condition),
+			&JmpCC{Condition: CondP, Identifier: skip},
+			&JmpCC{Condition: CondE, Identifier: node.Target},
+			&Label{Identifier: skip},
+		)
+	}
+	cmp := &Cmp{Type: g.operandType(node.Condition), Operand1: &Imn{Val: 0}, Operand2: g.convertOperand(node.Condition)}
+	return []Instruction{cmp, &JmpCC{Condition: CondE, Identifier: node.Target}}
 }
 func (g *AsmGenerator) VisitJumpIfNotZeroInstr(node *ir.JumpIfNotZeroInstr) any {
-	return []Instruction{&Cmp{Operand1: &Imn{Val: 0}, Operand2: g.convertOperand(node.Condition)}, &JmpCC{Condition: CondNE, Identifier: node.Target}}
+	if g.operandType(node.Condition) == Double {
+		return append(g.compareDoubleToZero(node.Condition),
+			&JmpCC{Condition: CondP, Identifier: node.Target},
+			&JmpCC{Condition: CondNE, Identifier: node.Target},
+		)
+	}
+	cmp := &Cmp{Type: g.operandType(node.Condition), Operand1: &Imn{Val: 0}, Operand2: g.convertOperand(node.Condition)}
+	return []Instruction{cmp, &JmpCC{Condition: CondNE, Identifier: node.Target}}
 }
+func (g *AsmGenerator) compareDoubleToZero(value ir.Value) []Instruction {
+	zero := &Reg{Reg: regXMM0}
+	return []Instruction{
+		&Binary{Type: Double, Operator: opXor, Operand1: zero, Operand2: zero},
+		&Cmp{Type: Double, Operand1: g.convertOperand(value), Operand2: zero},
+	}
+}
+
 func (g *AsmGenerator) VisitLabelInstr(node *ir.LabelInstr) any {
 	return &Label{Identifier: node.Identifier}
 }
 
+// VisitJumpTableInstr loads the target's offset from the table, adds it to the table's address and
+// jumps there. The offsets are relative so the code stays position independent.
+func (g *AsmGenerator) VisitJumpTableInstr(node *ir.JumpTableInstr) any {
+	table := &JumpTable{Name: g.makeLabel("jump_table"), Targets: node.Targets}
+	g.jumpTables = append(g.jumpTables, table)
+	return []Instruction{
+		&Mov{Type: Quadword, Src: g.convertOperand(node.Index), Dst: &Reg{Reg: regR10}},
+		&Lea{Src: &Data{Identifier: table.Name, Local: true}, Dst: &Reg{Reg: regR11}},
+		&Movsx{SrcType: Longword, DstType: Quadword, Src: &Indexed{Base: regR11, Index: regR10, Scale: 4}, Dst: &Reg{Reg: regR10}},
+		&Binary{Type: Quadword, Operator: opAdd, Operand1: &Reg{Reg: regR11}, Operand2: &Reg{Reg: regR10}},
+		&JmpIndirect{Operand: &Reg{Reg: regR10}},
+	}
+}
+
+func (g *AsmGenerator) VisitFunCallInstr(node *ir.FunCallInstr) any {
+	instructions := []Instruction{}
+
+	// The caller provides the memory a struct too big for registers is returned in
+	returnInMemory := node.Dst != nil && returnsInMemory(g.valueType(node.Dst))
+	intRegisters := argRegisters
+	if returnInMemory {
+		instructions = append(instructions, &Lea{Src: g.convertOperand(node.Dst), Dst: &Reg{Reg: regDI}})
+		intRegisters = argRegisters[1:]
+	}
+
+	intArgs, doubleArgs, stackArgs := g.classifyArgs(node.Args, returnInMemory)
+
+	// Keep the stack 16-byte aligned at the call instruction
+	stackPadding := 0
+	if len(stackArgs)%2 != 0 {
+		stackPadding = 8
+		instructions = append(instructions, &AllocateStack{Val: stackPadding})
+	}
+
+	for i, arg := range intArgs {
+		instructions = append(instructions, moveToRegister(arg, intRegisters[i])...)
+	}
+	for i, arg := range doubleArgs {
+		instructions = append(instructions, moveToRegister(arg, doubleArgRegisters[i])...)
+	}
+
+	// Stack arguments are pushed in reverse order
+	for i := len(stackArgs) - 1; i >= 0; i-- {
+		instructions = append(instructions, pushArg(stackArgs[i])...)
+	}
+
+	// A variadic function is told in al how many vector registers hold arguments, so that its prologue
+	// knows whether it needs to save them
+	if symbol, ok := g.symbols.Get(node.Identifier); ok && symbol.Type.(types.FunType).Variadic {
+		instructions = append(instructions, &Mov{Type: Longword, Src: &Imn{Val: int64(len(doubleArgs))}, Dst: &Reg{Reg: regAX}})
+	}
+	instructions = append(instructions, &Call{Identifier: node.Identifier, External: !g.definedFunctions[node.Identifier]})
+
+	bytesToRemove := 8*len(stackArgs) + stackPadding
+	if bytesToRemove != 0 {
+		instructions = append(instructions, &DeallocateStack{Val: bytesToRemove})
+	}
+
+	if node.Dst == nil || returnInMemory {
+		return instructions
+	}
+	intParts, doubleParts := g.returnParts(node.Dst)
+	for i, part := range intParts {
+		instructions = append(instructions, moveFromRegister(returnRegisters[i], part)...)
+	}
+	for i, part := range doubleParts {
+		instructions = append(instructions, moveFromRegister(doubleReturnRegisters[i], part)...)
+	}
+	return instructions
+}
+
+func (g *AsmGenerator) VisitSignExtendInstr(node *ir.SignExtendInstr) any {
+	return &Movsx{SrcType: g.operandType(node.Src), DstType: g.operandType(node.Dst), Src: g.convertOperand(node.Src), Dst: g.convertOperand(node.Dst)}
+}
+
+func (g *AsmGenerator) VisitZeroExtendInstr(node *ir.ZeroExtendInstr) any {
+	return &MovZeroExtend{SrcType: g.operandType(node.Src), DstType: g.operandType(node.Dst), Src: g.convertOperand(node.Src), Dst: g.convertOperand(node.Dst)}
+}
+
+func (g *AsmGenerator) VisitTruncateInstr(node *ir.TruncateInstr) any {
+	// Moving the low bytes is all a truncation needs
+	return &Mov{Type: g.operandType(node.Dst), Src: g.convertOperand(node.Src), Dst: g.convertOperand(node.Dst)}
+}
+
+func (g *AsmGenerator) VisitIntToDoubleInstr(node *ir.IntToDoubleInstr) any {
+	src := g.convertOperand(node.Src)
+	dst := g.convertOperand(node.Dst)
+
+	// cvtsi2sd has no byte form, so characters are sign extended first
+	if g.operandType(node.Src) == Byte {
+		return []Instruction{
+			&Movsx{SrcType: Byte, DstType: Longword, Src: src, Dst: &Reg{Reg: regAX}},
+			&Cvtsi2sd{Type: Longword, Src: &Reg{Reg: regAX}, Dst: dst},
+		}
+	}
+	return []Instruction{&Cvtsi2sd{Type: g.operandType(node.Src), Src: src, Dst: dst}}
+}
+
+func (g *AsmGenerator) VisitDoubleToIntInstr(node *ir.DoubleToIntInstr) any {
+	src := g.convertOperand(node.Src)
+	dst := g.convertOperand(node.Dst)
+
+	// cvttsd2si has no byte form, so convert to an int and keep the low byte
+	if g.operandType(node.Dst) == Byte {
+		return []Instruction{
+			&Cvttsd2si{Type: Longword, Src: src, Dst: &Reg{Reg: regAX}},
+			&Mov{Type: Byte, Src: &Reg{Reg: regAX}, Dst: dst},
+		}
+	}
+	return []Instruction{&Cvttsd2si{Type: g.operandType(node.Dst), Src: src, Dst: dst}}
+}
+
+func (g *AsmGenerator) VisitUIntToDoubleInstr(node *ir.UIntToDoubleInstr) any {
+	src := g.convertOperand(node.Src)
+	dst := g.convertOperand(node.Dst)
+
+	// Every unsigned char fits in a signed int, so zero extend it and convert that
+	if g.operandType(node.Src) == Byte {
+		return []Instruction{
+			&MovZeroExtend{SrcType: Byte, DstType: Longword, Src: src, Dst: &Reg{Reg: regAX}},
+			&Cvtsi2sd{Type: Longword, Src: &Reg{Reg: regAX}, Dst: dst},
+		}
+	}
+
+	// Every unsigned int fits in a signed long, so zero extend it and convert that
+	if g.operandType(node.Src) == Longword {
+		return []Instruction{
+			&MovZeroExtend{SrcType: Longword, DstType: Quadword, Src: src, Dst: &Reg{Reg: regAX}},
+			&Cvtsi2sd{Type: Quadword, Src: &Reg{Reg: regAX}, Dst: dst},
+		}
+	}
+
+	// Values with the top bit set are halved before converting and doubled after. The low bit is
+	// ORed back in after halving so the result still rounds the same way.
+	outOfRange := g.makeLabel("ulong_to_double.out_of_range")
+	end := g.makeLabel("ulong_to_double.end")
+	return []Instruction{
+		&Cmp{Type: Quadword, Operand1: &Imn{Val: 0}, Operand2: src},
+		&JmpCC{Condition: CondL, Identifier: outOfRange},
+		&Cvtsi2sd{Type: Quadword, Src: src, Dst: dst},
+		&Jmp{Identifier: end},
+		&Label{Identifier: outOfRange},
+		&Mov{Type: Quadword, Src: src, Dst: &Reg{Reg: regAX}},
+		&Mov{Type: Quadword, Src: &Reg{Reg: regAX}, Dst: &Reg{Reg: regDX}},
+		&Binary{Type: Quadword, Operator: opShr, Operand1: &Imn{Val: 1}, Operand2: &Reg{Reg: regDX}},
+		&Binary{Type: Quadword, Operator: opAnd, Operand1: &Imn{Val: 1}, Operand2: &Reg{Reg: regAX}},
+		&Binary{Type: Quadword, Operator: opOr, Operand1: &Reg{Reg: regAX}, Operand2: &Reg{Reg: regDX}},
+		&Cvtsi2sd{Type: Quadword, Src: &Reg{Reg: regDX}, Dst: dst},
+		&Binary{Type: Double, Operator: opAdd, Operand1: dst, Operand2: dst},
+		&Label{Identifier: end},
+	}
+}
+
+func (g *AsmGenerator) VisitDoubleToUIntInstr(node *ir.DoubleToUIntInstr) any {
+	src := g.convertOperand(node.Src)
+	dst := g.convertOperand(node.Dst)
+
+	// Every unsigned char fits in a signed int, so convert to that and keep the low byte
+	if g.operandType(node.Dst) == Byte {
+		return []Instruction{
+			&Cvttsd2si{Type: Longword, Src: src, Dst: &Reg{Reg: regAX}},
+			&Mov{Type: Byte, Src: &Reg{Reg: regAX}, Dst: dst},
+		}
+	}
+
+	// Every unsigned int fits in a signed long, so convert to that and keep the low half
+	if g.operandType(node.Dst) == Longword {
+		return []Instruction{
+			&Cvttsd2si{Type: Quadword, Src: src, Dst: &Reg{Reg: regAX}},
+			&Mov{Type: Longword, Src: &Reg{Reg: regAX}, Dst: dst},
+		}
+	}
+
+	// Values too big for a signed long have 2^63 subtracted before converting and added back after
+	upperBound := g.doubleConstant(math.Exp2(63), 8)
+	outOfRange := g.makeLabel("double_to_ulong.out_of_range")
+	end := g.makeLabel("double_to_ulong.end")
+	return []Instruction{
+		&Cmp{Type: Double, Operand1: upperBound, Operand2: src},
+		&JmpCC{Condition: CondAE, Identifier: outOfRange},
+		&Cvttsd2si{Type: Quadword, Src: src, Dst: dst},
+		&Jmp{Identifier: end},
+		&Label{Identifier: outOfRange},
+		&Mov{Type: Double, Src: src, Dst: &Reg{Reg: regXMM1}},
+		&Binary{Type: Double, Operator: opSub, Operand1: upperBound, Operand2: &Reg{Reg: regXMM1}},
+		&Cvttsd2si{Type: Quadword, Src: &Reg{Reg: regXMM1}, Dst: dst},
+		&Binary{Type: Quadword, Operator: opAdd, Operand1: &Imn{Val: math.MinInt64}, Operand2: dst},
+		&Label{Identifier: end},
+	}
+}
+
+func (g *AsmGenerator) VisitGetAddressInstr(node *ir.GetAddressInstr) any {
+	return &Lea{Src: g.convertOperand(node.Src), Dst: g.convertOperand(node.Dst)}
+}
+
+func (g *AsmGenerator) VisitLoadInstr(node *ir.LoadInstr) any {
+	instructions := []Instruction{&Mov{Type: Quadword, Src: g.convertOperand(node.SrcPtr), Dst: &Reg{Reg: regAX}}}
+	return append(instructions, g.copyValue(node.Dst, &Memory{Reg: regAX, Offset: 0}, g.convertOperand(node.Dst))...)
+}
+
+func (g *AsmGenerator) VisitStoreInstr(node *ir.StoreInstr) any {
+	instructions := []Instruction{&Mov{Type: Quadword, Src: g.convertOperand(node.DstPtr), Dst: &Reg{Reg: regAX}}}
+	return append(instructions, g.copyValue(node.Src, g.convertOperand(node.Src), &Memory{Reg: regAX, Offset: 0})...)
+}
+
+func (g *AsmGenerator) VisitCopyToOffsetInstr(node *ir.CopyToOffsetInstr) any {
+	dst := offsetOperand(g.convertOperand(&ir.Variable{Identifier: node.Dst}), node.Offset)
+	return g.copyValue(node.Src, g.convertOperand(node.Src), dst)
+}
+
+func (g *AsmGenerator) VisitCopyFromOffsetInstr(node *ir.CopyFromOffsetInstr) any {
+	src := offsetOperand(g.convertOperand(&ir.Variable{Identifier: node.Src}), node.Offset)
+	return g.copyValue(node.Dst, src, g.convertOperand(node.Dst))
+}
+
+func (g *AsmGenerator) VisitAddPtrInstr(node *ir.AddPtrInstr) any {
+	instructions := []Instruction{&Mov{Type: Quadword, Src: g.convertOperand(node.Ptr), Dst: &Reg{Reg: regAX}}}
+	dst := g.convertOperand(node.Dst)
+
+	// A constant index folds into the displacement
+	if index, ok := node.Index.(*ir.Constant); ok {
+		offset := int(types.Int64(index.Value)) * node.Scale
+		return append(instructions, &Lea{Src: &Memory{Reg: regAX, Offset: offset}, Dst: dst})
+	}
+
+	instructions = append(instructions, &Mov{Type: Quadword, Src: g.convertOperand(node.Index), Dst: &Reg{Reg: regDX}})
+	switch node.Scale {
+	case 1, 2, 4, 8:
+		return append(instructions, &Lea{Src: &Indexed{Base: regAX, Index: regDX, Scale: node.Scale}, Dst: dst})
+	default:
+		return append(instructions,
+			&Binary{Type: Quadword, Operator: opMult, Operand1: &Imn{Val: int64(node.Scale)}, Operand2: &Reg{Reg: regDX}},
+			&Lea{Src: &Indexed{Base: regAX, Index: regDX, Scale: 1}, Dst: dst})
+	}
+}
+
 func (g *AsmGenerator) VisitConstant(node *ir.Constant) any {
-	return &Imn{Val: node.Value}
+	// There are no immediate doubles, so they're read from .rodata instead
+	if d, ok := node.Value.(types.ConstDouble); ok {
+		return g.doubleConstant(d.Value, 8)
+	}
+	return &Imn{Val: types.Int64(node.Value)}
 }
 
 func (g *AsmGenerator) VisitVariable(node *ir.Variable) any {
+	if symbol, ok := g.symbols.Get(node.Identifier); ok {
+		if _, isConstant := symbol.Attrs.(symbols.ConstantAttrs); isConstant {
+			return &Data{Identifier: node.Identifier, Local: true}
+		}
+	}
+	if g.symbols.IsStatic(node.Identifier) {
+		return &Data{Identifier: node.Identifier}
+	}
 	return &Pseudo{Identifier: node.Identifier}
 }
 
 func (g *AsmGenerator) convertOperand(node ir.Value) Operand {
 	switch op := node.(type) {
 	case *ir.Constant:
-		return op.Accept(g).(*Imn)
+		return op.Accept(g).(Operand)
 
 	case *ir.Variable:
-		return op.Accept(g).(*Pseudo)
+		return op.Accept(g).(Operand)
 	default:
 		panic(fmt.Sprintf("invalid operand type: %T", node))
 	}
 }
 
+// valueType returns the C type of a TAC value
+func (g *AsmGenerator) valueType(node ir.Value) types.Type {
+	switch op := node.(type) {
+	case *ir.Constant:
+		return op.Value.Type()
+	case *ir.Variable:
+		symbol, ok := g.symbols.Get(op.Identifier)
+		if !ok {
+			panic(fmt.Sprintf("no type for variable %s", op.Identifier))
+		}
+		return symbol.Type
+	default:
+		panic(fmt.Sprintf("invalid operand type: %T", node))
+	}
+}
+
+// operandType returns the assembly type of a TAC value
+func (g *AsmGenerator) operandType(node ir.Value) AsmType {
+	return asmType(g.valueType(node))
+}
+
+// isSigned reports whether a TAC value has a signed type
+func (g *AsmGenerator) isSigned(node ir.Value) bool {
+	return types.IsSigned(g.valueType(node))
+}
+
+func asmType(t types.Type) AsmType {
+	switch t.(type) {
+	case types.Char, types.SChar, types.UChar:
+		return Byte
+	case types.Int, types.UInt:
+		return Longword
+	case types.Long, types.ULong, types.Pointer:
+		return Quadword
+	case types.Double:
+		return Double
+	default:
+		panic("type has no assembly type: " + t.String())
+	}
+}
+
 func convertUnOp(n parser.UnopType) UnaryOp {
 	switch n {
 	case parser.UnopBitwiseComp:
@@ -206,6 +841,12 @@ func convertBinOp(n parser.BinopType) BinaryOp {
 		return opSub
 	case parser.BinopMultiply:
 		return opMult
+	case parser.BinopBitwiseAnd:
+		return opAnd
+	case parser.BinopBitwiseOr:
+		return opOr
+	case parser.BinopBitwiseXor:
+		return opXor
 	default:
 		panic("invalid binary operation type")
 	}
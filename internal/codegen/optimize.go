@@ -1,269 +1,394 @@
 package codegen
 
+import (
+	"acc/internal/common/symbols"
+	"acc/internal/common/types"
+	"fmt"
+	"math"
+)
+
 type stackAllocator struct {
 	Variables    map[string]int
 	CurrentIndex int
+	symbols      *symbols.Table
 }
 
 func (g *AsmGenerator) FixInstructions() {
+	for _, item := range g.Program.TopLevel {
+		if fn, ok := item.(*Function); ok {
+			g.fixFunction(fn)
+		}
+	}
+}
+
+func (g *AsmGenerator) fixFunction(fn *Function) {
 	stackAllocator := &stackAllocator{
 		Variables:    make(map[string]int),
-		CurrentIndex: 4,
+		CurrentIndex: 0,
+		symbols:      g.symbols,
 	}
 
-	for i := 0; i < len(g.Program.Function.Instructions); i++ {
-		inst := g.Program.Function.Instructions[i]
+	for _, inst := range fn.Instructions {
+		stackAllocator.replacePseudos(inst)
+	}
 
+	var instructions []Instruction
+	for _, inst := range fn.Instructions {
 		switch inst := inst.(type) {
 		case *Mov:
-			i += g.fixMovInstruction(inst, i, stackAllocator)
-		case *Unary:
-			g.fixUnaryInstruction(inst, i, stackAllocator)
+			instructions = append(instructions, fixMovInstruction(inst)...)
+		case *Movsx:
+			instructions = append(instructions, fixMovsxInstruction(inst)...)
 		case *Binary:
-			i += g.fixBinaryInstruction(inst, i, stackAllocator)
+			instructions = append(instructions, fixBinaryInstruction(inst)...)
 		case *Idiv:
-			i += g.fixIdivInstruction(inst, i, stackAllocator)
+			instructions = append(instructions, fixIdivInstruction(inst)...)
+		case *Div:
+			instructions = append(instructions, fixDivInstruction(inst)...)
+		case *MovZeroExtend:
+			instructions = append(instructions, fixMovZeroExtendInstruction(inst)...)
 		case *Cmp:
-			i += g.fixCmpInstruction(inst, i, stackAllocator)
-		case *SetCC:
-			g.fixSetCCInstruction(inst, i, stackAllocator)
+			instructions = append(instructions, fixCmpInstruction(inst)...)
+		case *Push:
+			instructions = append(instructions, fixPushInstruction(inst)...)
+		case *Cvttsd2si:
+			instructions = append(instructions, fixCvttsd2siInstruction(inst)...)
+		case *Cvtsi2sd:
+			instructions = append(instructions, fixCvtsi2sdInstruction(inst)...)
+		case *Lea:
+			instructions = append(instructions, fixLeaInstruction(inst)...)
+		default:
+			instructions = append(instructions, inst)
 		}
 	}
 
-	// Insert stack allocation instruction at the beginning
-	g.Program.Function.Instructions = append(
-		[]Instruction{&AllocateStack{Val: stackAllocator.CurrentIndex}},
-		g.Program.Function.Instructions...,
+	// Insert stack allocation instruction at the beginning, keeping rsp 16-byte aligned
+	stackSize := (stackAllocator.CurrentIndex + 15) / 16 * 16
+	fn.Instructions = append(
+		[]Instruction{&AllocateStack{Val: stackSize}},
+		instructions...,
 	)
 }
 
 func (sa *stackAllocator) allocateVar(identifier string) *Stack {
 	if val, exists := sa.Variables[identifier]; exists {
-		return &Stack{Val: val}
+		return &Stack{Val: -val}
+	}
+
+	symbol, ok := sa.symbols.Get(identifier)
+	if !ok {
+		panic(fmt.Sprintf("no type for pseudoregister %s", identifier))
 	}
 
-	stack := &Stack{Val: sa.CurrentIndex}
+	// CurrentIndex counts the bytes already in use below rbp
+	size := types.Size(symbol.Type)
+	alignment := types.VarAlignment(symbol.Type)
+	sa.CurrentIndex = (sa.CurrentIndex + size + alignment - 1) / alignment * alignment
 	sa.Variables[identifier] = sa.CurrentIndex
-	sa.CurrentIndex += 4
-	return stack
+	return &Stack{Val: -sa.CurrentIndex}
 }
 
-func (g *AsmGenerator) fixMovInstruction(inst *Mov, index int, sa *stackAllocator) int {
-	// Replace pseudoregisters
-	modified := false
-
-	// Handle source operand
-	if src, ok := inst.Src.(*Pseudo); ok {
-		inst.Src = sa.allocateVar(src.Identifier)
-		modified = true
-	}
-
-	// Handle destination operand
-	if dst, ok := inst.Dst.(*Pseudo); ok {
-		inst.Dst = sa.allocateVar(dst.Identifier)
-		modified = true
-	}
-
-	if modified {
-		g.Program.Function.Instructions[index] = inst
-	}
-
-	// Handle stack-to-stack moves
-	if _, srcIsStack := inst.Src.(*Stack); srcIsStack {
-		if _, dstIsStack := inst.Dst.(*Stack); dstIsStack {
-			// Replace with two instructions using temporary register
-			g.Program.Function.Instructions[index] = &Mov{
-				Src: inst.Src,
-				Dst: &Reg{Reg: regR10},
-			}
-
-			g.Program.Function.Instructions = append(
-				g.Program.Function.Instructions[:index+1],
-				append(
-					[]Instruction{
-						&Mov{
-							Src: &Reg{Reg: regR10},
-							Dst: inst.Dst,
-						},
-					},
-					g.Program.Function.Instructions[index+1:]...,
-				)...,
-			)
-			return 1 // Indicate that extra instruction was added
-		}
+// replace swaps a pseudoregister operand for its stack slot
+func (sa *stackAllocator) replace(op Operand) Operand {
+	switch op := op.(type) {
+	case *Pseudo:
+		return sa.allocateVar(op.Identifier)
+	case *PseudoMem:
+		return &Stack{Val: sa.allocateVar(op.Identifier).Val + op.Offset}
+	default:
+		return op
+	}
+}
+
+// replacePseudos assigns a stack slot to every pseudoregister inst uses
+func (sa *stackAllocator) replacePseudos(inst Instruction) {
+	switch inst := inst.(type) {
+	case *Mov:
+		inst.Src = sa.replace(inst.Src)
+		inst.Dst = sa.replace(inst.Dst)
+	case *Movsx:
+		inst.Src = sa.replace(inst.Src)
+		inst.Dst = sa.replace(inst.Dst)
+	case *Lea:
+		inst.Src = sa.replace(inst.Src)
+		inst.Dst = sa.replace(inst.Dst)
+	case *Unary:
+		inst.Operand = sa.replace(inst.Operand)
+	case *Binary:
+		inst.Operand1 = sa.replace(inst.Operand1)
+		inst.Operand2 = sa.replace(inst.Operand2)
+	case *Cmp:
+		inst.Operand1 = sa.replace(inst.Operand1)
+		inst.Operand2 = sa.replace(inst.Operand2)
+	case *Idiv:
+		inst.Operand = sa.replace(inst.Operand)
+	case *Div:
+		inst.Operand = sa.replace(inst.Operand)
+	case *MovZeroExtend:
+		inst.Src = sa.replace(inst.Src)
+		inst.Dst = sa.replace(inst.Dst)
+	case *SetCC:
+		inst.Operand = sa.replace(inst.Operand)
+	case *Push:
+		inst.Operand = sa.replace(inst.Operand)
+	case *Cvttsd2si:
+		inst.Src = sa.replace(inst.Src)
+		inst.Dst = sa.replace(inst.Dst)
+	case *Cvtsi2sd:
+		inst.Src = sa.replace(inst.Src)
+		inst.Dst = sa.replace(inst.Dst)
 	}
+}
 
-	return 0
+// isMemory reports whether op refers to memory rather than a register or immediate
+func isMemory(op Operand) bool {
+	switch op.(type) {
+	case *Stack, *Memory, *Indexed, *Data:
+		return true
+	default:
+		return false
+	}
 }
 
-func (g *AsmGenerator) fixUnaryInstruction(inst *Unary, index int, sa *stackAllocator) {
-	// Replace pseudoregisters
-	if operand, ok := inst.Operand.(*Pseudo); ok {
-		inst.Operand = sa.allocateVar(operand.Identifier)
-		g.Program.Function.Instructions[index] = inst
+// scratchRegister returns the register used to stage a source operand of type t
+func scratchRegister(t AsmType) *Reg {
+	if t == Double {
+		return &Reg{Reg: regXMM14}
 	}
+	return &Reg{Reg: regR10}
+}
+
+func isRegister(op Operand) bool {
+	_, ok := op.(*Reg)
+	return ok
 }
 
-func (g *AsmGenerator) fixBinaryInstruction(inst *Binary, index int, sa *stackAllocator) int {
-	// Replace pseudoregisters
-	if operand1, ok := inst.Operand1.(*Pseudo); ok {
-		inst.Operand1 = sa.allocateVar(operand1.Identifier)
-		g.Program.Function.Instructions[index] = inst
+// isLargeImmediate reports whether op is an immediate that doesn't fit in a sign-extended 32-bit field
+func isLargeImmediate(op Operand) bool {
+	imn, ok := op.(*Imn)
+	return ok && (imn.Val > math.MaxInt32 || imn.Val < math.MinInt32)
+}
+
+func fixMovInstruction(inst *Mov) []Instruction {
+	// Only the low bytes of an immediate are used by movl and movb
+	if imn, ok := inst.Src.(*Imn); ok {
+		switch inst.Type {
+		case Byte:
+			inst.Src = &Imn{Val: int64(int8(imn.Val))}
+		case Longword:
+			inst.Src = &Imn{Val: int64(int32(imn.Val))}
+		}
 	}
 
-	if operand2, ok := inst.Operand2.(*Pseudo); ok {
-		inst.Operand2 = sa.allocateVar(operand2.Identifier)
-		g.Program.Function.Instructions[index] = inst
+	// A 64-bit immediate can only be moved into a register (movabsq)
+	if isLargeImmediate(inst.Src) && isMemory(inst.Dst) {
+		return []Instruction{
+			&Mov{Type: inst.Type, Src: inst.Src, Dst: &Reg{Reg: regR10}},
+			&Mov{Type: inst.Type, Src: &Reg{Reg: regR10}, Dst: inst.Dst},
+		}
 	}
 
 	// Can't have mem address as both src and dst
-	if _, dstIsOp := inst.Operand2.(*Stack); dstIsOp {
+	if isMemory(inst.Src) && isMemory(inst.Dst) {
+		scratch := scratchRegister(inst.Type)
+		return []Instruction{
+			&Mov{Type: inst.Type, Src: inst.Src, Dst: scratch},
+			&Mov{Type: inst.Type, Src: scratch, Dst: inst.Dst},
+		}
+	}
+
+	return []Instruction{inst}
+}
+
+func fixMovsxInstruction(inst *Movsx) []Instruction {
+	var instructions []Instruction
+	src, dst := inst.Src, inst.Dst
+
+	// movsx can't take an immediate source or a memory destination
+	if _, ok := src.(*Imn); ok {
+		instructions = append(instructions, fixMovInstruction(&Mov{Type: inst.SrcType, Src: src, Dst: &Reg{Reg: regR10}})...)
+		src = &Reg{Reg: regR10}
+	}
+	if isMemory(dst) {
+		instructions = append(instructions, &Movsx{SrcType: inst.SrcType, DstType: inst.DstType, Src: src, Dst: &Reg{Reg: regR11}})
+		return append(instructions, &Mov{Type: inst.DstType, Src: &Reg{Reg: regR11}, Dst: dst})
+	}
+	return append(instructions, &Movsx{SrcType: inst.SrcType, DstType: inst.DstType, Src: src, Dst: dst})
+}
+
+func fixBinaryInstruction(inst *Binary) []Instruction {
+	var instructions []Instruction
+
+	// SSE arithmetic always writes to a register
+	if inst.Type == Double && !isRegister(inst.Operand2) {
+		return []Instruction{
+			&Mov{Type: Double, Src: inst.Operand2, Dst: &Reg{Reg: regXMM15}},
+			&Binary{Type: Double, Operator: inst.Operator, Operand1: inst.Operand1, Operand2: &Reg{Reg: regXMM15}},
+			&Mov{Type: Double, Src: &Reg{Reg: regXMM15}, Dst: inst.Operand2},
+		}
+	}
+
+	// add, sub and imul only take 32-bit immediates
+	if isLargeImmediate(inst.Operand1) {
+		instructions = append(instructions, &Mov{Type: inst.Type, Src: inst.Operand1, Dst: &Reg{Reg: regR10}})
+		inst.Operand1 = &Reg{Reg: regR10}
+	}
+
+	if isMemory(inst.Operand2) {
 		if inst.Operator == opMult {
 			// imul cant have mem address as dst, reguardless of source
-			g.Program.Function.Instructions[index] = &Mov{
-				Src: inst.Operand2,
-				Dst: &Reg{Reg: regR11},
-			}
-
-			g.Program.Function.Instructions = append(
-				g.Program.Function.Instructions[:index+1],
-				append(
-					[]Instruction{
-						&Binary{
-							Operator: inst.Operator,
-							Operand1: inst.Operand1,
-							Operand2: &Reg{Reg: regR11},
-						},
-						&Mov{Src: &Reg{Reg: regR11}, Dst: inst.Operand2},
-					},
-					g.Program.Function.Instructions[index+1:]...,
-				)...,
+			return append(instructions,
+				&Mov{Type: inst.Type, Src: inst.Operand2, Dst: &Reg{Reg: regR11}},
+				&Binary{Type: inst.Type, Operator: inst.Operator, Operand1: inst.Operand1, Operand2: &Reg{Reg: regR11}},
+				&Mov{Type: inst.Type, Src: &Reg{Reg: regR11}, Dst: inst.Operand2},
 			)
-			return 2
 		}
 
-		if _, srcIsOp := inst.Operand1.(*Stack); srcIsOp {
-			g.Program.Function.Instructions[index] = &Mov{
-				Src: inst.Operand1,
-				Dst: &Reg{Reg: regR10},
-			}
-
-			g.Program.Function.Instructions = append(
-				g.Program.Function.Instructions[:index+1],
-				append(
-					[]Instruction{
-						&Binary{
-							Operator: inst.Operator,
-							Operand1: &Reg{Reg: regR10},
-							Operand2: inst.Operand2,
-						},
-					},
-					g.Program.Function.Instructions[index+1:]...,
-				)...,
+		// Can't have mem address as both src and dst
+		if isMemory(inst.Operand1) {
+			return append(instructions,
+				&Mov{Type: inst.Type, Src: inst.Operand1, Dst: &Reg{Reg: regR10}},
+				&Binary{Type: inst.Type, Operator: inst.Operator, Operand1: &Reg{Reg: regR10}, Operand2: inst.Operand2},
 			)
-			return 1
 		}
 	}
 
-	return 0
+	return append(instructions, inst)
 }
 
-func (g *AsmGenerator) fixIdivInstruction(inst *Idiv, index int, sa *stackAllocator) int {
-	// Replace pseudoregisters
-	if operand, ok := inst.Operand.(*Pseudo); ok {
-		inst.Operand = sa.allocateVar(operand.Identifier)
-		g.Program.Function.Instructions[index] = inst
-		return 0
+func fixIdivInstruction(inst *Idiv) []Instruction {
+	// idiv can't operate on constants, copy value into scratch register
+	if _, ok := inst.Operand.(*Imn); ok {
+		return []Instruction{
+			&Mov{Type: inst.Type, Src: inst.Operand, Dst: &Reg{Reg: regR10}},
+			&Idiv{Type: inst.Type, Operand: &Reg{Reg: regR10}},
+		}
 	}
 
-	// idivl can't operate on constants, copy value into scratch register
-	if constant, ok := inst.Operand.(*Imn); ok {
-		g.Program.Function.Instructions[index] = &Mov{
-			Src: constant,
-			Dst: &Reg{Reg: regR10},
+	return []Instruction{inst}
+}
+
+func fixDivInstruction(inst *Div) []Instruction {
+	// div can't operate on constants either
+	if _, ok := inst.Operand.(*Imn); ok {
+		return []Instruction{
+			&Mov{Type: inst.Type, Src: inst.Operand, Dst: &Reg{Reg: regR10}},
+			&Div{Type: inst.Type, Operand: &Reg{Reg: regR10}},
 		}
+	}
 
-		g.Program.Function.Instructions = append(
-			g.Program.Function.Instructions[:index+1],
-			append(
-				[]Instruction{
-					&Idiv{
-						Operand: &Reg{Reg: regR10},
-					},
-				},
-				g.Program.Function.Instructions[index+1:]...,
-			)...,
-		)
-		return 1
+	return []Instruction{inst}
+}
+
+func fixMovZeroExtendInstruction(inst *MovZeroExtend) []Instruction {
+	if inst.SrcType == Byte {
+		var instructions []Instruction
+		src, dst := inst.Src, inst.Dst
+
+		// movzb can't take an immediate source or a memory destination either
+		if _, ok := src.(*Imn); ok {
+			instructions = append(instructions, fixMovInstruction(&Mov{Type: Byte, Src: src, Dst: &Reg{Reg: regR10}})...)
+			src = &Reg{Reg: regR10}
+		}
+		if isMemory(dst) {
+			instructions = append(instructions, &MovZeroExtend{SrcType: Byte, DstType: inst.DstType, Src: src, Dst: &Reg{Reg: regR11}})
+			return append(instructions, &Mov{Type: inst.DstType, Src: &Reg{Reg: regR11}, Dst: dst})
+		}
+		return append(instructions, &MovZeroExtend{SrcType: Byte, DstType: inst.DstType, Src: src, Dst: dst})
 	}
 
-	return 0
+	// movl into a register clears the upper four bytes, so that's all a zero extension needs
+	if _, ok := inst.Dst.(*Reg); ok {
+		return fixMovInstruction(&Mov{Type: Longword, Src: inst.Src, Dst: inst.Dst})
+	}
+	return []Instruction{
+		&Mov{Type: Longword, Src: inst.Src, Dst: &Reg{Reg: regR11}},
+		&Mov{Type: Quadword, Src: &Reg{Reg: regR11}, Dst: inst.Dst},
+	}
 }
 
-func (g *AsmGenerator) fixCmpInstruction(inst *Cmp, index int, sa *stackAllocator) int {
-	// Replace pseudoregisters
-	if operand1, ok := inst.Operand1.(*Pseudo); ok {
-		inst.Operand1 = sa.allocateVar(operand1.Identifier)
-		g.Program.Function.Instructions[index] = inst
+func fixCmpInstruction(inst *Cmp) []Instruction {
+	var instructions []Instruction
+
+	// comisd needs a register as its second operand
+	if inst.Type == Double {
+		if !isRegister(inst.Operand2) {
+			instructions = append(instructions, &Mov{Type: Double, Src: inst.Operand2, Dst: &Reg{Reg: regXMM15}})
+			inst.Operand2 = &Reg{Reg: regXMM15}
+		}
+		return append(instructions, inst)
 	}
 
-	if operand2, ok := inst.Operand2.(*Pseudo); ok {
-		inst.Operand2 = sa.allocateVar(operand2.Identifier)
-		g.Program.Function.Instructions[index] = inst
+	// cmp only takes a 32-bit immediate
+	if isLargeImmediate(inst.Operand1) {
+		instructions = append(instructions, &Mov{Type: inst.Type, Src: inst.Operand1, Dst: &Reg{Reg: regR10}})
+		inst.Operand1 = &Reg{Reg: regR10}
 	}
 
 	// Can't have mem address as both src and dst
-	if _, dstIsOp := inst.Operand2.(*Stack); dstIsOp {
-
-		if _, srcIsOp := inst.Operand1.(*Stack); srcIsOp {
-			g.Program.Function.Instructions[index] = &Mov{
-				Src: inst.Operand1,
-				Dst: &Reg{Reg: regR10},
-			}
-
-			g.Program.Function.Instructions = append(
-				g.Program.Function.Instructions[:index+1],
-				append(
-					[]Instruction{
-						&Cmp{
-							Operand1: &Reg{Reg: regR10},
-							Operand2: inst.Operand2,
-						},
-					},
-					g.Program.Function.Instructions[index+1:]...,
-				)...,
-			)
-			return 1
+	if isMemory(inst.Operand1) && isMemory(inst.Operand2) {
+		instructions = append(instructions, &Mov{Type: inst.Type, Src: inst.Operand1, Dst: &Reg{Reg: regR10}})
+		inst.Operand1 = &Reg{Reg: regR10}
+	}
+
+	// cmp cant have a constant as dst
+	if _, dstIsConst := inst.Operand2.(*Imn); dstIsConst {
+		instructions = append(instructions, &Mov{Type: inst.Type, Src: inst.Operand2, Dst: &Reg{Reg: regR11}})
+		inst.Operand2 = &Reg{Reg: regR11}
+	}
+
+	return append(instructions, inst)
+}
+
+func fixPushInstruction(inst *Push) []Instruction {
+	// pushq only takes a 32-bit immediate
+	if isLargeImmediate(inst.Operand) {
+		return []Instruction{
+			&Mov{Type: Quadword, Src: inst.Operand, Dst: &Reg{Reg: regR10}},
+			&Push{Operand: &Reg{Reg: regR10}},
 		}
-	} else if _, dstIsConst := inst.Operand2.(*Imn); dstIsConst {
-		// cmp cant have mem address as dst
-		g.Program.Function.Instructions[index] = &Mov{
-			Src: inst.Operand2,
-			Dst: &Reg{Reg: regR11},
+	}
+
+	return []Instruction{inst}
+}
+
+func fixCvttsd2siInstruction(inst *Cvttsd2si) []Instruction {
+	// cvttsd2si must write to a register
+	if !isRegister(inst.Dst) {
+		return []Instruction{
+			&Cvttsd2si{Type: inst.Type, Src: inst.Src, Dst: &Reg{Reg: regR11}},
+			&Mov{Type: inst.Type, Src: &Reg{Reg: regR11}, Dst: inst.Dst},
 		}
+	}
+
+	return []Instruction{inst}
+}
+
+func fixCvtsi2sdInstruction(inst *Cvtsi2sd) []Instruction {
+	var instructions []Instruction
 
-		g.Program.Function.Instructions = append(
-			g.Program.Function.Instructions[:index+1],
-			append(
-				[]Instruction{
-					&Cmp{
-						Operand1: inst.Operand1,
-						Operand2: &Reg{Reg: regR11},
-					},
-				},
-				g.Program.Function.Instructions[index+1:]...,
-			)...,
+	// cvtsi2sd can't convert an immediate, and must write to a register
+	if _, ok := inst.Src.(*Imn); ok {
+		instructions = append(instructions, &Mov{Type: inst.Type, Src: inst.Src, Dst: &Reg{Reg: regR10}})
+		inst.Src = &Reg{Reg: regR10}
+	}
+	if !isRegister(inst.Dst) {
+		return append(instructions,
+			&Cvtsi2sd{Type: inst.Type, Src: inst.Src, Dst: &Reg{Reg: regXMM15}},
+			&Mov{Type: Double, Src: &Reg{Reg: regXMM15}, Dst: inst.Dst},
 		)
-		return 1
 	}
 
-	return 0
+	return append(instructions, inst)
 }
 
-func (g *AsmGenerator) fixSetCCInstruction(inst *SetCC, index int, sa *stackAllocator) {
-	// Replace pseudoregister
-	if operand, ok := inst.Operand.(*Pseudo); ok {
-		inst.Operand = sa.allocateVar(operand.Identifier)
-		g.Program.Function.Instructions[index] = inst
+func fixLeaInstruction(inst *Lea) []Instruction {
+	// lea can only write to a register
+	if isMemory(inst.Dst) {
+		return []Instruction{
+			&Lea{Src: inst.Src, Dst: &Reg{Reg: regR11}},
+			&Mov{Type: Quadword, Src: &Reg{Reg: regR11}, Dst: inst.Dst},
+		}
 	}
+	return []Instruction{inst}
 }
@@ -8,6 +8,8 @@ type CompilerConfig struct {
 	StopAfterTAC      bool
 	StopAfterCodeGen  bool
 	StopAfterValidate bool
+	CompileOnly       bool
+	LinkMath          bool
 }
 
 func NewCompilerConfig() *CompilerConfig {
@@ -20,4 +22,6 @@ func (c *CompilerConfig) RegisterFlags() {
 	flag.BoolVar(&c.StopAfterTAC, "tacky", false, "stop after TAC generation")
 	flag.BoolVar(&c.StopAfterCodeGen, "codegen", false, "stop before code emission")
 	flag.BoolVar(&c.StopAfterValidate, "validate", false, "stop after ast validation")
+	flag.BoolVar(&c.CompileOnly, "c", false, "compile to an object file without linking")
+	flag.BoolVar(&c.LinkMath, "lm", false, "link against the math library")
 }
@@ -0,0 +1,124 @@
+package symbols
+
+import (
+	"acc/internal/common/types"
+	"sort"
+)
+
+// Table holds every identifier with a type after semantic analysis, keyed by its unique name
+type Table struct {
+	entries map[string]*Symbol
+}
+
+type Symbol struct {
+	Type  types.Type
+	Attrs Attributes
+}
+
+type Attributes interface {
+	attrs()
+}
+
+type FunAttrs struct {
+	Defined bool
+	Global  bool
+}
+
+// StaticAttrs describes a variable with static storage duration
+type StaticAttrs struct {
+	Init   InitialValue
+	Global bool
+}
+
+type LocalAttrs struct{}
+
+// ConstantAttrs describes a read-only object the compiler creates, such as the array behind a string literal
+type ConstantAttrs struct {
+	Init StaticInit
+}
+
+type InitialValue interface {
+	initialValue()
+}
+
+type Tentative struct{}
+
+// Initial holds the initializer as a sequence of values laid out one after another in memory
+type Initial struct {
+	Values []StaticInit
+}
+
+// StaticInit is one piece of a static initializer
+type StaticInit interface {
+	staticInit()
+}
+
+// ConstInit is a scalar, already converted to the type of the object it initializes
+type ConstInit struct {
+	Value types.Const
+}
+
+// StringInit stores the bytes of a string, followed by a null byte if NullTerminated is set
+type StringInit struct {
+	Value          string
+	NullTerminated bool
+}
+
+// PointerInit stores the address of the static object Name
+type PointerInit struct {
+	Name string
+}
+
+// ZeroInit fills Bytes bytes with zeros
+type ZeroInit struct {
+	Bytes int
+}
+
+type NoInitializer struct{}
+
+func (FunAttrs) attrs()      {}
+func (StaticAttrs) attrs()   {}
+func (LocalAttrs) attrs()    {}
+func (ConstantAttrs) attrs() {}
+
+func (Tentative) initialValue()     {}
+func (Initial) initialValue()       {}
+func (NoInitializer) initialValue() {}
+
+func (ConstInit) staticInit()   {}
+func (StringInit) staticInit()  {}
+func (PointerInit) staticInit() {}
+func (ZeroInit) staticInit()    {}
+
+func NewTable() *Table {
+	return &Table{entries: make(map[string]*Symbol)}
+}
+
+func (t *Table) Add(name string, symbol *Symbol) {
+	t.entries[name] = symbol
+}
+
+func (t *Table) Get(name string) (*Symbol, bool) {
+	symbol, ok := t.entries[name]
+	return symbol, ok
+}
+
+// Names returns every identifier in the table in a stable order
+func (t *Table) Names() []string {
+	names := make([]string, 0, len(t.entries))
+	for name := range t.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsStatic reports whether name refers to a variable with static storage duration
+func (t *Table) IsStatic(name string) bool {
+	symbol, ok := t.entries[name]
+	if !ok {
+		return false
+	}
+	_, isStatic := symbol.Attrs.(StaticAttrs)
+	return isStatic
+}
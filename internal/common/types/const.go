@@ -0,0 +1,124 @@
+package types
+
+import (
+	"fmt"
+	"math"
+)
+
+// Const is a compile-time constant of a particular arithmetic type
+type Const interface {
+	Type() Type
+	String() string
+}
+
+type ConstChar struct {
+	Value int8
+}
+
+type ConstUChar struct {
+	Value uint8
+}
+
+type ConstInt struct {
+	Value int32
+}
+
+type ConstLong struct {
+	Value int64
+}
+
+type ConstUInt struct {
+	Value uint32
+}
+
+type ConstULong struct {
+	Value uint64
+}
+
+type ConstDouble struct {
+	Value float64
+}
+
+func (ConstChar) Type() Type   { return Char{} }
+func (ConstUChar) Type() Type  { return UChar{} }
+func (ConstInt) Type() Type    { return Int{} }
+func (ConstLong) Type() Type   { return Long{} }
+func (ConstUInt) Type() Type   { return UInt{} }
+func (ConstULong) Type() Type  { return ULong{} }
+func (ConstDouble) Type() Type { return Double{} }
+
+func (c ConstChar) String() string   { return fmt.Sprint(c.Value) }
+func (c ConstUChar) String() string  { return fmt.Sprint(c.Value) }
+func (c ConstInt) String() string    { return fmt.Sprint(c.Value) }
+func (c ConstLong) String() string   { return fmt.Sprint(c.Value) }
+func (c ConstUInt) String() string   { return fmt.Sprint(c.Value) }
+func (c ConstULong) String() string  { return fmt.Sprint(c.Value) }
+func (c ConstDouble) String() string { return fmt.Sprint(c.Value) }
+
+// Int64 returns the value of c as a 64-bit integer; unsigned long values above
+// math.MaxInt64 keep their bit pattern and come out negative, and doubles are truncated
+func Int64(c Const) int64 {
+	switch c := c.(type) {
+	case ConstChar:
+		return int64(c.Value)
+	case ConstUChar:
+		return int64(c.Value)
+	case ConstInt:
+		return int64(c.Value)
+	case ConstLong:
+		return c.Value
+	case ConstUInt:
+		return int64(c.Value)
+	case ConstULong:
+		return int64(c.Value)
+	case ConstDouble:
+		return int64(c.Value)
+	default:
+		panic("invalid constant type")
+	}
+}
+
+// ConvertConst converts c to type t using the same wraparound rules as a cast at run time
+func ConvertConst(c Const, t Type) Const {
+	switch c := c.(type) {
+	case ConstDouble:
+		switch t.(type) {
+		case Double:
+			return c
+		case ULong:
+			return ConstULong{Value: uint64(c.Value)}
+		}
+	case ConstULong:
+		if _, ok := t.(Double); ok {
+			return ConstDouble{Value: float64(c.Value)}
+		}
+	}
+
+	value := Int64(c)
+	switch t.(type) {
+	case Char, SChar:
+		return ConstChar{Value: int8(value)}
+	case UChar:
+		return ConstUChar{Value: uint8(value)}
+	case Int:
+		return ConstInt{Value: int32(value)}
+	case Long:
+		return ConstLong{Value: value}
+	case UInt:
+		return ConstUInt{Value: uint32(value)}
+	case ULong, Pointer:
+		return ConstULong{Value: uint64(value)}
+	case Double:
+		return ConstDouble{Value: float64(value)}
+	default:
+		panic("cannot convert constant to " + t.String())
+	}
+}
+
+// IsZero reports whether every bit of c is zero, so -0.0 doesn't count
+func IsZero(c Const) bool {
+	if d, ok := c.(ConstDouble); ok {
+		return math.Float64bits(d.Value) == 0
+	}
+	return Int64(c) == 0
+}
@@ -0,0 +1,391 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type is the type of a declaration or expression
+type Type interface {
+	String() string
+}
+
+type Char struct{}
+
+type SChar struct{}
+
+type UChar struct{}
+
+type Int struct{}
+
+type Long struct{}
+
+type UInt struct{}
+
+type ULong struct{}
+
+type Double struct{}
+
+// Void is the incomplete type with no values; it only appears as a function's return type or behind a pointer
+type Void struct{}
+
+type Pointer struct {
+	Referenced Type
+}
+
+type Array struct {
+	Element Type
+	Length  int
+}
+
+// Structure is a struct or, if Union is set, a union type. Identifier resolution makes Tag unique
+// and gives every use of a tag the same Def, which is filled in once the definition is type checked.
+// Name keeps the tag as it was written, for messages, and is empty for an anonymous struct.
+type Structure struct {
+	Tag   string
+	Name  string
+	Union bool
+	Def   *StructDef
+}
+
+// StructDef is the layout of a struct type; it stays incomplete until the struct is defined
+type StructDef struct {
+	Complete  bool
+	Members   []Member
+	Size      int
+	Alignment int
+}
+
+// Member is one member of a struct, Offset bytes from the start of it
+type Member struct {
+	Name   string
+	Type   Type
+	Offset int
+}
+
+// FunType is the type of a function; a variadic function takes any number of arguments after Params
+type FunType struct {
+	Params   []Type
+	Ret      Type
+	Variadic bool
+}
+
+// Enum is an enum type as the parser saw it; identifier resolution checks the tag and replaces it
+// with int, which every enum type is compatible with
+type Enum struct {
+	Tag string
+}
+
+// Typedef is a typedef name as the parser saw it; identifier resolution replaces it with the type it names
+type Typedef struct {
+	Name string
+}
+
+func (Char) String() string {
+	return "char"
+}
+
+func (SChar) String() string {
+	return "signed char"
+}
+
+func (UChar) String() string {
+	return "unsigned char"
+}
+
+func (Int) String() string {
+	return "int"
+}
+
+func (Long) String() string {
+	return "long"
+}
+
+func (UInt) String() string {
+	return "unsigned int"
+}
+
+func (ULong) String() string {
+	return "unsigned long"
+}
+
+func (Double) String() string {
+	return "double"
+}
+
+func (Void) String() string {
+	return "void"
+}
+
+func (t Pointer) String() string {
+	return t.Referenced.String() + " *"
+}
+
+func (t Array) String() string {
+	return fmt.Sprintf("%s[%d]", t.Element, t.Length)
+}
+
+func (t Structure) String() string {
+	name := t.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	if t.Union {
+		return "union " + name
+	}
+	return "struct " + name
+}
+
+func (t Enum) String() string {
+	return "enum " + t.Tag
+}
+
+func (t Typedef) String() string {
+	return t.Name
+}
+
+func (t FunType) String() string {
+	params := make([]string, len(t.Params))
+	for i, param := range t.Params {
+		params[i] = param.String()
+	}
+	if t.Variadic {
+		params = append(params, "...")
+	}
+	return t.Ret.String() + "(" + strings.Join(params, ", ") + ")"
+}
+
+// Size returns the number of bytes an object of type t occupies
+func Size(t Type) int {
+	switch t := t.(type) {
+	case Char, SChar, UChar:
+		return 1
+	case Int, UInt:
+		return 4
+	case Long, ULong, Double, Pointer:
+		return 8
+	case Array:
+		return Size(t.Element) * t.Length
+	case Structure:
+		return t.Def.Size
+	default:
+		panic("type has no size: " + t.String())
+	}
+}
+
+// Alignment returns the required alignment in bytes of an object of type t
+func Alignment(t Type) int {
+	switch t := t.(type) {
+	case Array:
+		return Alignment(t.Element)
+	case Structure:
+		return t.Def.Alignment
+	default:
+		return Size(t)
+	}
+}
+
+// Define lays out members in declaration order, padding each one to its alignment and the whole
+// struct to a multiple of its strictest member's alignment, as the System V ABI requires
+func (d *StructDef) Define(names []string, memberTypes []Type) {
+	d.Members = make([]Member, len(names))
+	offset, alignment := 0, 1
+	for i, name := range names {
+		memberAlignment := Alignment(memberTypes[i])
+		offset = roundUp(offset, memberAlignment)
+		d.Members[i] = Member{Name: name, Type: memberTypes[i], Offset: offset}
+		offset += Size(memberTypes[i])
+		alignment = max(alignment, memberAlignment)
+	}
+	d.Size = roundUp(offset, alignment)
+	d.Alignment = alignment
+	d.Complete = true
+}
+
+// DefineUnion lays out members all at offset zero, so the union is as big as its largest member,
+// padded to the strictest alignment of any member
+func (d *StructDef) DefineUnion(names []string, memberTypes []Type) {
+	d.Members = make([]Member, len(names))
+	size, alignment := 0, 1
+	for i, name := range names {
+		d.Members[i] = Member{Name: name, Type: memberTypes[i], Offset: 0}
+		size = max(size, Size(memberTypes[i]))
+		alignment = max(alignment, Alignment(memberTypes[i]))
+	}
+	d.Size = roundUp(size, alignment)
+	d.Alignment = alignment
+	d.Complete = true
+}
+
+// Member looks up a member by name
+func (d *StructDef) Member(name string) (Member, bool) {
+	for _, member := range d.Members {
+		if member.Name == name {
+			return member, true
+		}
+	}
+	return Member{}, false
+}
+
+func roundUp(n, multiple int) int {
+	return (n + multiple - 1) / multiple * multiple
+}
+
+// VarAlignment returns the alignment of a variable of type t; the System V ABI
+// gives arrays of 16 bytes or more 16-byte alignment
+func VarAlignment(t Type) int {
+	if _, ok := t.(Array); ok && Size(t) >= 16 {
+		return 16
+	}
+	return Alignment(t)
+}
+
+// IsComplete reports whether the size of t is known, which it is for everything but void,
+// structs that haven't been defined yet, and arrays of those
+func IsComplete(t Type) bool {
+	switch t := t.(type) {
+	case Void:
+		return false
+	case Structure:
+		return t.Def.Complete
+	case Array:
+		return IsComplete(t.Element)
+	default:
+		return true
+	}
+}
+
+// IsScalar reports whether t is an arithmetic or pointer type, the types a condition can have
+func IsScalar(t Type) bool {
+	_, isPointer := t.(Pointer)
+	return isPointer || IsArithmetic(t)
+}
+
+// IsSigned reports whether t is a signed integer type; double is not an integer type and so isn't signed
+func IsSigned(t Type) bool {
+	switch t.(type) {
+	case Char, SChar, Int, Long:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsCharacter reports whether t is one of the three character types
+func IsCharacter(t Type) bool {
+	switch t.(type) {
+	case Char, SChar, UChar:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsInteger reports whether t is an integer type
+func IsInteger(t Type) bool {
+	switch t.(type) {
+	case Char, SChar, UChar, Int, Long, UInt, ULong:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsArithmetic reports whether t is an integer or floating type
+func IsArithmetic(t Type) bool {
+	switch t.(type) {
+	case Char, SChar, UChar, Int, Long, UInt, ULong, Double:
+		return true
+	default:
+		return false
+	}
+}
+
+// Promote applies the integer promotions, which turn character types into int
+func Promote(t Type) Type {
+	if IsCharacter(t) {
+		return Int{}
+	}
+	return t
+}
+
+// CommonType returns the type both operands of a binary operation are converted to
+func CommonType(t1, t2 Type) Type {
+	// Character types are promoted to int before anything else
+	t1, t2 = Promote(t1), Promote(t2)
+	if Equal(t1, t2) {
+		return t1
+	}
+	if _, isDouble := t1.(Double); isDouble {
+		return t1
+	}
+	if _, isDouble := t2.(Double); isDouble {
+		return t2
+	}
+	// The wider type wins; between types of equal size the unsigned one wins
+	if Size(t1) == Size(t2) {
+		if IsSigned(t1) {
+			return t2
+		}
+		return t1
+	}
+	if Size(t1) > Size(t2) {
+		return t1
+	}
+	return t2
+}
+
+// Equal reports whether two types are identical
+func Equal(a, b Type) bool {
+	switch a := a.(type) {
+	case Char:
+		_, ok := b.(Char)
+		return ok
+	case SChar:
+		_, ok := b.(SChar)
+		return ok
+	case UChar:
+		_, ok := b.(UChar)
+		return ok
+	case Int:
+		_, ok := b.(Int)
+		return ok
+	case Long:
+		_, ok := b.(Long)
+		return ok
+	case UInt:
+		_, ok := b.(UInt)
+		return ok
+	case ULong:
+		_, ok := b.(ULong)
+		return ok
+	case Double:
+		_, ok := b.(Double)
+		return ok
+	case Void:
+		_, ok := b.(Void)
+		return ok
+	case Pointer:
+		b, ok := b.(Pointer)
+		return ok && Equal(a.Referenced, b.Referenced)
+	case Array:
+		b, ok := b.(Array)
+		return ok && a.Length == b.Length && Equal(a.Element, b.Element)
+	case Structure:
+		b, ok := b.(Structure)
+		return ok && a.Tag == b.Tag
+	case FunType:
+		b, ok := b.(FunType)
+		if !ok || len(a.Params) != len(b.Params) || a.Variadic != b.Variadic || !Equal(a.Ret, b.Ret) {
+			return false
+		}
+		for i := range a.Params {
+			if !Equal(a.Params[i], b.Params[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
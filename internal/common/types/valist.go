@@ -0,0 +1,35 @@
+package types
+
+// The prologue of a variadic function saves every register an argument can arrive in to a register
+// save area: the six general purpose argument registers first, then the eight XMM argument registers
+// in 16-byte slots. va_arg reads arguments from there until they run out, then from the stack.
+const (
+	GPSaveAreaSize  = 48
+	RegSaveAreaSize = 176
+)
+
+// vaListTag is the structure the System V ABI uses to track how far va_arg has got. gp_offset and
+// fp_offset are the offsets into the register save area of the next general purpose and XMM
+// argument, and overflow_arg_area points to the next argument passed on the stack.
+var vaListTag = Structure{Tag: "__va_list_tag", Name: "__va_list_tag", Def: vaListDef()}
+
+func vaListDef() *StructDef {
+	def := &StructDef{}
+	voidPointer := Pointer{Referenced: Void{}}
+	def.Define(
+		[]string{"gp_offset", "fp_offset", "overflow_arg_area", "reg_save_area"},
+		[]Type{UInt{}, UInt{}, voidPointer, voidPointer},
+	)
+	return def
+}
+
+// VaListTag returns the structure a va_list is made of
+func VaListTag() Structure {
+	return vaListTag
+}
+
+// VaList returns the type of __builtin_va_list, which <stdarg.h> defines va_list as. It's an array
+// of one structure, so a va_list passed to another function is passed by reference.
+func VaList() Type {
+	return Array{Element: vaListTag, Length: 1}
+}
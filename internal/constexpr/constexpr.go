@@ -0,0 +1,429 @@
+// Package constexpr evaluates constant expressions at compile time, giving exactly the value the
+// expression would have at run time: unsigned arithmetic wraps around at the width of its type, while
+// signed overflow, division by zero and out of range shifts are errors, since their behaviour is
+// undefined.
+package constexpr
+
+import (
+	"acc/internal/common/errors"
+	"acc/internal/common/types"
+	"acc/internal/parser"
+	"math"
+	"math/big"
+)
+
+type evaluator struct {
+	// floating is set for an arithmetic constant expression, which unlike an integer constant
+	// expression can have floating operands
+	floating bool
+	// skipping counts the enclosing operands that are never evaluated, like the right side of 0 && x.
+	// Those still have to be constant, but overflowing or dividing by zero in them isn't an error.
+	skipping int
+}
+
+// EvaluateInteger evaluates an integer constant expression, as in a case label, an array size or an
+// enumerator. Its operands can only be integer constants, sizeof expressions and floating constants
+// that are cast to an integer type straight away.
+func EvaluateInteger(exp parser.Expression) (types.Const, error) {
+	e := &evaluator{}
+	return e.expression(exp)
+}
+
+// EvaluateArithmetic evaluates an arithmetic constant expression, as in the initializer of a variable
+// with static storage duration, which can also do floating point arithmetic
+func EvaluateArithmetic(exp parser.Expression) (types.Const, error) {
+	e := &evaluator{floating: true}
+	return e.expression(exp)
+}
+
+func (e *evaluator) expression(exp parser.Expression) (types.Const, error) {
+	switch exp := exp.(type) {
+	case *parser.FactorExp:
+		return e.factor(exp.Factor)
+	case *parser.BinaryExp:
+		if exp.Op == parser.BinopAnd || exp.Op == parser.BinopOr {
+			return e.logical(exp)
+		}
+		left, err := e.expression(exp.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := e.expression(exp.Right)
+		if err != nil {
+			return nil, err
+		}
+		return e.binary(exp.Op, left, right, exp.Loc)
+	case *parser.ConditionalExp:
+		return e.conditional(exp)
+	case *parser.AssignmentExp:
+		return nil, notConstant(exp.Loc)
+	case *parser.CompoundAssignmentExp:
+		return nil, notConstant(exp.Loc)
+	default:
+		panic("invalid expression type")
+	}
+}
+
+func (e *evaluator) factor(factor parser.Factor) (types.Const, error) {
+	switch item := factor.(type) {
+	case *parser.Constant:
+		if _, isDouble := item.Value.(types.ConstDouble); isDouble && !e.floating {
+			return nil, errors.NewAnalysisError("floating constant in integer constant expression", item.Loc)
+		}
+		return item.Value, nil
+	case *parser.NestedExp:
+		return e.expression(item.Expr)
+	case *parser.UnaryFactor:
+		value, err := e.factor(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		return e.unary(item.Op, value, item.Loc)
+	case *parser.CastFactor:
+		return e.cast(item)
+	case *parser.SizeOfTypeFactor:
+		return sizeOf(item.TargetType, item.Loc)
+	case *parser.SizeOfExpFactor:
+		// The operand's type is only known once it's been type checked
+		t := item.Expr.GetType()
+		if t == nil {
+			return nil, errors.NewAnalysisError("sizeof an expression can't be evaluated here", item.Loc)
+		}
+		return sizeOf(t, item.Loc)
+	case *parser.IdentifierFactor:
+		return nil, notConstant(item.Loc)
+	case *parser.StringLiteral:
+		return nil, notConstant(item.Loc)
+	case *parser.FunctionCall:
+		return nil, notConstant(item.Loc)
+	case *parser.IncDecFactor:
+		return nil, notConstant(item.Loc)
+	case *parser.DereferenceFactor:
+		return nil, notConstant(item.Loc)
+	case *parser.AddressOfFactor:
+		return nil, notConstant(item.Loc)
+	case *parser.SubscriptFactor:
+		return nil, notConstant(item.Loc)
+	case *parser.DotFactor:
+		return nil, notConstant(item.Loc)
+	case *parser.ArrowFactor:
+		return nil, notConstant(item.Loc)
+	case *parser.VaBuiltinFactor:
+		return nil, notConstant(item.Loc)
+	default:
+		panic("invalid factor type")
+	}
+}
+
+func notConstant(loc errors.Location) error {
+	return errors.NewAnalysisError("expression is not constant", loc)
+}
+
+func sizeOf(t types.Type, loc errors.Location) (types.Const, error) {
+	if !types.IsComplete(t) {
+		return nil, errors.NewAnalysisError("can't take the size of an incomplete type", loc)
+	}
+	return types.ConstULong{Value: uint64(types.Size(t))}, nil
+}
+
+// cast converts the operand of a cast. An integer constant expression can only be cast to an integer
+// type, and that's the one place a floating constant can appear in one.
+func (e *evaluator) cast(cast *parser.CastFactor) (types.Const, error) {
+	target := cast.TargetType
+	if !types.IsArithmetic(target) {
+		return nil, errors.NewAnalysisError("can't cast to "+target.String()+" in a constant expression", cast.Loc)
+	}
+	if !e.floating && !types.IsInteger(target) {
+		return nil, errors.NewAnalysisError("can't cast to "+target.String()+" in an integer constant expression", cast.Loc)
+	}
+
+	var value types.Const
+	var err error
+	if constant, ok := floatingConstant(cast.Expr); ok {
+		value = constant
+	} else {
+		value, err = e.expression(cast.Expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return e.convert(value, target, cast.Loc)
+}
+
+// floatingConstant returns the floating constant exp consists of, if it is one
+func floatingConstant(exp parser.Expression) (types.Const, bool) {
+	for {
+		factor, ok := exp.(*parser.FactorExp)
+		if !ok {
+			return nil, false
+		}
+		switch item := factor.Factor.(type) {
+		case *parser.Constant:
+			_, isDouble := item.Value.(types.ConstDouble)
+			return item.Value, isDouble
+		case *parser.NestedExp:
+			exp = item.Expr
+		default:
+			return nil, false
+		}
+	}
+}
+
+// convert converts value to t. Converting a double whose integer part doesn't fit in an integer type is
+// undefined, so it's an error; every other conversion wraps as it would at run time.
+func (e *evaluator) convert(value types.Const, t types.Type, loc errors.Location) (types.Const, error) {
+	if d, isDouble := value.(types.ConstDouble); isDouble && types.IsInteger(t) && e.skipping == 0 {
+		truncated := math.Trunc(d.Value)
+		bits := float64(8 * types.Size(t))
+		low, high := -math.Exp2(bits-1), math.Exp2(bits-1)
+		if !types.IsSigned(t) {
+			low, high = 0, math.Exp2(bits)
+		}
+		if !(truncated >= low && truncated < high) {
+			return nil, errors.NewAnalysisError("constant "+d.String()+" is out of range of "+t.String(), loc)
+		}
+	}
+	return types.ConvertConst(value, t), nil
+}
+
+func (e *evaluator) unary(op parser.UnopType, value types.Const, loc errors.Location) (types.Const, error) {
+	if op == parser.UnopNot {
+		return boolConst(!isTrue(value)), nil
+	}
+
+	if d, isDouble := value.(types.ConstDouble); isDouble {
+		if op == parser.UnopBitwiseComp {
+			return nil, errors.NewAnalysisError("invalid operand of type double to ~", loc)
+		}
+		return types.ConstDouble{Value: -d.Value}, nil
+	}
+
+	t := types.Promote(value.Type())
+	v := toBig(value)
+	if op == parser.UnopNegate {
+		v.Neg(v)
+	} else {
+		v.Not(v)
+	}
+	return e.fit(v, t, loc)
+}
+
+// logical evaluates && and ||, which only evaluate their right operand if the left doesn't decide the result
+func (e *evaluator) logical(exp *parser.BinaryExp) (types.Const, error) {
+	left, err := e.expression(exp.Left)
+	if err != nil {
+		return nil, err
+	}
+	decided := isTrue(left) == (exp.Op == parser.BinopOr)
+
+	if decided {
+		e.skipping++
+	}
+	right, err := e.expression(exp.Right)
+	if decided {
+		e.skipping--
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if decided {
+		return boolConst(isTrue(left)), nil
+	}
+	return boolConst(isTrue(right)), nil
+}
+
+// conditional evaluates c ? a : b. Only the operand the condition picks is evaluated, but the result has
+// the common type of both.
+func (e *evaluator) conditional(exp *parser.ConditionalExp) (types.Const, error) {
+	condition, err := e.expression(exp.Condition)
+	if err != nil {
+		return nil, err
+	}
+	chosen := isTrue(condition)
+
+	values := [2]types.Const{}
+	for i, operand := range []parser.Expression{exp.Expression1, exp.Expression2} {
+		skipped := (i == 0) != chosen
+		if skipped {
+			e.skipping++
+		}
+		values[i], err = e.expression(operand)
+		if skipped {
+			e.skipping--
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	common := types.CommonType(values[0].Type(), values[1].Type())
+	if chosen {
+		return types.ConvertConst(values[0], common), nil
+	}
+	return types.ConvertConst(values[1], common), nil
+}
+
+func (e *evaluator) binary(op parser.BinopType, left, right types.Const, loc errors.Location) (types.Const, error) {
+	if op == parser.BinopShiftLeft || op == parser.BinopShiftRight {
+		return e.shift(op, left, right, loc)
+	}
+
+	t := types.CommonType(left.Type(), right.Type())
+	left, right = types.ConvertConst(left, t), types.ConvertConst(right, t)
+	if _, isDouble := t.(types.Double); isDouble {
+		return doubleBinary(op, left.(types.ConstDouble).Value, right.(types.ConstDouble).Value, loc)
+	}
+
+	l, r := toBig(left), toBig(right)
+	switch op {
+	case parser.BinopEqual:
+		return boolConst(l.Cmp(r) == 0), nil
+	case parser.BinopNotEqual:
+		return boolConst(l.Cmp(r) != 0), nil
+	case parser.BinopLessThan:
+		return boolConst(l.Cmp(r) < 0), nil
+	case parser.BinopLessOrEqual:
+		return boolConst(l.Cmp(r) <= 0), nil
+	case parser.BinopGreaterThan:
+		return boolConst(l.Cmp(r) > 0), nil
+	case parser.BinopGreaterOrEqual:
+		return boolConst(l.Cmp(r) >= 0), nil
+	}
+
+	result := new(big.Int)
+	switch op {
+	case parser.BinopAdd:
+		result.Add(l, r)
+	case parser.BinopSubtract:
+		result.Sub(l, r)
+	case parser.BinopMultiply:
+		result.Mul(l, r)
+	case parser.BinopDivide, parser.BinopRemainder:
+		if r.Sign() == 0 {
+			if e.skipping > 0 {
+				return types.ConvertConst(types.ConstInt{}, t), nil
+			}
+			return nil, errors.NewAnalysisError("division by zero in constant expression", loc)
+		}
+		// C division truncates toward zero, as Quo and Rem do. The remainder is undefined whenever the
+		// quotient is, so the quotient is checked for overflow either way.
+		result.Quo(l, r)
+		if _, err := e.fit(result, t, loc); err != nil {
+			return nil, err
+		}
+		if op == parser.BinopRemainder {
+			result.Rem(l, r)
+		}
+	case parser.BinopBitwiseAnd:
+		result.And(l, r)
+	case parser.BinopBitwiseOr:
+		result.Or(l, r)
+	case parser.BinopBitwiseXor:
+		result.Xor(l, r)
+	default:
+		panic("invalid binary operator")
+	}
+	return e.fit(result, t, loc)
+}
+
+func doubleBinary(op parser.BinopType, l, r float64, loc errors.Location) (types.Const, error) {
+	switch op {
+	case parser.BinopAdd:
+		return types.ConstDouble{Value: l + r}, nil
+	case parser.BinopSubtract:
+		return types.ConstDouble{Value: l - r}, nil
+	case parser.BinopMultiply:
+		return types.ConstDouble{Value: l * r}, nil
+	case parser.BinopDivide:
+		return types.ConstDouble{Value: l / r}, nil
+	case parser.BinopEqual:
+		return boolConst(l == r), nil
+	case parser.BinopNotEqual:
+		return boolConst(l != r), nil
+	case parser.BinopLessThan:
+		return boolConst(l < r), nil
+	case parser.BinopLessOrEqual:
+		return boolConst(l <= r), nil
+	case parser.BinopGreaterThan:
+		return boolConst(l > r), nil
+	case parser.BinopGreaterOrEqual:
+		return boolConst(l >= r), nil
+	default:
+		return nil, errors.NewAnalysisError("invalid operand of type double in constant expression", loc)
+	}
+}
+
+// shift evaluates << and >>. The result has the promoted type of the left operand, whatever the type of
+// the right; shifting by a negative count or by at least the width of that type is undefined, as is
+// shifting a negative value left or shifting a signed value past its largest value.
+func (e *evaluator) shift(op parser.BinopType, left, right types.Const, loc errors.Location) (types.Const, error) {
+	_, leftDouble := left.(types.ConstDouble)
+	_, rightDouble := right.(types.ConstDouble)
+	if leftDouble || rightDouble {
+		return nil, errors.NewAnalysisError("invalid operand of type double in constant expression", loc)
+	}
+
+	t := types.Promote(left.Type())
+	l, count := toBig(left), toBig(right)
+	if count.Sign() < 0 || count.Cmp(big.NewInt(int64(8*types.Size(t)))) >= 0 {
+		if e.skipping > 0 {
+			return types.ConvertConst(types.ConstInt{}, t), nil
+		}
+		return nil, errors.NewAnalysisError("shift count "+count.String()+" is out of range in constant expression", loc)
+	}
+
+	result := new(big.Int)
+	if op == parser.BinopShiftRight {
+		// Shifting a negative value right is implementation-defined; like the shift instructions used at
+		// run time, Rsh keeps the sign
+		return e.fit(result.Rsh(l, uint(count.Uint64())), t, loc)
+	}
+	if l.Sign() < 0 && e.skipping == 0 {
+		return nil, errors.NewAnalysisError("left shift of negative value in constant expression", loc)
+	}
+	return e.fit(result.Lsh(l, uint(count.Uint64())), t, loc)
+}
+
+// fit converts the exact result of an integer operation to t. Unsigned results wrap around; a signed
+// result that doesn't fit has overflowed.
+func (e *evaluator) fit(v *big.Int, t types.Type, loc errors.Location) (types.Const, error) {
+	bits := uint(8 * types.Size(t))
+	if !types.IsSigned(t) {
+		mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bits), big.NewInt(1))
+		return types.ConvertConst(types.ConstULong{Value: new(big.Int).And(v, mask).Uint64()}, t), nil
+	}
+
+	limit := new(big.Int).Lsh(big.NewInt(1), bits-1)
+	if v.Cmp(limit) >= 0 || v.Cmp(new(big.Int).Neg(limit)) < 0 {
+		if e.skipping == 0 {
+			return nil, errors.NewAnalysisError("integer overflow in constant expression", loc)
+		}
+		v = new(big.Int).And(v, new(big.Int).Sub(new(big.Int).Lsh(limit, 1), big.NewInt(1)))
+		return types.ConvertConst(types.ConstULong{Value: v.Uint64()}, t), nil
+	}
+	return types.ConvertConst(types.ConstLong{Value: v.Int64()}, t), nil
+}
+
+// toBig returns the exact value of an integer constant
+func toBig(c types.Const) *big.Int {
+	if u, isULong := c.(types.ConstULong); isULong {
+		return new(big.Int).SetUint64(u.Value)
+	}
+	return big.NewInt(types.Int64(c))
+}
+
+// isTrue reports whether c compares unequal to zero, which -0.0 doesn't
+func isTrue(c types.Const) bool {
+	if d, isDouble := c.(types.ConstDouble); isDouble {
+		return d.Value != 0
+	}
+	return types.Int64(c) != 0
+}
+
+func boolConst(b bool) types.Const {
+	if b {
+		return types.ConstInt{Value: 1}
+	}
+	return types.ConstInt{Value: 0}
+}
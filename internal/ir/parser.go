@@ -2,7 +2,10 @@ package ir
 
 import (
 	"acc/internal/common/errors"
+	"acc/internal/common/symbols"
+	"acc/internal/common/types"
 	"acc/internal/parser"
+	"encoding/binary"
 	"fmt"
 )
 
@@ -10,22 +13,82 @@ type TACGenerator struct {
 	instructions   []Instruction
 	tempVarCounter int
 	labelCounter   int
+	symbols        *symbols.Table
 }
 
-// Accept starting number from semantic analysis
-func NewTACGenerator(startVar int) *TACGenerator {
-	return &TACGenerator{tempVarCounter: startVar}
+// Accept starting number and symbol table from semantic analysis
+func NewTACGenerator(startVar int, symbolTable *symbols.Table) *TACGenerator {
+	return &TACGenerator{tempVarCounter: startVar, symbols: symbolTable}
 }
 
-func (g *TACGenerator) makeTemporaryVar() string {
+// makeTemporaryVar creates a new temporary and records its type in the symbol table
+func (g *TACGenerator) makeTemporaryVar(t types.Type) string {
 	g.tempVarCounter++
-	return fmt.Sprintf("tmp.%d", g.tempVarCounter)
+	name := fmt.Sprintf("tmp.%d", g.tempVarCounter)
+	g.symbols.Add(name, &symbols.Symbol{Type: t, Attrs: symbols.LocalAttrs{}})
+	return name
 }
 func (g *TACGenerator) makeLabel(prefix string) string {
 	g.labelCounter++
 	return fmt.Sprintf("%s.%d", prefix, g.labelCounter)
 }
 
+// dereferencedPointer is the result of translating *ptr: the pointed-to object isn't read until
+// we know whether the expression is being assigned to or used as a value
+type dereferencedPointer struct {
+	ptr Value
+}
+
+// subObject is the result of translating a member access on a variable: the member lives offset
+// bytes into base, and like a dereferenced pointer it isn't read until we know how it is used
+type subObject struct {
+	base   string
+	offset int
+}
+
+// typedNode is an expression or factor that the type checker has annotated with a type
+type typedNode interface {
+	parser.Node
+	GetType() types.Type
+}
+
+// emitValue translates node and returns its value, loading through the pointer if node is a dereference
+func (g *TACGenerator) emitValue(node typedNode) Value {
+	return g.readLvalue(node.Accept(g), node.GetType())
+}
+
+// readLvalue returns the value of an object of type t, given the result of translating it
+func (g *TACGenerator) readLvalue(result any, t types.Type) Value {
+	switch result := result.(type) {
+	case *dereferencedPointer:
+		dstVar := &Variable{Identifier: g.makeTemporaryVar(t)}
+		g.instructions = append(g.instructions, &LoadInstr{SrcPtr: result.ptr, Dst: dstVar})
+		return dstVar
+	case *subObject:
+		dstVar := &Variable{Identifier: g.makeTemporaryVar(t)}
+		g.instructions = append(g.instructions, &CopyFromOffsetInstr{Src: result.base, Offset: result.offset, Dst: dstVar})
+		return dstVar
+	case Value:
+		return result
+	default:
+		panic("expression produced no value")
+	}
+}
+
+// writeLvalue stores value into an object, given the result of translating it
+func (g *TACGenerator) writeLvalue(result any, value Value) {
+	switch result := result.(type) {
+	case *dereferencedPointer:
+		g.instructions = append(g.instructions, &StoreInstr{Src: value, DstPtr: result.ptr})
+	case *subObject:
+		g.instructions = append(g.instructions, &CopyToOffsetInstr{Src: value, Dst: result.base, Offset: result.offset})
+	case Value:
+		g.instructions = append(g.instructions, &CopyInstr{Src: value, Dst: result})
+	default:
+		panic("invalid assignment target")
+	}
+}
+
 func (g *TACGenerator) Generate(node *parser.Program) (*Program, error) {
 	result := node.Accept(g)
 
@@ -38,21 +101,83 @@ func (g *TACGenerator) Generate(node *parser.Program) (*Program, error) {
 }
 
 func (g *TACGenerator) VisitProgram(node *parser.Program) interface{} {
-	function := node.Function.Accept(g).(Function)
+	program := &Program{}
+
+	for _, decl := range node.Declarations {
+		// Prototypes don't produce any code
+		function, ok := decl.(*parser.FunctionDecl)
+		if !ok || function.Body == nil {
+			continue
+		}
 
-	function.Body = g.instructions
+		g.instructions = nil
+		tacFunction := function.Accept(g).(*Function)
+		tacFunction.Body = g.instructions
+		program.TopLevel = append(program.TopLevel, tacFunction)
+	}
+
+	program.TopLevel = append(program.TopLevel, g.staticVariables()...)
 
-	return &Program{Function: function}
+	return program
+}
+
+// staticVariables emits a definition for every variable with static storage duration and every read-only constant
+func (g *TACGenerator) staticVariables() []TopLevel {
+	var variables []TopLevel
+	for _, name := range g.symbols.Names() {
+		symbol, _ := g.symbols.Get(name)
+		if attrs, ok := symbol.Attrs.(symbols.ConstantAttrs); ok {
+			variables = append(variables, &StaticConstant{Identifier: name, Type: symbol.Type, Init: attrs.Init})
+			continue
+		}
+		attrs, ok := symbol.Attrs.(symbols.StaticAttrs)
+		if !ok {
+			continue
+		}
+
+		switch init := attrs.Init.(type) {
+		case symbols.Initial:
+			variables = append(variables, &StaticVariable{Identifier: name, Global: attrs.Global, Type: symbol.Type, Init: init.Values})
+		case symbols.Tentative:
+			zero := []symbols.StaticInit{symbols.ZeroInit{Bytes: types.Size(symbol.Type)}}
+			variables = append(variables, &StaticVariable{Identifier: name, Global: attrs.Global, Type: symbol.Type, Init: zero})
+		}
+	}
+	return variables
 }
 
-func (g *TACGenerator) VisitFunction(node *parser.Function) interface{} {
+func (g *TACGenerator) VisitFunctionDecl(node *parser.FunctionDecl) interface{} {
+	// Local function declarations have no body and generate nothing
+	if node.Body == nil {
+		return nil
+	}
+
 	node.Body.Accept(g)
 
-	// Handle situation where function has no return statement; if function has return statement this will do nothing
-	g.instructions = append(g.instructions, &ReturnInstr{Value: &Constant{Value: 0}})
+	// Handle situation where function has no return statement; if function has return statement this will do nothing.
+	// There's no zero to return from a function returning a struct, and using its result would be undefined anyway.
+	switch node.Type.Ret.(type) {
+	case types.Void, types.Structure:
+		g.instructions = append(g.instructions, &ReturnInstr{})
+	default:
+		returnValue := types.ConvertConst(types.ConstInt{Value: 0}, node.Type.Ret)
+		g.instructions = append(g.instructions, &ReturnInstr{Value: &Constant{Value: returnValue}})
+	}
 
-	return Function{
+	params := make([]string, len(node.Params))
+	for i, param := range node.Params {
+		params[i] = param.Value
+	}
+
+	global := true
+	if symbol, ok := g.symbols.Get(node.Name.Value); ok {
+		global = symbol.Attrs.(symbols.FunAttrs).Global
+	}
+
+	return &Function{
 		Identifier: node.Name.Value,
+		Global:     global,
+		Params:     params,
 	}
 }
 
@@ -64,7 +189,10 @@ func (g *TACGenerator) VisitBlock(node *parser.Block) any {
 }
 
 func (g *TACGenerator) VisitReturnStatement(node *parser.ReturnStmt) any {
-	resultValue := node.Expression.Accept(g).(Value)
+	var resultValue Value
+	if node.Expression != nil {
+		resultValue = g.emitValue(node.Expression)
+	}
 
 	returnInstr := &ReturnInstr{Value: resultValue}
 	g.instructions = append(g.instructions, returnInstr)
@@ -72,20 +200,76 @@ func (g *TACGenerator) VisitReturnStatement(node *parser.ReturnStmt) any {
 	return returnInstr
 }
 
-func (g *TACGenerator) VisitDeclaration(node *parser.Declaration) any {
-	if node.Init == nil {
+func (g *TACGenerator) VisitVarDecl(node *parser.VarDecl) any {
+	// Static and extern variables are initialized at load time, not when the declaration runs
+	if node.Init == nil || node.StorageClass != parser.StorageClassNone {
 		return nil
 	}
 
-	initValue := node.Init.Accept(g).(Value)
+	if _, isSingle := node.Init.(*parser.SingleInit); isSingle && !isArray(node.Type) {
+		init := node.Init.(*parser.SingleInit)
+		initValue := g.emitValue(init.Expr)
 
-	variable := &Variable{Identifier: g.makeTemporaryVar()}
+		variable := &Variable{Identifier: g.makeTemporaryVar(init.GetType())}
 
-	copyInstr := &CopyInstr{Src: initValue, Dst: variable}
-	g.instructions = append(g.instructions, copyInstr, &CopyInstr{Src: variable, Dst: &Variable{Identifier: node.Name.Value}})
+		copyInstr := &CopyInstr{Src: initValue, Dst: variable}
+		g.instructions = append(g.instructions, copyInstr, &CopyInstr{Src: variable, Dst: &Variable{Identifier: node.Name.Value}})
 
-	return variable
+		return variable
+	}
+
+	// Arrays and structs are filled in one scalar at a time
+	g.emitInit(node.Init, node.Name.Value, 0)
+	return nil
+}
+
+// emitInit copies each scalar in init into the variable name at its byte offset
+func (g *TACGenerator) emitInit(init parser.Initializer, name string, offset int) {
+	switch init := init.(type) {
+	case *parser.SingleInit:
+		if array, isArray := init.GetType().(types.Array); isArray {
+			g.emitStringInit(init.Expr.(*parser.FactorExp).Factor.(*parser.StringLiteral).Value, array.Length, name, offset)
+			return
+		}
+		g.instructions = append(g.instructions, &CopyToOffsetInstr{Src: g.emitValue(init.Expr), Dst: name, Offset: offset})
+	case *parser.CompoundInit:
+		switch t := init.GetType().(type) {
+		case types.Array:
+			elementSize := types.Size(t.Element)
+			for i, item := range init.Inits {
+				g.emitInit(item, name, offset+i*elementSize)
+			}
+		case types.Structure:
+			for i, item := range init.Inits {
+				g.emitInit(item, name, offset+t.Def.Members[i].Offset)
+			}
+		default:
+			panic("invalid compound initializer type")
+		}
+	default:
+		panic("invalid initializer type")
+	}
+}
 
+// emitStringInit copies value into a char array of the given length, padding it with null bytes.
+// The bytes are copied eight or four at a time where possible.
+func (g *TACGenerator) emitStringInit(value string, length int, name string, offset int) {
+	bytes := make([]byte, length)
+	copy(bytes, value)
+
+	for i := 0; i < length; {
+		var chunk types.Const
+		switch {
+		case length-i >= 8:
+			chunk = types.ConstLong{Value: int64(binary.LittleEndian.Uint64(bytes[i:]))}
+		case length-i >= 4:
+			chunk = types.ConstInt{Value: int32(binary.LittleEndian.Uint32(bytes[i:]))}
+		default:
+			chunk = types.ConstChar{Value: int8(bytes[i])}
+		}
+		g.instructions = append(g.instructions, &CopyToOffsetInstr{Src: &Constant{Value: chunk}, Dst: name, Offset: offset + i})
+		i += types.Size(chunk.Type())
+	}
 }
 
 func (g *TACGenerator) VisitNullStatement(node *parser.NullStmt) any {
@@ -94,45 +278,47 @@ func (g *TACGenerator) VisitNullStatement(node *parser.NullStmt) any {
 
 func (g *TACGenerator) VisitBinaryExp(node *parser.BinaryExp) interface{} {
 	if node.Op == parser.BinopAnd {
-		leftVal := node.Left.Accept(g).(Value)
+		leftVal := g.emitValue(node.Left)
 		falseLabel := g.makeLabel("and_false")
 		endLabel := g.makeLabel("and_end")
-		dstVar := &Variable{Identifier: g.makeTemporaryVar()}
+		dstVar := &Variable{Identifier: g.makeTemporaryVar(types.Int{})}
 
 		g.instructions = append(g.instructions, &JumpIfZeroInstr{Condition: leftVal, Target: falseLabel})
 
-		rightVal := node.Right.Accept(g).(Value)
+		rightVal := g.emitValue(node.Right)
 		g.instructions = append(g.instructions, &JumpIfZeroInstr{Condition: rightVal, Target: falseLabel},
-			&CopyInstr{Src: &Constant{Value: 1}, Dst: dstVar},
+			&CopyInstr{Src: &Constant{Value: types.ConstInt{Value: 1}}, Dst: dstVar},
 			&JumpInstr{Identifier: endLabel},
 			&LabelInstr{Identifier: falseLabel},
-			&CopyInstr{Src: &Constant{Value: 0}, Dst: dstVar},
+			&CopyInstr{Src: &Constant{Value: types.ConstInt{Value: 0}}, Dst: dstVar},
 			&LabelInstr{Identifier: endLabel})
 		return dstVar
 
 	} else if node.Op == parser.BinopOr {
-		leftVal := node.Left.Accept(g).(Value)
+		leftVal := g.emitValue(node.Left)
 		trueLabel := g.makeLabel("or_true")
 		endLabel := g.makeLabel("or_end")
-		dstVar := &Variable{Identifier: g.makeTemporaryVar()}
+		dstVar := &Variable{Identifier: g.makeTemporaryVar(types.Int{})}
 
 		g.instructions = append(g.instructions, &JumpIfNotZeroInstr{Condition: leftVal, Target: trueLabel})
 
-		rightVal := node.Right.Accept(g).(Value)
+		rightVal := g.emitValue(node.Right)
 		g.instructions = append(g.instructions, &JumpIfNotZeroInstr{Condition: rightVal, Target: trueLabel},
-			&CopyInstr{Src: &Constant{Value: 0}, Dst: dstVar},
+			&CopyInstr{Src: &Constant{Value: types.ConstInt{Value: 0}}, Dst: dstVar},
 			&JumpInstr{Identifier: endLabel},
 			&LabelInstr{Identifier: trueLabel},
-			&CopyInstr{Src: &Constant{Value: 1}, Dst: dstVar},
+			&CopyInstr{Src: &Constant{Value: types.ConstInt{Value: 1}}, Dst: dstVar},
 			&LabelInstr{Identifier: endLabel})
 		return dstVar
+	} else if isPointer(node.Left.GetType()) || isPointer(node.Right.GetType()) {
+		return g.emitPointerArithmetic(node)
 	} else {
 		// Visit left and right operands
-		leftVal := node.Left.Accept(g).(Value)
-		rightVal := node.Right.Accept(g).(Value)
+		leftVal := g.emitValue(node.Left)
+		rightVal := g.emitValue(node.Right)
 
 		// Create a destination temporary variable
-		destVar := &Variable{Identifier: g.makeTemporaryVar()}
+		destVar := &Variable{Identifier: g.makeTemporaryVar(node.GetType())}
 
 		binInstr := &BinaryInstr{
 			Operator: node.Op,
@@ -147,24 +333,206 @@ func (g *TACGenerator) VisitBinaryExp(node *parser.BinaryExp) interface{} {
 	}
 }
 
+// emitPointerArithmetic translates a binary expression with a pointer operand, scaling integer
+// operands by the size of the element the pointer points to
+func (g *TACGenerator) emitPointerArithmetic(node *parser.BinaryExp) Value {
+	leftVal := g.emitValue(node.Left)
+	rightVal := g.emitValue(node.Right)
+	dstVar := &Variable{Identifier: g.makeTemporaryVar(node.GetType())}
+
+	switch {
+	case node.Op == parser.BinopAdd && isPointer(node.Left.GetType()):
+		scale := types.Size(node.Left.GetType().(types.Pointer).Referenced)
+		g.instructions = append(g.instructions, &AddPtrInstr{Ptr: leftVal, Index: rightVal, Scale: scale, Dst: dstVar})
+	case node.Op == parser.BinopAdd:
+		scale := types.Size(node.Right.GetType().(types.Pointer).Referenced)
+		g.instructions = append(g.instructions, &AddPtrInstr{Ptr: rightVal, Index: leftVal, Scale: scale, Dst: dstVar})
+	case node.Op == parser.BinopSubtract && !isPointer(node.Right.GetType()):
+		scale := types.Size(node.Left.GetType().(types.Pointer).Referenced)
+		negated := &Variable{Identifier: g.makeTemporaryVar(types.Long{})}
+		g.instructions = append(g.instructions,
+			&UnaryInstr{Operator: parser.UnopNegate, Src: rightVal, Dst: negated},
+			&AddPtrInstr{Ptr: leftVal, Index: negated, Scale: scale, Dst: dstVar})
+	case node.Op == parser.BinopSubtract:
+		scale := int64(types.Size(node.Left.GetType().(types.Pointer).Referenced))
+		diff := &Variable{Identifier: g.makeTemporaryVar(types.Long{})}
+		g.instructions = append(g.instructions,
+			&BinaryInstr{Operator: parser.BinopSubtract, Src1: leftVal, Src2: rightVal, Dst: diff},
+			&BinaryInstr{Operator: parser.BinopDivide, Src1: diff, Src2: &Constant{Value: types.ConstLong{Value: scale}}, Dst: dstVar})
+	default:
+		// Comparisons need no scaling
+		g.instructions = append(g.instructions, &BinaryInstr{Operator: node.Op, Src1: leftVal, Src2: rightVal, Dst: dstVar})
+	}
+	return dstVar
+}
+
+func isPointer(t types.Type) bool {
+	_, ok := t.(types.Pointer)
+	return ok
+}
+
+func isArray(t types.Type) bool {
+	_, ok := t.(types.Array)
+	return ok
+}
+
 func (g *TACGenerator) VisitAssignmentExp(node *parser.AssignmentExp) any {
-	right := node.Right.Accept(g).(Value)
-	left := node.Left.Accept(g).(*Variable)
-	g.instructions = append(g.instructions, &CopyInstr{Src: right, Dst: left})
-	return left
+	right := g.emitValue(node.Right)
+	left := node.Left.Accept(g)
+	g.writeLvalue(left, right)
+	if variable, isValue := left.(Value); isValue {
+		return variable
+	}
+	return right
+}
+
+// VisitCompoundAssignmentExp translates the left operand only once, so its side effects happen once,
+// and reads and writes the object through the same result
+func (g *TACGenerator) VisitCompoundAssignmentExp(node *parser.CompoundAssignmentExp) any {
+	leftType := node.Left.GetType()
+	left := node.Left.Accept(g)
+	current := g.emitConversion(g.readLvalue(left, leftType), leftType, node.ResultType)
+	right := g.emitValue(node.Right)
+
+	result := &Variable{Identifier: g.makeTemporaryVar(node.ResultType)}
+	if isPointer(leftType) {
+		scale := types.Size(leftType.(types.Pointer).Referenced)
+		if node.Op == parser.BinopSubtract {
+			negated := &Variable{Identifier: g.makeTemporaryVar(types.Long{})}
+			g.instructions = append(g.instructions, &UnaryInstr{Operator: parser.UnopNegate, Src: right, Dst: negated})
+			right = negated
+		}
+		g.instructions = append(g.instructions, &AddPtrInstr{Ptr: current, Index: right, Scale: scale, Dst: result})
+	} else {
+		g.instructions = append(g.instructions, &BinaryInstr{Operator: node.Op, Src1: current, Src2: right, Dst: result})
+	}
+
+	stored := g.emitConversion(result, node.ResultType, leftType)
+	g.writeLvalue(left, stored)
+	if variable, isValue := left.(Value); isValue {
+		return variable
+	}
+	return stored
+}
+
+// VisitIncDecFactor translates ++ and --, which add or subtract one like a compound assignment. The
+// postfix forms yield a copy of the value the object had before it was updated.
+func (g *TACGenerator) VisitIncDecFactor(node *parser.IncDecFactor) any {
+	t := node.GetType()
+	operand := node.Expr.Accept(g)
+	current := g.readLvalue(operand, t)
+
+	var old Value = current
+	if _, isVariable := operand.(Value); isVariable && node.Postfix {
+		// A variable is read in place, so its old value has to be saved before it changes
+		saved := &Variable{Identifier: g.makeTemporaryVar(t)}
+		g.instructions = append(g.instructions, &CopyInstr{Src: current, Dst: saved})
+		old = saved
+	}
+
+	var updated Value
+	if isPointer(t) {
+		index := int64(1)
+		if node.Decrement {
+			index = -1
+		}
+		dstVar := &Variable{Identifier: g.makeTemporaryVar(t)}
+		g.instructions = append(g.instructions, &AddPtrInstr{Ptr: current, Index: &Constant{Value: types.ConstLong{Value: index}},
+			Scale: types.Size(t.(types.Pointer).Referenced), Dst: dstVar})
+		updated = dstVar
+	} else {
+		// Character types are promoted to int for the arithmetic, as they would be for x = x + 1
+		workType := t
+		if types.IsCharacter(t) {
+			workType = types.Int{}
+		}
+		op := parser.BinopAdd
+		if node.Decrement {
+			op = parser.BinopSubtract
+		}
+		dstVar := &Variable{Identifier: g.makeTemporaryVar(workType)}
+		g.instructions = append(g.instructions, &BinaryInstr{Operator: op, Src1: g.emitConversion(current, t, workType),
+			Src2: &Constant{Value: types.ConvertConst(types.ConstInt{Value: 1}, workType)}, Dst: dstVar})
+		updated = g.emitConversion(dstVar, workType, t)
+	}
 
+	g.writeLvalue(operand, updated)
+	if node.Postfix {
+		return old
+	}
+	if variable, isValue := operand.(Value); isValue {
+		return variable
+	}
+	return updated
 }
 
 func (g *TACGenerator) VisitIdentifierFactor(node *parser.IdentifierFactor) any {
 	return &Variable{Identifier: node.Value}
 }
 
+func (g *TACGenerator) VisitFunctionCall(node *parser.FunctionCall) any {
+	args := make([]Value, len(node.Args))
+	for i, arg := range node.Args {
+		args[i] = g.emitValue(arg)
+	}
+
+	// A void function's result can only be discarded, so there's nothing to store it in
+	if _, returnsVoid := node.GetType().(types.Void); returnsVoid {
+		g.instructions = append(g.instructions, &FunCallInstr{Identifier: node.Name.Value, Args: args})
+		return nil
+	}
+
+	dstVar := &Variable{Identifier: g.makeTemporaryVar(node.GetType())}
+	g.instructions = append(g.instructions, &FunCallInstr{Identifier: node.Name.Value, Args: args, Dst: dstVar})
+	return dstVar
+}
+
+func (g *TACGenerator) VisitCastFactor(node *parser.CastFactor) any {
+	// Casting to void evaluates the operand for its side effects and throws the result away
+	if _, toVoid := node.TargetType.(types.Void); toVoid {
+		node.Expr.Accept(g)
+		return nil
+	}
+
+	return g.emitConversion(g.emitValue(node.Expr), node.Expr.GetType(), node.TargetType)
+}
+
+// emitConversion converts value from sourceType to targetType
+func (g *TACGenerator) emitConversion(value Value, sourceType, targetType types.Type) Value {
+	if types.Equal(targetType, sourceType) {
+		return value
+	}
+
+	dstVar := &Variable{Identifier: g.makeTemporaryVar(targetType)}
+	_, toDouble := targetType.(types.Double)
+	_, fromDouble := sourceType.(types.Double)
+	switch {
+	case toDouble && types.IsSigned(sourceType):
+		g.instructions = append(g.instructions, &IntToDoubleInstr{Src: value, Dst: dstVar})
+	case toDouble:
+		g.instructions = append(g.instructions, &UIntToDoubleInstr{Src: value, Dst: dstVar})
+	case fromDouble && types.IsSigned(targetType):
+		g.instructions = append(g.instructions, &DoubleToIntInstr{Src: value, Dst: dstVar})
+	case fromDouble:
+		g.instructions = append(g.instructions, &DoubleToUIntInstr{Src: value, Dst: dstVar})
+	case types.Size(targetType) == types.Size(sourceType):
+		g.instructions = append(g.instructions, &CopyInstr{Src: value, Dst: dstVar})
+	case types.Size(targetType) < types.Size(sourceType):
+		g.instructions = append(g.instructions, &TruncateInstr{Src: value, Dst: dstVar})
+	case types.IsSigned(sourceType):
+		g.instructions = append(g.instructions, &SignExtendInstr{Src: value, Dst: dstVar})
+	default:
+		g.instructions = append(g.instructions, &ZeroExtendInstr{Src: value, Dst: dstVar})
+	}
+	return dstVar
+}
+
 func (g *TACGenerator) VisitUnaryFactor(node *parser.UnaryFactor) interface{} {
 	// Visit the operand
-	sourceVal := node.Value.Accept(g).(Value)
+	sourceVal := g.emitValue(node.Value)
 
 	// Create a destination temporary variable
-	destVar := &Variable{Identifier: g.makeTemporaryVar()}
+	destVar := &Variable{Identifier: g.makeTemporaryVar(node.GetType())}
 
 	// Create unary instruction
 	unInstr := &UnaryInstr{
@@ -178,30 +546,144 @@ func (g *TACGenerator) VisitUnaryFactor(node *parser.UnaryFactor) interface{} {
 	return destVar
 }
 
-func (g *TACGenerator) VisitIntLiteral(node *parser.IntLiteral) interface{} {
+func (g *TACGenerator) VisitDereferenceFactor(node *parser.DereferenceFactor) any {
+	return &dereferencedPointer{ptr: g.emitValue(node.Expr)}
+}
+
+func (g *TACGenerator) VisitAddressOfFactor(node *parser.AddressOfFactor) any {
+	switch inner := node.Expr.Accept(g).(type) {
+	case *dereferencedPointer:
+		// &*p is just p
+		return inner.ptr
+	case *subObject:
+		base := &Variable{Identifier: g.makeTemporaryVar(node.GetType())}
+		g.instructions = append(g.instructions, &GetAddressInstr{Src: &Variable{Identifier: inner.base}, Dst: base})
+		return g.emitOffsetPointer(base, inner.offset, node.GetType())
+	case Value:
+		dstVar := &Variable{Identifier: g.makeTemporaryVar(node.GetType())}
+		g.instructions = append(g.instructions, &GetAddressInstr{Src: inner, Dst: dstVar})
+		return dstVar
+	default:
+		panic("invalid operand to address-of")
+	}
+}
+
+func (g *TACGenerator) VisitSubscriptFactor(node *parser.SubscriptFactor) any {
+	ptr := g.emitValue(node.Expr)
+	index := g.emitValue(node.Index)
+	dstVar := &Variable{Identifier: g.makeTemporaryVar(types.Pointer{Referenced: node.GetType()})}
+	g.instructions = append(g.instructions, &AddPtrInstr{Ptr: ptr, Index: index, Scale: types.Size(node.GetType()), Dst: dstVar})
+	return &dereferencedPointer{ptr: dstVar}
+}
+
+// VisitDotFactor finds the member within the struct the operand designates without reading anything
+func (g *TACGenerator) VisitDotFactor(node *parser.DotFactor) any {
+	structure := node.Expr.GetType().(types.Structure)
+	member, _ := structure.Def.Member(node.Member)
+	switch inner := node.Expr.Accept(g).(type) {
+	case *Variable:
+		return &subObject{base: inner.Identifier, offset: member.Offset}
+	case *subObject:
+		return &subObject{base: inner.base, offset: inner.offset + member.Offset}
+	case *dereferencedPointer:
+		return &dereferencedPointer{ptr: g.emitOffsetPointer(inner.ptr, member.Offset, types.Pointer{Referenced: node.GetType()})}
+	default:
+		panic("invalid operand to member access")
+	}
+}
+
+func (g *TACGenerator) VisitArrowFactor(node *parser.ArrowFactor) any {
+	structure := node.Expr.GetType().(types.Pointer).Referenced.(types.Structure)
+	member, _ := structure.Def.Member(node.Member)
+	ptr := g.emitValue(node.Expr)
+	return &dereferencedPointer{ptr: g.emitOffsetPointer(ptr, member.Offset, types.Pointer{Referenced: node.GetType()})}
+}
+
+// emitOffsetPointer returns ptr advanced by offset bytes, as a pointer of type t
+func (g *TACGenerator) emitOffsetPointer(ptr Value, offset int, t types.Type) Value {
+	if offset == 0 {
+		return ptr
+	}
+	dstVar := &Variable{Identifier: g.makeTemporaryVar(t)}
+	index := &Constant{Value: types.ConstLong{Value: int64(offset)}}
+	g.instructions = append(g.instructions, &AddPtrInstr{Ptr: ptr, Index: index, Scale: 1, Dst: dstVar})
+	return dstVar
+}
+
+// VisitTypedefDecl generates nothing; identifier resolution has already replaced every use of the name
+func (g *TACGenerator) VisitTypedefDecl(node *parser.TypedefDecl) any {
+	return nil
+}
+
+// VisitEnumDecl generates nothing; type checking has already replaced every enumerator with its value
+func (g *TACGenerator) VisitEnumDecl(node *parser.EnumDecl) any {
+	return nil
+}
+
+// VisitStaticAssertDecl generates nothing; the assertion was checked during type checking
+func (g *TACGenerator) VisitStaticAssertDecl(node *parser.StaticAssertDecl) any {
+	return nil
+}
+
+// VisitStructDecl generates nothing; a struct's layout only matters where its members are accessed
+func (g *TACGenerator) VisitStructDecl(node *parser.StructDecl) any {
+	return nil
+}
+
+// VisitStringLiteral places the string in a read-only constant; in an initializer for a char array
+// the literal is copied into the array instead and never gets here
+func (g *TACGenerator) VisitStringLiteral(node *parser.StringLiteral) any {
+	g.tempVarCounter++
+	name := fmt.Sprintf("string.%d", g.tempVarCounter)
+	init := symbols.StringInit{Value: node.Value, NullTerminated: true}
+	g.symbols.Add(name, &symbols.Symbol{Type: node.GetType(), Attrs: symbols.ConstantAttrs{Init: init}})
+	return &Variable{Identifier: name}
+}
+
+// VisitSizeOfExpFactor only looks at the operand's type; the operand itself is never evaluated
+func (g *TACGenerator) VisitSizeOfExpFactor(node *parser.SizeOfExpFactor) any {
+	return &Constant{Value: types.ConstULong{Value: uint64(types.Size(node.Expr.GetType()))}}
+}
+
+func (g *TACGenerator) VisitSizeOfTypeFactor(node *parser.SizeOfTypeFactor) any {
+	return &Constant{Value: types.ConstULong{Value: uint64(types.Size(node.TargetType))}}
+}
+
+func (g *TACGenerator) VisitConstant(node *parser.Constant) interface{} {
 	return &Constant{Value: node.Value}
 }
 
 func (g *TACGenerator) VisitConditionalExp(node *parser.ConditionalExp) any {
-	condition := node.Condition.Accept(g).(Value)
+	condition := g.emitValue(node.Condition)
 	e2Label := g.makeLabel("conditional_e2")
 	endLabel := g.makeLabel("conditional_end")
-	dstVar := &Variable{Identifier: g.makeTemporaryVar()}
+
+	// When both branches are void there's no result to copy out of them
+	if _, isVoid := node.GetType().(types.Void); isVoid {
+		g.instructions = append(g.instructions, &JumpIfZeroInstr{Condition: condition, Target: e2Label})
+		node.Expression1.Accept(g)
+		g.instructions = append(g.instructions, &JumpInstr{Identifier: endLabel}, &LabelInstr{Identifier: e2Label})
+		node.Expression2.Accept(g)
+		g.instructions = append(g.instructions, &LabelInstr{Identifier: endLabel})
+		return nil
+	}
+
+	dstVar := &Variable{Identifier: g.makeTemporaryVar(node.GetType())}
 
 	g.instructions = append(g.instructions, &JumpIfZeroInstr{Condition: condition, Target: e2Label})
 
-	v1 := node.Expression1.Accept(g).(Value)
+	v1 := g.emitValue(node.Expression1)
 
 	g.instructions = append(g.instructions, &CopyInstr{Src: v1, Dst: dstVar}, &JumpInstr{Identifier: endLabel}, &LabelInstr{Identifier: e2Label})
 
-	v2 := node.Expression2.Accept(g).(Value)
+	v2 := g.emitValue(node.Expression2)
 
 	g.instructions = append(g.instructions, &CopyInstr{Src: v2, Dst: dstVar}, &LabelInstr{Identifier: endLabel})
 	return dstVar
 }
 func (g *TACGenerator) VisitIfStatement(node *parser.IfStmt) any {
 	if node.Else == nil {
-		condition := node.Condition.Accept(g).(Value)
+		condition := g.emitValue(node.Condition)
 		endLabel := g.makeLabel("if_end")
 
 		g.instructions = append(g.instructions, &JumpIfZeroInstr{Condition: condition, Target: endLabel})
@@ -210,7 +692,7 @@ func (g *TACGenerator) VisitIfStatement(node *parser.IfStmt) any {
 		g.instructions = append(g.instructions, &LabelInstr{Identifier: endLabel})
 		return nil
 	} else {
-		condition := node.Condition.Accept(g).(Value)
+		condition := g.emitValue(node.Condition)
 		elseLabel := g.makeLabel("if_else")
 		endLabel := g.makeLabel("if_end")
 
@@ -237,8 +719,19 @@ func (g *TACGenerator) VisitContinueStatement(node *parser.ContinueStmt) any {
 	return instr
 }
 
+func (g *TACGenerator) VisitLabeledStatement(node *parser.LabeledStmt) any {
+	g.instructions = append(g.instructions, &LabelInstr{Identifier: node.Label})
+	node.Statement.Accept(g)
+	return nil
+}
+
+func (g *TACGenerator) VisitGotoStatement(node *parser.GotoStmt) any {
+	g.instructions = append(g.instructions, &JumpInstr{Identifier: node.Label})
+	return nil
+}
+
 func (g *TACGenerator) VisitDoWhileStatement(node *parser.DoWhileStmt) any {
-	conditionVar := &Variable{Identifier: g.makeTemporaryVar()}
+	conditionVar := &Variable{Identifier: g.makeTemporaryVar(node.Condition.GetType())}
 	startLabel := fmt.Sprint("start_", node.Label)
 	continueLabel := fmt.Sprint("continue_", node.Label)
 	breakLabel := fmt.Sprint("break_", node.Label)
@@ -246,13 +739,17 @@ func (g *TACGenerator) VisitDoWhileStatement(node *parser.DoWhileStmt) any {
 	g.instructions = append(g.instructions, &LabelInstr{Identifier: startLabel})
 	node.Body.Accept(g)
 	g.instructions = append(g.instructions, &LabelInstr{Identifier: continueLabel})
-	condition := node.Condition.Accept(g).(Value)
+	condition := g.emitValue(node.Condition)
 	g.instructions = append(g.instructions, &CopyInstr{Src: condition, Dst: conditionVar}, &JumpIfNotZeroInstr{Condition: conditionVar, Target: startLabel}, &LabelInstr{Identifier: breakLabel})
 	return nil
 }
 
 func (g *TACGenerator) VisitForStatement(node *parser.ForStmt) any {
-	conditionVar := &Variable{Identifier: g.makeTemporaryVar()}
+	var conditionType types.Type = types.Int{}
+	if node.Condition != nil {
+		conditionType = node.Condition.GetType()
+	}
+	conditionVar := &Variable{Identifier: g.makeTemporaryVar(conditionType)}
 	startLabel := fmt.Sprint("start_", node.Label)
 	breakLabel := fmt.Sprint("break_", node.Label)
 	continueLabel := fmt.Sprint("continue_", node.Label)
@@ -262,10 +759,10 @@ func (g *TACGenerator) VisitForStatement(node *parser.ForStmt) any {
 	}
 	g.instructions = append(g.instructions, &LabelInstr{Identifier: startLabel})
 	if node.Condition != nil {
-		condition := node.Condition.Accept(g).(Value)
+		condition := g.emitValue(node.Condition)
 		g.instructions = append(g.instructions, &CopyInstr{Src: condition, Dst: conditionVar}, &JumpIfZeroInstr{Condition: conditionVar, Target: breakLabel})
 	} else {
-		g.instructions = append(g.instructions, &JumpIfZeroInstr{Condition: &Constant{Value: 1}, Target: breakLabel})
+		g.instructions = append(g.instructions, &JumpIfZeroInstr{Condition: &Constant{Value: types.ConstInt{Value: 1}}, Target: breakLabel})
 	}
 	node.Body.Accept(g)
 	g.instructions = append(g.instructions, &LabelInstr{Identifier: continueLabel})
@@ -277,12 +774,12 @@ func (g *TACGenerator) VisitForStatement(node *parser.ForStmt) any {
 }
 
 func (g *TACGenerator) VisitWhileStatement(node *parser.WhileStmt) any {
-	conditionVar := &Variable{Identifier: g.makeTemporaryVar()}
+	conditionVar := &Variable{Identifier: g.makeTemporaryVar(node.Condition.GetType())}
 	continueLabel := fmt.Sprint("continue_", node.Label)
 	breakLabel := fmt.Sprint("break_", node.Label)
 
 	g.instructions = append(g.instructions, &LabelInstr{Identifier: continueLabel})
-	condition := node.Condition.Accept(g).(Value)
+	condition := g.emitValue(node.Condition)
 	g.instructions = append(g.instructions, &CopyInstr{Src: condition, Dst: conditionVar}, &JumpIfZeroInstr{Condition: conditionVar, Target: breakLabel})
 	node.Body.Accept(g)
 	g.instructions = append(g.instructions, &JumpInstr{Identifier: continueLabel}, &LabelInstr{Identifier: breakLabel})
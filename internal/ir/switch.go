@@ -0,0 +1,138 @@
+package ir
+
+import (
+	"acc/internal/common/types"
+	"acc/internal/parser"
+	"fmt"
+	"slices"
+)
+
+// minCaseTreeSize is the fewest cases worth a jump table or a binary search; smaller sets of cases
+// are compared one at a time
+const minCaseTreeSize = 4
+
+// maxJumpTableSpread is how many jump table entries each case may account for. Cases spread out more
+// thinly than this are searched instead, so a table is never more than this many times the number of
+// cases in size.
+const maxJumpTableSpread = 3
+
+// VisitSwitchStatement dispatches on the condition with a mix of binary search, jump tables and
+// compares, depending on how many cases there are and how closely their values are packed
+func (g *TACGenerator) VisitSwitchStatement(node *parser.SwitchStmt) any {
+	breakLabel := fmt.Sprint("break_", node.Label)
+	defaultLabel := breakLabel
+	if node.Default != nil {
+		defaultLabel = node.Default.Label
+	}
+
+	condition := g.emitValue(node.Condition)
+	cases := slices.Clone(node.Cases)
+	slices.SortFunc(cases, func(a, b *parser.CaseStmt) int {
+		switch {
+		case constLess(a.Value, b.Value):
+			return -1
+		case constLess(b.Value, a.Value):
+			return 1
+		default:
+			return 0
+		}
+	})
+	g.emitCaseDispatch(condition, node.Condition.GetType(), cases, defaultLabel)
+
+	node.Body.Accept(g)
+	g.instructions = append(g.instructions, &LabelInstr{Identifier: breakLabel})
+	return nil
+}
+
+func (g *TACGenerator) VisitCaseStatement(node *parser.CaseStmt) any {
+	g.instructions = append(g.instructions, &LabelInstr{Identifier: node.Label})
+	node.Statement.Accept(g)
+	return nil
+}
+
+func (g *TACGenerator) VisitDefaultStatement(node *parser.DefaultStmt) any {
+	g.instructions = append(g.instructions, &LabelInstr{Identifier: node.Label})
+	node.Statement.Accept(g)
+	return nil
+}
+
+// emitCaseDispatch jumps to the case whose value equals condition, which has type t, or to
+// defaultLabel if none does. cases must be sorted by value.
+func (g *TACGenerator) emitCaseDispatch(condition Value, t types.Type, cases []*parser.CaseStmt, defaultLabel string) {
+	switch {
+	case len(cases) < minCaseTreeSize:
+		for _, c := range cases {
+			matches := &Variable{Identifier: g.makeTemporaryVar(types.Int{})}
+			g.instructions = append(g.instructions,
+				&BinaryInstr{Operator: parser.BinopEqual, Src1: condition, Src2: &Constant{Value: c.Value}, Dst: matches},
+				&JumpIfNotZeroInstr{Condition: matches, Target: c.Label})
+		}
+		g.instructions = append(g.instructions, &JumpInstr{Identifier: defaultLabel})
+	case caseSpan(cases) < uint64(maxJumpTableSpread*len(cases)):
+		g.emitJumpTable(condition, t, cases, defaultLabel)
+	default:
+		// Split the cases around the middle one and search each half the same way, so dense runs
+		// of cases within a sparse switch still get their own jump tables
+		middle := len(cases) / 2
+		upperLabel := g.makeLabel("switch_upper")
+		isLower := &Variable{Identifier: g.makeTemporaryVar(types.Int{})}
+		g.instructions = append(g.instructions,
+			&BinaryInstr{Operator: parser.BinopLessThan, Src1: condition, Src2: &Constant{Value: cases[middle].Value}, Dst: isLower},
+			&JumpIfZeroInstr{Condition: isLower, Target: upperLabel})
+		g.emitCaseDispatch(condition, t, cases[:middle], defaultLabel)
+		g.instructions = append(g.instructions, &LabelInstr{Identifier: upperLabel})
+		g.emitCaseDispatch(condition, t, cases[middle:], defaultLabel)
+	}
+}
+
+// emitJumpTable jumps through a table with an entry for every value from the lowest case to the
+// highest, after sending values outside that range to defaultLabel
+func (g *TACGenerator) emitJumpTable(condition Value, t types.Type, cases []*parser.CaseStmt, defaultLabel string) {
+	span := caseSpan(cases)
+	targets := make([]string, span+1)
+	for i := range targets {
+		targets[i] = defaultLabel
+	}
+	for _, c := range cases {
+		targets[constDistance(cases[0].Value, c.Value)] = c.Label
+	}
+
+	// Subtracting the lowest value as unsigned turns every value below it into one above the highest,
+	// so a single comparison checks both ends of the range
+	unsigned := unsignedType(t)
+	index := &Variable{Identifier: g.makeTemporaryVar(unsigned)}
+	outOfRange := &Variable{Identifier: g.makeTemporaryVar(types.Int{})}
+	g.instructions = append(g.instructions,
+		&BinaryInstr{Operator: parser.BinopSubtract, Src1: g.emitConversion(condition, t, unsigned),
+			Src2: &Constant{Value: types.ConvertConst(cases[0].Value, unsigned)}, Dst: index},
+		&BinaryInstr{Operator: parser.BinopGreaterThan, Src1: index,
+			Src2: &Constant{Value: types.ConvertConst(types.ConstULong{Value: span}, unsigned)}, Dst: outOfRange},
+		&JumpIfNotZeroInstr{Condition: outOfRange, Target: defaultLabel})
+	g.instructions = append(g.instructions, &JumpTableInstr{Index: g.emitConversion(index, unsigned, types.ULong{}), Targets: targets})
+}
+
+// caseSpan is the difference between the highest and lowest of a sorted list of cases
+func caseSpan(cases []*parser.CaseStmt) uint64 {
+	return constDistance(cases[0].Value, cases[len(cases)-1].Value)
+}
+
+// constDistance is b - a for integer constants of the same type with a <= b
+func constDistance(a, b types.Const) uint64 {
+	return uint64(types.Int64(b)) - uint64(types.Int64(a))
+}
+
+// constLess reports whether a < b for integer constants of the same type
+func constLess(a, b types.Const) bool {
+	if types.IsSigned(a.Type()) {
+		return types.Int64(a) < types.Int64(b)
+	}
+	return uint64(types.Int64(a)) < uint64(types.Int64(b))
+}
+
+// unsignedType is the unsigned integer type the same size as the promoted integer type t
+func unsignedType(t types.Type) types.Type {
+	if types.Size(t) == 8 {
+		return types.ULong{}
+	}
+	return types.UInt{}
+}
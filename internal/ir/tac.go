@@ -1,6 +1,8 @@
 package ir
 
 import (
+	"acc/internal/common/symbols"
+	"acc/internal/common/types"
 	"acc/internal/parser"
 )
 
@@ -10,6 +12,8 @@ type TacNode interface {
 type TacVisitor interface {
 	VisitProgram(node *Program) any
 	VisitFunction(node *Function) any
+	VisitStaticVariable(node *StaticVariable) any
+	VisitStaticConstant(node *StaticConstant) any
 	VisitReturnInstr(node *ReturnInstr) any
 	VisitUnaryInstr(node *UnaryInstr) any
 	VisitBinaryInstr(node *BinaryInstr) any
@@ -18,6 +22,22 @@ type TacVisitor interface {
 	VisitJumpIfZeroInstr(node *JumpIfZeroInstr) any
 	VisitJumpIfNotZeroInstr(node *JumpIfNotZeroInstr) any
 	VisitLabelInstr(node *LabelInstr) any
+	VisitJumpTableInstr(node *JumpTableInstr) any
+	VisitFunCallInstr(node *FunCallInstr) any
+	VisitSignExtendInstr(node *SignExtendInstr) any
+	VisitTruncateInstr(node *TruncateInstr) any
+	VisitZeroExtendInstr(node *ZeroExtendInstr) any
+	VisitDoubleToIntInstr(node *DoubleToIntInstr) any
+	VisitDoubleToUIntInstr(node *DoubleToUIntInstr) any
+	VisitIntToDoubleInstr(node *IntToDoubleInstr) any
+	VisitUIntToDoubleInstr(node *UIntToDoubleInstr) any
+	VisitGetAddressInstr(node *GetAddressInstr) any
+	VisitLoadInstr(node *LoadInstr) any
+	VisitStoreInstr(node *StoreInstr) any
+	VisitAddPtrInstr(node *AddPtrInstr) any
+	VisitCopyToOffsetInstr(node *CopyToOffsetInstr) any
+	VisitCopyFromOffsetInstr(node *CopyFromOffsetInstr) any
+	VisitVaStartInstr(node *VaStartInstr) any
 	VisitConstant(node *Constant) any
 	VisitVariable(node *Variable) any
 }
@@ -27,13 +47,18 @@ type Instruction interface {
 	instr()
 }
 
+type TopLevel interface {
+	TacNode
+	topLevel()
+}
+
 type Value interface {
 	TacNode
 	val()
 }
 
 type Program struct {
-	Function Function
+	TopLevel []TopLevel
 }
 
 func (p *Program) Accept(visitor TacVisitor) any {
@@ -42,13 +67,44 @@ func (p *Program) Accept(visitor TacVisitor) any {
 
 type Function struct {
 	Identifier string
+	Global     bool
+	Params     []string
 	Body       []Instruction
 }
 
+func (f *Function) topLevel() {}
+
 func (p *Function) Accept(visitor TacVisitor) any {
 	return visitor.VisitFunction(p)
 }
 
+type StaticVariable struct {
+	Identifier string
+	Global     bool
+	Type       types.Type
+	Init       []symbols.StaticInit
+}
+
+func (v *StaticVariable) topLevel() {}
+
+func (p *StaticVariable) Accept(visitor TacVisitor) any {
+	return visitor.VisitStaticVariable(p)
+}
+
+// StaticConstant is a read-only object, such as the array behind a string literal
+type StaticConstant struct {
+	Identifier string
+	Type       types.Type
+	Init       symbols.StaticInit
+}
+
+func (c *StaticConstant) topLevel() {}
+
+func (p *StaticConstant) Accept(visitor TacVisitor) any {
+	return visitor.VisitStaticConstant(p)
+}
+
+// ReturnInstr returns Value from the function, or nothing if Value is nil
 type ReturnInstr struct {
 	Value Value
 }
@@ -132,8 +188,183 @@ func (p *LabelInstr) Accept(visitor TacVisitor) any {
 	return visitor.VisitLabelInstr(p)
 }
 
+// JumpTableInstr jumps to Targets[Index]. Index is an unsigned long that has already been checked
+// to be in range.
+type JumpTableInstr struct {
+	Index   Value
+	Targets []string
+}
+
+func (i *JumpTableInstr) instr() {}
+func (p *JumpTableInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitJumpTableInstr(p)
+}
+
+// FunCallInstr calls a function and stores its result in Dst, which is nil for void functions
+type FunCallInstr struct {
+	Identifier string
+	Args       []Value
+	Dst        Value
+}
+
+func (i *FunCallInstr) instr() {}
+func (p *FunCallInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitFunCallInstr(p)
+}
+
+type SignExtendInstr struct {
+	Src Value
+	Dst Value
+}
+
+func (i *SignExtendInstr) instr() {}
+func (p *SignExtendInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitSignExtendInstr(p)
+}
+
+type TruncateInstr struct {
+	Src Value
+	Dst Value
+}
+
+func (i *TruncateInstr) instr() {}
+func (p *TruncateInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitTruncateInstr(p)
+}
+
+type ZeroExtendInstr struct {
+	Src Value
+	Dst Value
+}
+
+func (i *ZeroExtendInstr) instr() {}
+func (p *ZeroExtendInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitZeroExtendInstr(p)
+}
+
+type DoubleToIntInstr struct {
+	Src Value
+	Dst Value
+}
+
+func (i *DoubleToIntInstr) instr() {}
+func (p *DoubleToIntInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitDoubleToIntInstr(p)
+}
+
+type DoubleToUIntInstr struct {
+	Src Value
+	Dst Value
+}
+
+func (i *DoubleToUIntInstr) instr() {}
+func (p *DoubleToUIntInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitDoubleToUIntInstr(p)
+}
+
+type IntToDoubleInstr struct {
+	Src Value
+	Dst Value
+}
+
+func (i *IntToDoubleInstr) instr() {}
+func (p *IntToDoubleInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitIntToDoubleInstr(p)
+}
+
+type UIntToDoubleInstr struct {
+	Src Value
+	Dst Value
+}
+
+func (i *UIntToDoubleInstr) instr() {}
+func (p *UIntToDoubleInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitUIntToDoubleInstr(p)
+}
+
+// GetAddressInstr stores the address of Src in Dst
+type GetAddressInstr struct {
+	Src Value
+	Dst Value
+}
+
+func (i *GetAddressInstr) instr() {}
+func (p *GetAddressInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitGetAddressInstr(p)
+}
+
+// LoadInstr copies the object SrcPtr points to into Dst
+type LoadInstr struct {
+	SrcPtr Value
+	Dst    Value
+}
+
+func (i *LoadInstr) instr() {}
+func (p *LoadInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitLoadInstr(p)
+}
+
+// StoreInstr copies Src into the object DstPtr points to
+type StoreInstr struct {
+	Src    Value
+	DstPtr Value
+}
+
+func (i *StoreInstr) instr() {}
+func (p *StoreInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitStoreInstr(p)
+}
+
+// AddPtrInstr computes Ptr + Index * Scale, where Scale is the size in bytes of the element Ptr points to
+type AddPtrInstr struct {
+	Ptr   Value
+	Index Value
+	Scale int
+	Dst   Value
+}
+
+func (i *AddPtrInstr) instr() {}
+func (p *AddPtrInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitAddPtrInstr(p)
+}
+
+// CopyToOffsetInstr copies Src into the variable Dst, Offset bytes from its start
+type CopyToOffsetInstr struct {
+	Src    Value
+	Dst    string
+	Offset int
+}
+
+func (i *CopyToOffsetInstr) instr() {}
+func (p *CopyToOffsetInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitCopyToOffsetInstr(p)
+}
+
+// CopyFromOffsetInstr copies the part of the variable Src starting Offset bytes from its start into Dst
+type CopyFromOffsetInstr struct {
+	Src    string
+	Offset int
+	Dst    Value
+}
+
+func (i *CopyFromOffsetInstr) instr() {}
+func (p *CopyFromOffsetInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitCopyFromOffsetInstr(p)
+}
+
+// VaStartInstr initializes the va_list List points to, so that va_arg starts from the first
+// argument after the named parameters of the current function
+type VaStartInstr struct {
+	List Value
+}
+
+func (i *VaStartInstr) instr() {}
+func (p *VaStartInstr) Accept(visitor TacVisitor) any {
+	return visitor.VisitVaStartInstr(p)
+}
+
 type Constant struct {
-	Value int
+	Value types.Const
 }
 
 func (v *Constant) val() {}
@@ -0,0 +1,82 @@
+package ir
+
+import (
+	"acc/internal/common/types"
+	"acc/internal/parser"
+)
+
+// VisitVaBuiltinFactor translates the va_list builtins. Each one's first argument has already been
+// converted to a pointer to the structure the va_list is made of.
+func (g *TACGenerator) VisitVaBuiltinFactor(node *parser.VaBuiltinFactor) any {
+	list := g.emitValue(node.Args[0])
+	switch node.Builtin {
+	case parser.VaStart:
+		// Only the code generator knows how many registers the named parameters take up
+		g.instructions = append(g.instructions, &VaStartInstr{List: list})
+	case parser.VaArg:
+		return g.emitVaArg(list, node.ArgType)
+	case parser.VaCopy:
+		src := g.emitValue(node.Args[1])
+		tmp := &Variable{Identifier: g.makeTemporaryVar(types.VaListTag())}
+		g.instructions = append(g.instructions, &LoadInstr{SrcPtr: src, Dst: tmp}, &StoreInstr{Src: tmp, DstPtr: list})
+	case parser.VaEnd:
+		// There's nothing to clean up
+	}
+	return nil
+}
+
+// emitVaArg fetches the next variadic argument of type t. A double comes from the XMM part of the
+// register save area and anything else from the general purpose part, until that part is used up;
+// after that arguments come from the stack, where each one takes up an eightbyte.
+func (g *TACGenerator) emitVaArg(list Value, t types.Type) Value {
+	tag := types.VaListTag().Def
+	offsetMember, _ := tag.Member("gp_offset")
+	limit, step := types.GPSaveAreaSize, 8
+	if _, isDouble := t.(types.Double); isDouble {
+		offsetMember, _ = tag.Member("fp_offset")
+		limit, step = types.RegSaveAreaSize, 16
+	}
+	saveAreaMember, _ := tag.Member("reg_save_area")
+	overflowMember, _ := tag.Member("overflow_arg_area")
+
+	voidPointer := types.Pointer{Referenced: types.Void{}}
+	argPtr := &Variable{Identifier: g.makeTemporaryVar(types.Pointer{Referenced: t})}
+	overflowLabel := g.makeLabel("va_arg_overflow")
+	endLabel := g.makeLabel("va_arg_end")
+
+	offsetPtr := g.emitOffsetPointer(list, offsetMember.Offset, types.Pointer{Referenced: types.UInt{}})
+	offset := &Variable{Identifier: g.makeTemporaryVar(types.UInt{})}
+	usedUp := &Variable{Identifier: g.makeTemporaryVar(types.Int{})}
+	g.instructions = append(g.instructions,
+		&LoadInstr{SrcPtr: offsetPtr, Dst: offset},
+		&BinaryInstr{Operator: parser.BinopGreaterOrEqual, Src1: offset, Src2: &Constant{Value: types.ConstUInt{Value: uint32(limit)}}, Dst: usedUp},
+		&JumpIfNotZeroInstr{Condition: usedUp, Target: overflowLabel},
+	)
+
+	saveAreaPtr := g.emitOffsetPointer(list, saveAreaMember.Offset, types.Pointer{Referenced: voidPointer})
+	saveArea := &Variable{Identifier: g.makeTemporaryVar(voidPointer)}
+	longOffset := &Variable{Identifier: g.makeTemporaryVar(types.ULong{})}
+	nextOffset := &Variable{Identifier: g.makeTemporaryVar(types.UInt{})}
+	g.instructions = append(g.instructions,
+		&LoadInstr{SrcPtr: saveAreaPtr, Dst: saveArea},
+		&ZeroExtendInstr{Src: offset, Dst: longOffset},
+		&AddPtrInstr{Ptr: saveArea, Index: longOffset, Scale: 1, Dst: argPtr},
+		&BinaryInstr{Operator: parser.BinopAdd, Src1: offset, Src2: &Constant{Value: types.ConstUInt{Value: uint32(step)}}, Dst: nextOffset},
+		&StoreInstr{Src: nextOffset, DstPtr: offsetPtr},
+		&JumpInstr{Identifier: endLabel},
+		&LabelInstr{Identifier: overflowLabel},
+	)
+
+	overflowPtr := g.emitOffsetPointer(list, overflowMember.Offset, types.Pointer{Referenced: voidPointer})
+	overflowArea := &Variable{Identifier: g.makeTemporaryVar(voidPointer)}
+	nextArea := &Variable{Identifier: g.makeTemporaryVar(voidPointer)}
+	g.instructions = append(g.instructions,
+		&LoadInstr{SrcPtr: overflowPtr, Dst: overflowArea},
+		&CopyInstr{Src: overflowArea, Dst: argPtr},
+		&AddPtrInstr{Ptr: overflowArea, Index: &Constant{Value: types.ConstLong{Value: 8}}, Scale: 1, Dst: nextArea},
+		&StoreInstr{Src: nextArea, DstPtr: overflowPtr},
+		&LabelInstr{Identifier: endLabel},
+	)
+
+	return g.readLvalue(&dereferencedPointer{ptr: argPtr}, t)
+}
@@ -53,24 +53,52 @@ func (l *Lexer) scanToken() error {
 		l.addToken(TokenOpenBrace, "{")
 	case '}':
 		l.addToken(TokenCloseBrace, "}")
+	case '[':
+		l.addToken(TokenOpenBracket, "[")
+	case ']':
+		l.addToken(TokenCloseBracket, "]")
 	case ';':
 		l.addToken(TokenSemicolon, ";")
+	case ',':
+		l.addToken(TokenComma, ",")
 	case '~':
 		l.addToken(TokenBitwiseCompOp, "~")
 	case '-':
 		if l.match('-') {
 			l.addToken(TokenDecrementOp, "--")
+		} else if l.match('>') {
+			l.addToken(TokenArrow, "->")
+		} else if l.match('=') {
+			l.addToken(TokenSubtractAssignOp, "-=")
 		} else {
 			l.addToken(TokenNegationOp, "-")
 		}
 	case '+':
-		l.addToken(TokenAdditionOp, "+")
+		if l.match('+') {
+			l.addToken(TokenIncrementOp, "++")
+		} else if l.match('=') {
+			l.addToken(TokenAddAssignOp, "+=")
+		} else {
+			l.addToken(TokenAdditionOp, "+")
+		}
 	case '*':
-		l.addToken(TokenMultiplicationOp, "*")
+		if l.match('=') {
+			l.addToken(TokenMultiplyAssignOp, "*=")
+		} else {
+			l.addToken(TokenMultiplicationOp, "*")
+		}
 	case '/':
-		l.addToken(TokenDivisionOp, "/")
+		if l.match('=') {
+			l.addToken(TokenDivideAssignOp, "/=")
+		} else {
+			l.addToken(TokenDivisionOp, "/")
+		}
 	case '%':
-		l.addToken(TokenRemainderOp, "%")
+		if l.match('=') {
+			l.addToken(TokenRemainderAssignOp, "%=")
+		} else {
+			l.addToken(TokenRemainderOp, "%")
+		}
 	case '!':
 		if l.match('=') {
 			l.addToken(TokenNotEqualOp, "!=")
@@ -80,23 +108,45 @@ func (l *Lexer) scanToken() error {
 	case '&':
 		if l.match('&') {
 			l.addToken(TokenAndOp, "&&")
+		} else if l.match('=') {
+			l.addToken(TokenBitwiseAndAssignOp, "&=")
 		} else {
-			return errors.NewLexError("Invalid token: &", l.currentLocation())
+			l.addToken(TokenAmpersand, "&")
 		}
 	case '|':
 		if l.match('|') {
 			l.addToken(TokenOrOp, "||")
+		} else if l.match('=') {
+			l.addToken(TokenBitwiseOrAssignOp, "|=")
 		} else {
-			return errors.NewLexError("Invalid token: |", l.currentLocation())
+			l.addToken(TokenBitwiseOrOp, "|")
 		}
-	case '<':
+	case '^':
 		if l.match('=') {
+			l.addToken(TokenBitwiseXorAssignOp, "^=")
+		} else {
+			l.addToken(TokenBitwiseXorOp, "^")
+		}
+	case '<':
+		if l.match('<') {
+			if l.match('=') {
+				l.addToken(TokenShiftLeftAssignOp, "<<=")
+			} else {
+				l.addToken(TokenShiftLeftOp, "<<")
+			}
+		} else if l.match('=') {
 			l.addToken(TokenLessOrEqualOp, "<=")
 		} else {
 			l.addToken(TokenLessThanOp, "<")
 		}
 	case '>':
-		if l.match('=') {
+		if l.match('>') {
+			if l.match('=') {
+				l.addToken(TokenShiftRightAssignOp, ">>=")
+			} else {
+				l.addToken(TokenShiftRightOp, ">>")
+			}
+		} else if l.match('=') {
 			l.addToken(TokenGreaterOrEqualOp, ">=")
 		} else {
 			l.addToken(TokenGreaterThanOp, ">")
@@ -111,6 +161,21 @@ func (l *Lexer) scanToken() error {
 		l.addToken(TokenConditionalOpFront, "?")
 	case ':':
 		l.addToken(TokenConditionalOpEnd, ":")
+	case '\'':
+		return l.charConstant()
+	case '"':
+		return l.stringLiteral()
+	case '.':
+		if isDigit(l.peek()) {
+			return l.number()
+		}
+		if l.peek() == '.' && l.current+1 < len(l.source) && l.source[l.current+1] == '.' {
+			l.advance()
+			l.advance()
+			l.addToken(TokenEllipsis, "...")
+		} else {
+			l.addToken(TokenDot, ".")
+		}
 
 	// Ignore whitespace
 	case ' ', '\t', '\r':
@@ -138,19 +203,214 @@ func (l *Lexer) scanToken() error {
 func (l *Lexer) number() error {
 	startLoc := l.currentLocation()
 
-	for !l.isAtEnd() && isDigit(l.peek()) {
+	if l.source[l.start] == '0' && (l.peek() == 'x' || l.peek() == 'X') {
+		return l.hexNumber()
+	}
+
+	l.digits(isDigit)
+	digits := l.source[l.start:l.current]
+
+	// A fractional part or an exponent makes this a floating constant
+	isFloat := l.source[l.start] == '.'
+	if !isFloat && l.peek() == '.' {
+		l.advance()
+		l.digits(isDigit)
+		isFloat = true
+	}
+	if l.peek() == 'e' || l.peek() == 'E' {
+		l.advance()
+		if err := l.exponent(startLoc); err != nil {
+			return err
+		}
+		isFloat = true
+	}
+	if isFloat {
+		return l.endFloat(startLoc)
+	}
+	return l.endInteger(startLoc, digits)
+}
+
+// endInteger scans any suffixes on an integer constant whose digits are literal, and adds its token
+func (l *Lexer) endInteger(startLoc errors.Location, literal string) error {
+	// The u and l suffixes may appear in either order
+	unsigned, long := false, false
+	for !l.isAtEnd() {
+		if !unsigned && (l.peek() == 'u' || l.peek() == 'U') {
+			unsigned = true
+		} else if !long && (l.peek() == 'l' || l.peek() == 'L') {
+			long = true
+		} else {
+			break
+		}
 		l.advance()
 	}
 
+	tokenType := TokenConstant
+	switch {
+	case unsigned && long:
+		tokenType = TokenUnsignedLongConstant
+	case unsigned:
+		tokenType = TokenUnsignedConstant
+	case long:
+		tokenType = TokenLongConstant
+	}
+
 	// Check for invalid identifiers immediately after number
-	if !l.isAtEnd() && isAlpha(l.peek()) {
+	if !l.isAtEnd() && (isAlphaNumeric(l.peek()) || l.peek() == '.') {
 		return errors.NewLexError("Invalid number", startLoc)
 	}
 
-	l.addToken(TokenConstant, l.source[l.start:l.current])
+	l.addToken(tokenType, literal)
+	return nil
+}
+
+// hexNumber scans a hexadecimal constant. Without a fractional part or a binary exponent it's an
+// integer constant such as 0xFFu, whose literal keeps the 0x prefix; otherwise it's a floating
+// constant such as 0x1.8p3, where the exponent is required.
+func (l *Lexer) hexNumber() error {
+	startLoc := l.currentLocation()
+	l.advance()
+
+	digitsStart := l.current
+	l.digits(isHexDigit)
+	if l.peek() != '.' && l.peek() != 'p' && l.peek() != 'P' {
+		if l.current == digitsStart {
+			return errors.NewLexError("Invalid number", startLoc)
+		}
+		return l.endInteger(startLoc, l.source[l.start:l.current])
+	}
+	if l.peek() == '.' {
+		l.advance()
+		l.digits(isHexDigit)
+	}
+	if l.peek() != 'p' && l.peek() != 'P' {
+		return errors.NewLexError("Invalid number", startLoc)
+	}
+	l.advance()
+	if err := l.exponent(startLoc); err != nil {
+		return err
+	}
+	return l.endFloat(startLoc)
+}
+
+// exponent scans the signed digits following an exponent marker
+func (l *Lexer) exponent(startLoc errors.Location) error {
+	if l.peek() == '+' || l.peek() == '-' {
+		l.advance()
+	}
+	if !isDigit(l.peek()) {
+		return errors.NewLexError("Invalid number", startLoc)
+	}
+	l.digits(isDigit)
 	return nil
 }
 
+// endFloat checks that nothing is stuck to the end of a floating constant and adds its token
+func (l *Lexer) endFloat(startLoc errors.Location) error {
+	if !l.isAtEnd() && (isAlphaNumeric(l.peek()) || l.peek() == '.') {
+		return errors.NewLexError("Invalid number", startLoc)
+	}
+	l.addToken(TokenDoubleConstant, l.source[l.start:l.current])
+	return nil
+}
+
+// charConstant scans a character constant such as 'a' or '\n'; the token's literal is the single byte it denotes
+func (l *Lexer) charConstant() error {
+	startLoc := l.currentLocation()
+	if l.isAtEnd() || l.peek() == '\'' || l.peek() == '\n' {
+		return errors.NewLexError("Invalid character constant", startLoc)
+	}
+
+	c := l.advance()
+	if c == '\\' {
+		var err error
+		c, err = l.escape(startLoc)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !l.match('\'') {
+		return errors.NewLexError("Invalid character constant", startLoc)
+	}
+	l.addToken(TokenCharConstant, string([]byte{c}))
+	return nil
+}
+
+// stringLiteral scans a string literal; the token's literal holds the bytes it denotes, with escapes already processed
+func (l *Lexer) stringLiteral() error {
+	startLoc := l.currentLocation()
+	value := []byte{}
+	for !l.match('"') {
+		if l.isAtEnd() || l.peek() == '\n' {
+			return errors.NewLexError("Unterminated string literal", startLoc)
+		}
+
+		c := l.advance()
+		if c == '\\' {
+			var err error
+			c, err = l.escape(startLoc)
+			if err != nil {
+				return err
+			}
+		}
+		value = append(value, c)
+	}
+	l.addToken(TokenStringLiteral, string(value))
+	return nil
+}
+
+// simpleEscapes maps the character after a backslash to the byte it stands for
+var simpleEscapes = map[byte]byte{
+	'\'': '\'', '"': '"', '?': '?', '\\': '\\',
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+}
+
+// escape scans the rest of an escape sequence after its backslash
+func (l *Lexer) escape(startLoc errors.Location) (byte, error) {
+	if l.isAtEnd() {
+		return 0, errors.NewLexError("Invalid escape sequence", startLoc)
+	}
+
+	c := l.advance()
+	if value, ok := simpleEscapes[c]; ok {
+		return value, nil
+	}
+
+	// Octal escapes take up to three digits; hex escapes take as many digits as follow
+	value := 0
+	switch {
+	case isOctalDigit(c):
+		value = int(c - '0')
+		for i := 0; i < 2 && isOctalDigit(l.peek()); i++ {
+			value = value*8 + int(l.advance()-'0')
+		}
+	case c == 'x':
+		if !isHexDigit(l.peek()) {
+			return 0, errors.NewLexError("Invalid escape sequence", startLoc)
+		}
+		for isHexDigit(l.peek()) {
+			value = value*16 + hexValue(l.advance())
+			if value > 0xff {
+				return 0, errors.NewLexError("Escape sequence out of range", startLoc)
+			}
+		}
+	default:
+		return 0, errors.NewLexError("Invalid escape sequence", startLoc)
+	}
+
+	if value > 0xff {
+		return 0, errors.NewLexError("Escape sequence out of range", startLoc)
+	}
+	return byte(value), nil
+}
+
+func (l *Lexer) digits(accept func(byte) bool) {
+	for !l.isAtEnd() && accept(l.peek()) {
+		l.advance()
+	}
+}
+
 func (l *Lexer) identifier() {
 	for !l.isAtEnd() && isAlphaNumeric(l.peek()) {
 		l.advance()
@@ -207,6 +467,25 @@ func isDigit(c byte) bool {
 	return c >= '0' && c <= '9'
 }
 
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isOctalDigit(c byte) bool {
+	return c >= '0' && c <= '7'
+}
+
+func hexValue(c byte) int {
+	switch {
+	case isDigit(c):
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	default:
+		return int(c-'A') + 10
+	}
+}
+
 func isAlpha(c byte) bool {
 	return (c >= 'a' && c <= 'z') ||
 		(c >= 'A' && c <= 'Z') ||
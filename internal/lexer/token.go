@@ -10,7 +10,13 @@ const (
 	TokenCloseParen
 	TokenOpenBrace
 	TokenCloseBrace
+	TokenOpenBracket
+	TokenCloseBracket
 	TokenSemicolon
+	TokenComma
+	TokenDot
+	TokenArrow
+	TokenEllipsis
 
 	TokenConditionalOpFront
 	TokenConditionalOpEnd
@@ -18,10 +24,25 @@ const (
 	// Literals
 	TokenIdentifier
 	TokenConstant
+	TokenLongConstant
+	TokenUnsignedConstant
+	TokenUnsignedLongConstant
+	TokenDoubleConstant
+	TokenCharConstant
+	TokenStringLiteral
 
 	// Keywords
 	TokenInt
+	TokenLong
+	TokenSigned
+	TokenUnsigned
+	TokenDouble
+	TokenChar
 	TokenVoid
+	TokenStruct
+	TokenUnion
+	TokenEnum
+	TokenSizeof
 	TokenReturn
 	TokenIf
 	TokenElse
@@ -30,17 +51,35 @@ const (
 	TokenFor
 	TokenBreak
 	TokenContinue
+	TokenGoto
+	TokenSwitch
+	TokenCase
+	TokenDefault
+	TokenStatic
+	TokenExtern
+	TokenTypedef
+	TokenStaticAssert
+	TokenVaStart
+	TokenVaArg
+	TokenVaEnd
+	TokenVaCopy
 
 	// Unary Operators
 	TokenBitwiseCompOp
 	TokenNegationOp
+	TokenAmpersand
 
 	// Binary Operators
+	TokenIncrementOp
 	TokenDecrementOp
 	TokenAdditionOp
 	TokenMultiplicationOp
 	TokenDivisionOp
 	TokenRemainderOp
+	TokenBitwiseOrOp
+	TokenBitwiseXorOp
+	TokenShiftLeftOp
+	TokenShiftRightOp
 
 	// Logical Operators
 	TokenNotOp
@@ -56,6 +95,18 @@ const (
 	TokenGreaterOrEqualOp
 
 	TokenAssignmentOp
+
+	// Compound Assignment Operators
+	TokenAddAssignOp
+	TokenSubtractAssignOp
+	TokenMultiplyAssignOp
+	TokenDivideAssignOp
+	TokenRemainderAssignOp
+	TokenBitwiseAndAssignOp
+	TokenBitwiseOrAssignOp
+	TokenBitwiseXorAssignOp
+	TokenShiftLeftAssignOp
+	TokenShiftRightAssignOp
 )
 
 type Token struct {
@@ -74,7 +125,16 @@ func NewToken(tokenType TokenType, literal string, loc errors.Location) Token {
 
 var Keywords = map[string]TokenType{
 	"int":      TokenInt,
+	"long":     TokenLong,
+	"char":     TokenChar,
+	"signed":   TokenSigned,
+	"unsigned": TokenUnsigned,
+	"double":   TokenDouble,
 	"void":     TokenVoid,
+	"struct":   TokenStruct,
+	"union":    TokenUnion,
+	"enum":     TokenEnum,
+	"sizeof":   TokenSizeof,
 	"return":   TokenReturn,
 	"if":       TokenIf,
 	"else":     TokenElse,
@@ -83,4 +143,19 @@ var Keywords = map[string]TokenType{
 	"for":      TokenFor,
 	"break":    TokenBreak,
 	"continue": TokenContinue,
+	"goto":     TokenGoto,
+	"switch":   TokenSwitch,
+	"case":     TokenCase,
+	"default":  TokenDefault,
+	"static":   TokenStatic,
+	"extern":   TokenExtern,
+	"typedef":  TokenTypedef,
+	// static_assert is the C23 spelling of _Static_assert
+	"_Static_assert": TokenStaticAssert,
+	"static_assert":  TokenStaticAssert,
+	// <stdarg.h> defines its macros in terms of these builtins
+	"__builtin_va_start": TokenVaStart,
+	"__builtin_va_arg":   TokenVaArg,
+	"__builtin_va_end":   TokenVaEnd,
+	"__builtin_va_copy":  TokenVaCopy,
 }
@@ -1,9 +1,14 @@
 package parser
 
-import "acc/internal/common/errors"
+import (
+	"acc/internal/common/errors"
+	"acc/internal/common/types"
+)
 
 type BinopType int
 type UnopType int
+type StorageClass int
+type VaBuiltin int
 
 const (
 	BinopAdd BinopType = iota
@@ -19,6 +24,11 @@ const (
 	BinopLessOrEqual
 	BinopGreaterThan
 	BinopGreaterOrEqual
+	BinopBitwiseAnd
+	BinopBitwiseOr
+	BinopBitwiseXor
+	BinopShiftLeft
+	BinopShiftRight
 )
 
 const (
@@ -27,22 +37,59 @@ const (
 	UnopNot
 )
 
+const (
+	StorageClassNone StorageClass = iota
+	StorageClassStatic
+	StorageClassExtern
+	// StorageClassTypedef only exists while parsing; a declaration with it becomes a TypedefDecl
+	StorageClassTypedef
+)
+
+const (
+	VaStart VaBuiltin = iota
+	VaArg
+	VaEnd
+	VaCopy
+)
+
 type AstVisitor interface {
 	VisitProgram(node *Program) any
-	VisitFunction(node *Function) any
+	VisitFunctionDecl(node *FunctionDecl) any
 	VisitReturnStatement(node *ReturnStmt) any
 	VisitIfStatement(node *IfStmt) any
 	VisitNullStatement(node *NullStmt) any
-	VisitDeclaration(node *Declaration) any
+	VisitVarDecl(node *VarDecl) any
+	VisitStructDecl(node *StructDecl) any
+	VisitTypedefDecl(node *TypedefDecl) any
+	VisitEnumDecl(node *EnumDecl) any
+	VisitStaticAssertDecl(node *StaticAssertDecl) any
 	VisitBinaryExp(node *BinaryExp) any
 	VisitAssignmentExp(node *AssignmentExp) any
+	VisitCompoundAssignmentExp(node *CompoundAssignmentExp) any
 	VisitConditionalExp(node *ConditionalExp) any
 	VisitUnaryFactor(node *UnaryFactor) any
+	VisitIncDecFactor(node *IncDecFactor) any
 	VisitIdentifierFactor(node *IdentifierFactor) any
-	VisitIntLiteral(node *IntLiteral) any
+	VisitFunctionCall(node *FunctionCall) any
+	VisitCastFactor(node *CastFactor) any
+	VisitDereferenceFactor(node *DereferenceFactor) any
+	VisitAddressOfFactor(node *AddressOfFactor) any
+	VisitSubscriptFactor(node *SubscriptFactor) any
+	VisitDotFactor(node *DotFactor) any
+	VisitArrowFactor(node *ArrowFactor) any
+	VisitSizeOfExpFactor(node *SizeOfExpFactor) any
+	VisitSizeOfTypeFactor(node *SizeOfTypeFactor) any
+	VisitVaBuiltinFactor(node *VaBuiltinFactor) any
+	VisitConstant(node *Constant) any
+	VisitStringLiteral(node *StringLiteral) any
 	VisitBlock(node *Block) any
 	VisitBreakStatement(node *BreakStmt) any
 	VisitContinueStatement(node *ContinueStmt) any
+	VisitLabeledStatement(node *LabeledStmt) any
+	VisitGotoStatement(node *GotoStmt) any
+	VisitSwitchStatement(node *SwitchStmt) any
+	VisitCaseStatement(node *CaseStmt) any
+	VisitDefaultStatement(node *DefaultStmt) any
 	VisitWhileStatement(node *WhileStmt) any
 	VisitDoWhileStatement(node *DoWhileStmt) any
 	VisitForStatement(node *ForStmt) any
@@ -59,14 +106,43 @@ type BlockItem interface {
 
 type Expression interface {
 	Node
+	GetType() types.Type
+	SetType(t types.Type)
 	exp()
 }
 
 type Factor interface {
 	Node
+	GetType() types.Type
+	SetType(t types.Type)
 	factor()
 }
 
+// typed holds the type the type checker assigns to an expression
+type typed struct {
+	Type types.Type
+}
+
+func (t *typed) GetType() types.Type {
+	return t.Type
+}
+
+func (t *typed) SetType(typ types.Type) {
+	t.Type = typ
+}
+
+// Initializer is the initial value in a variable declaration, either one expression or a brace-enclosed list
+type Initializer interface {
+	GetType() types.Type
+	SetType(t types.Type)
+	initializer()
+}
+
+type Declaration interface {
+	Node
+	decl()
+}
+
 type Statement interface {
 	Node
 	stmt()
@@ -77,15 +153,20 @@ type ForInit interface {
 	forInit()
 }
 
+// Program is a translation unit: a list of file-scope declarations
 type Program struct {
-	Loc      errors.Location
-	Function *Function
+	Loc          errors.Location
+	Declarations []Declaration
 }
 
-type Function struct {
-	Loc  errors.Location
-	Name IdentifierFactor
-	Body Block
+// FunctionDecl is either a function definition or a prototype; Body is nil for prototypes
+type FunctionDecl struct {
+	Loc          errors.Location
+	Name         IdentifierFactor
+	Params       []IdentifierFactor
+	Body         *Block
+	Type         types.FunType
+	StorageClass StorageClass
 }
 
 type Block struct {
@@ -101,6 +182,7 @@ type DeclarationBlock struct {
 	Declaration Declaration
 }
 
+// ReturnStmt returns from the enclosing function; Expression is nil in a void function
 type ReturnStmt struct {
 	Loc        errors.Location
 	Expression Expression
@@ -123,26 +205,70 @@ type CompoundStmt struct {
 }
 
 type BreakStmt struct {
+	Loc   errors.Location
 	Label string
 }
 
 type ContinueStmt struct {
+	Loc   errors.Location
 	Label string
 }
 
+// LabeledStmt is a statement preceded by "label:". After semantic analysis Label is unique across
+// the whole program.
+type LabeledStmt struct {
+	Loc       errors.Location
+	Label     string
+	Statement Statement
+}
+
+type GotoStmt struct {
+	Loc   errors.Location
+	Label string
+}
+
+// SwitchStmt is a switch statement; semantic analysis fills in Cases and Default with the case and
+// default statements that belong to it
+type SwitchStmt struct {
+	Loc       errors.Location
+	Label     string
+	Condition Expression
+	Body      Statement
+	Cases     []*CaseStmt
+	Default   *DefaultStmt
+}
+
+// CaseStmt is "case Expr: Statement"; Value is Expr evaluated and converted to the type of the switch
+type CaseStmt struct {
+	Loc       errors.Location
+	Label     string
+	Expr      Expression
+	Value     types.Const
+	Statement Statement
+}
+
+type DefaultStmt struct {
+	Loc       errors.Location
+	Label     string
+	Statement Statement
+}
+
 type WhileStmt struct {
+	Loc       errors.Location
 	Label     string
 	Condition Expression
 	Body      Statement
 }
 
 type DoWhileStmt struct {
+	Loc       errors.Location
 	Label     string
 	Body      Statement
 	Condition Expression
 }
 
 type ForStmt struct {
+	Loc       errors.Location
 	Label     string
 	Init      ForInit
 	Condition Expression
@@ -151,7 +277,7 @@ type ForStmt struct {
 }
 
 type InitDecl struct {
-	Declaration Declaration
+	Declaration VarDecl
 }
 type InitExp struct {
 	Expression Expression
@@ -162,6 +288,7 @@ type NullStmt struct {
 }
 
 type BinaryExp struct {
+	typed
 	Loc   errors.Location
 	Left  Expression
 	Op    BinopType
@@ -169,56 +296,249 @@ type BinaryExp struct {
 }
 
 type FactorExp struct {
+	typed
 	Loc    errors.Location
 	Factor Factor
 }
 
 type ConditionalExp struct {
+	typed
 	Loc         errors.Location
 	Condition   Expression
 	Expression1 Expression
 	Expression2 Expression
 }
 
-type IntLiteral struct {
+type Constant struct {
+	typed
 	Loc   errors.Location
-	Value int
+	Value types.Const
+}
+
+// StringLiteral is a string literal, which has type array of char; Value doesn't include the terminating null byte
+type StringLiteral struct {
+	typed
+	Loc   errors.Location
+	Value string
 }
 
 type UnaryFactor struct {
+	typed
 	Loc   errors.Location
 	Op    UnopType
 	Value Factor
 }
 
 type NestedExp struct {
+	typed
 	Loc  errors.Location
 	Expr Expression
 }
 
 type AssignmentExp struct {
+	typed
 	Loc   errors.Location
 	Left  Expression
 	Right Expression
 }
 
+// CompoundAssignmentExp is Left Op= Right. The type checker sets ResultType to the type the
+// operation is performed in; the result is converted back to the type of Left before it's stored.
+type CompoundAssignmentExp struct {
+	typed
+	Loc        errors.Location
+	Op         BinopType
+	Left       Expression
+	Right      Expression
+	ResultType types.Type
+}
+
+// IncDecFactor is a prefix or postfix ++ or --
+type IncDecFactor struct {
+	typed
+	Loc       errors.Location
+	Decrement bool
+	Postfix   bool
+	Expr      Factor
+}
+
 type IdentifierFactor struct {
+	typed
 	Loc   errors.Location
 	Value string
 }
 
-type Declaration struct {
+type FunctionCall struct {
+	typed
 	Loc  errors.Location
 	Name IdentifierFactor
-	Init Expression
+	Args []Expression
+}
+
+// CastFactor converts an expression to TargetType; the type checker inserts these for implicit conversions
+type CastFactor struct {
+	typed
+	Loc        errors.Location
+	TargetType types.Type
+	Expr       Expression
+}
+
+// DereferenceFactor is *Expr, the object Expr points to
+type DereferenceFactor struct {
+	typed
+	Loc  errors.Location
+	Expr Factor
+}
+
+// AddressOfFactor is &Expr, a pointer to the object Expr designates
+type AddressOfFactor struct {
+	typed
+	Loc  errors.Location
+	Expr Factor
+}
+
+// SubscriptFactor is Expr[Index]; either operand may be the pointer
+type SubscriptFactor struct {
+	typed
+	Loc   errors.Location
+	Expr  Factor
+	Index Expression
+}
+
+// DotFactor is Expr.Member, a member of a struct
+type DotFactor struct {
+	typed
+	Loc    errors.Location
+	Expr   Factor
+	Member string
+}
+
+// ArrowFactor is Expr->Member, a member of the struct Expr points to
+type ArrowFactor struct {
+	typed
+	Loc    errors.Location
+	Expr   Factor
+	Member string
+}
+
+// SizeOfExpFactor is sizeof Expr; Expr is only type checked, never evaluated
+type SizeOfExpFactor struct {
+	typed
+	Loc  errors.Location
+	Expr Factor
+}
+
+// SizeOfTypeFactor is sizeof(TargetType)
+type SizeOfTypeFactor struct {
+	typed
+	Loc        errors.Location
+	TargetType types.Type
+}
+
+// VaBuiltinFactor is a call to one of the builtins behind the <stdarg.h> macros. Args[0] is the
+// va_list it works on; va_start also takes the last named parameter, and va_copy the list to copy.
+// va_arg takes a type instead of a second argument, which is ArgType.
+type VaBuiltinFactor struct {
+	typed
+	Loc     errors.Location
+	Builtin VaBuiltin
+	Args    []Expression
+	ArgType types.Type
+}
+
+type SingleInit struct {
+	typed
+	Loc  errors.Location
+	Expr Expression
+}
+
+// CompoundInit is a brace-enclosed initializer list; the type checker pads it with zeros to the full array length
+type CompoundInit struct {
+	typed
+	Loc   errors.Location
+	Inits []Initializer
+}
+
+// VarDecl declares a variable. Identifier resolution gives a local variable a unique Name, while
+// SourceName keeps the name as it was written, for messages.
+type VarDecl struct {
+	Loc          errors.Location
+	Name         IdentifierFactor
+	SourceName   string
+	Init         Initializer
+	Type         types.Type
+	StorageClass StorageClass
+}
+
+// StructDecl declares a struct tag, or a union tag if Union is set, and defines it unless Members
+// is nil, as in "struct s;". Identifier resolution makes Tag unique and sets Def to the definition
+// the tag refers to; Name keeps the tag as it was written, and is empty for an anonymous struct.
+type StructDecl struct {
+	Loc     errors.Location
+	Tag     string
+	Name    string
+	Union   bool
+	Members []MemberDecl
+	Def     *types.StructDef
+}
+
+// TypedefDecl declares Name as another name for Type. If the name was already a typedef in the same
+// scope, identifier resolution sets Previous to the type it stood for, which Type has to match.
+type TypedefDecl struct {
+	Loc      errors.Location
+	Name     string
+	Type     types.Type
+	Previous types.Type
+}
+
+// EnumDecl defines an enum type and its enumerators. The parser gives an anonymous enum a tag that
+// can't clash with any identifier.
+type EnumDecl struct {
+	Loc         errors.Location
+	Tag         string
+	Enumerators []Enumerator
+}
+
+// Enumerator is one constant of an enum; Value is nil if it takes the value after the previous one.
+// Identifier resolution gives it a unique Name, while SourceName keeps the name as it was written.
+type Enumerator struct {
+	Loc        errors.Location
+	Name       string
+	SourceName string
+	Value      Expression
+}
+
+// StaticAssertDecl is a _Static_assert declaration, which fails to compile unless Condition, an integer
+// constant expression, is nonzero. Message is nil if the assertion doesn't have one, as C23 allows.
+type StaticAssertDecl struct {
+	Loc       errors.Location
+	Condition Expression
+	Message   *StringLiteral
+}
+
+// ArrayType is an array type as the parser saw it, with a size that's still an expression; type
+// checking evaluates the size and replaces it with a types.Array
+type ArrayType struct {
+	Element types.Type
+	Size    Expression
+}
+
+func (t ArrayType) String() string {
+	return t.Element.String() + "[]"
+}
+
+type MemberDecl struct {
+	Loc  errors.Location
+	Name string
+	Type types.Type
 }
 
 func (p *Program) Accept(visitor AstVisitor) any {
 	return visitor.VisitProgram(p)
 }
 
-func (f *Function) Accept(visitor AstVisitor) any {
-	return visitor.VisitFunction(f)
+func (f *FunctionDecl) Accept(visitor AstVisitor) any {
+	return visitor.VisitFunctionDecl(f)
 }
 
 func (s *StmtBlock) Accept(visitor AstVisitor) any {
@@ -264,8 +584,12 @@ func (n *ConditionalExp) Accept(visitor AstVisitor) any {
 	return visitor.VisitConditionalExp(n)
 }
 
-func (i *IntLiteral) Accept(visitor AstVisitor) any {
-	return visitor.VisitIntLiteral(i)
+func (i *Constant) Accept(visitor AstVisitor) any {
+	return visitor.VisitConstant(i)
+}
+
+func (s *StringLiteral) Accept(visitor AstVisitor) any {
+	return visitor.VisitStringLiteral(s)
 }
 
 func (u *UnaryFactor) Accept(visitor AstVisitor) any {
@@ -280,12 +604,76 @@ func (u *AssignmentExp) Accept(visitor AstVisitor) any {
 	return visitor.VisitAssignmentExp(u)
 }
 
+func (u *CompoundAssignmentExp) Accept(visitor AstVisitor) any {
+	return visitor.VisitCompoundAssignmentExp(u)
+}
+
+func (u *IncDecFactor) Accept(visitor AstVisitor) any {
+	return visitor.VisitIncDecFactor(u)
+}
+
 func (u *IdentifierFactor) Accept(visitor AstVisitor) any {
 	return visitor.VisitIdentifierFactor(u)
 }
 
-func (u *Declaration) Accept(visitor AstVisitor) any {
-	return visitor.VisitDeclaration(u)
+func (u *FunctionCall) Accept(visitor AstVisitor) any {
+	return visitor.VisitFunctionCall(u)
+}
+
+func (c *CastFactor) Accept(visitor AstVisitor) any {
+	return visitor.VisitCastFactor(c)
+}
+
+func (d *DereferenceFactor) Accept(visitor AstVisitor) any {
+	return visitor.VisitDereferenceFactor(d)
+}
+
+func (a *AddressOfFactor) Accept(visitor AstVisitor) any {
+	return visitor.VisitAddressOfFactor(a)
+}
+
+func (s *SubscriptFactor) Accept(visitor AstVisitor) any {
+	return visitor.VisitSubscriptFactor(s)
+}
+
+func (d *DotFactor) Accept(visitor AstVisitor) any {
+	return visitor.VisitDotFactor(d)
+}
+
+func (a *ArrowFactor) Accept(visitor AstVisitor) any {
+	return visitor.VisitArrowFactor(a)
+}
+
+func (s *SizeOfExpFactor) Accept(visitor AstVisitor) any {
+	return visitor.VisitSizeOfExpFactor(s)
+}
+
+func (s *SizeOfTypeFactor) Accept(visitor AstVisitor) any {
+	return visitor.VisitSizeOfTypeFactor(s)
+}
+
+func (v *VaBuiltinFactor) Accept(visitor AstVisitor) any {
+	return visitor.VisitVaBuiltinFactor(v)
+}
+
+func (u *VarDecl) Accept(visitor AstVisitor) any {
+	return visitor.VisitVarDecl(u)
+}
+
+func (s *StructDecl) Accept(visitor AstVisitor) any {
+	return visitor.VisitStructDecl(s)
+}
+
+func (t *TypedefDecl) Accept(visitor AstVisitor) any {
+	return visitor.VisitTypedefDecl(t)
+}
+
+func (e *EnumDecl) Accept(visitor AstVisitor) any {
+	return visitor.VisitEnumDecl(e)
+}
+
+func (s *StaticAssertDecl) Accept(visitor AstVisitor) any {
+	return visitor.VisitStaticAssertDecl(s)
 }
 
 func (b *BreakStmt) Accept(visitor AstVisitor) any {
@@ -294,6 +682,21 @@ func (b *BreakStmt) Accept(visitor AstVisitor) any {
 func (b *ContinueStmt) Accept(visitor AstVisitor) any {
 	return visitor.VisitContinueStatement(b)
 }
+func (l *LabeledStmt) Accept(visitor AstVisitor) any {
+	return visitor.VisitLabeledStatement(l)
+}
+func (g *GotoStmt) Accept(visitor AstVisitor) any {
+	return visitor.VisitGotoStatement(g)
+}
+func (s *SwitchStmt) Accept(visitor AstVisitor) any {
+	return visitor.VisitSwitchStatement(s)
+}
+func (c *CaseStmt) Accept(visitor AstVisitor) any {
+	return visitor.VisitCaseStatement(c)
+}
+func (d *DefaultStmt) Accept(visitor AstVisitor) any {
+	return visitor.VisitDefaultStatement(d)
+}
 func (b *WhileStmt) Accept(visitor AstVisitor) any {
 	return visitor.VisitWhileStatement(b)
 }
@@ -314,26 +717,54 @@ func (f *InitExp) Accept(visitor AstVisitor) any {
 func (StmtBlock) block()        {}
 func (DeclarationBlock) block() {}
 
+func (VarDecl) decl()          {}
+func (FunctionDecl) decl()     {}
+func (StructDecl) decl()       {}
+func (TypedefDecl) decl()      {}
+func (EnumDecl) decl()         {}
+func (StaticAssertDecl) decl() {}
+
 func (ReturnStmt) stmt()     {}
 func (ExpressionStmt) stmt() {}
 func (IfStmt) stmt()         {}
 func (CompoundStmt) stmt()   {}
 func (BreakStmt) stmt()      {}
 func (ContinueStmt) stmt()   {}
+func (LabeledStmt) stmt()    {}
+func (GotoStmt) stmt()       {}
+func (SwitchStmt) stmt()     {}
+func (CaseStmt) stmt()       {}
+func (DefaultStmt) stmt()    {}
 func (WhileStmt) stmt()      {}
 func (DoWhileStmt) stmt()    {}
 func (ForStmt) stmt()        {}
 func (NullStmt) stmt()       {}
 
-func (BinaryExp) exp()      {}
-func (FactorExp) exp()      {}
-func (AssignmentExp) exp()  {}
-func (ConditionalExp) exp() {}
-
-func (IntLiteral) factor()       {}
-func (UnaryFactor) factor()      {}
-func (NestedExp) factor()        {}
-func (IdentifierFactor) factor() {}
+func (BinaryExp) exp()             {}
+func (FactorExp) exp()             {}
+func (AssignmentExp) exp()         {}
+func (CompoundAssignmentExp) exp() {}
+func (ConditionalExp) exp()        {}
+
+func (Constant) factor()          {}
+func (StringLiteral) factor()     {}
+func (UnaryFactor) factor()       {}
+func (IncDecFactor) factor()      {}
+func (NestedExp) factor()         {}
+func (IdentifierFactor) factor()  {}
+func (FunctionCall) factor()      {}
+func (CastFactor) factor()        {}
+func (DereferenceFactor) factor() {}
+func (AddressOfFactor) factor()   {}
+func (SubscriptFactor) factor()   {}
+func (DotFactor) factor()         {}
+func (ArrowFactor) factor()       {}
+func (SizeOfExpFactor) factor()   {}
+func (SizeOfTypeFactor) factor()  {}
+func (VaBuiltinFactor) factor()   {}
+
+func (SingleInit) initializer()   {}
+func (CompoundInit) initializer() {}
 
 func (InitDecl) forInit() {}
 func (InitExp) forInit()  {}
@@ -0,0 +1,217 @@
+package parser
+
+import (
+	"acc/internal/common/errors"
+	"acc/internal/common/types"
+	"acc/internal/lexer"
+)
+
+// declarator is the part of a declaration after the specifiers. It names the declared entity and
+// describes how its type is derived from the base type the specifiers give.
+type declarator interface {
+	declarator()
+}
+
+type identDeclarator struct {
+	name IdentifierFactor
+}
+
+type pointerDeclarator struct {
+	inner declarator
+}
+
+type arrayDeclarator struct {
+	inner declarator
+	size  Expression
+}
+
+type funDeclarator struct {
+	params   []paramInfo
+	variadic bool
+	inner    declarator
+}
+
+// abstractDeclarator stands in for the missing name at the centre of an abstract declarator, as in a cast
+type abstractDeclarator struct{}
+
+type paramInfo struct {
+	paramType types.Type
+	decl      declarator
+}
+
+func (identDeclarator) declarator()    {}
+func (pointerDeclarator) declarator()  {}
+func (arrayDeclarator) declarator()    {}
+func (funDeclarator) declarator()      {}
+func (abstractDeclarator) declarator() {}
+
+// parseDeclarator parses <declarator> ::= "*" <declarator> | <direct-declarator>
+func (p *Parser) parseDeclarator() (declarator, error) {
+	if p.peek().Type == lexer.TokenMultiplicationOp {
+		p.expect(lexer.TokenMultiplicationOp)
+		inner, err := p.parseDeclarator()
+		if err != nil {
+			return nil, err
+		}
+		return &pointerDeclarator{inner: inner}, nil
+	}
+	return p.parseDirectDeclarator()
+}
+
+// parseDirectDeclarator parses <direct-declarator> ::= <simple-declarator> [ <param-list> | { "[" <exp> "]" }+ ]
+func (p *Parser) parseDirectDeclarator() (declarator, error) {
+	simple, err := p.parseSimpleDeclarator()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().Type == lexer.TokenOpenParen {
+		params, variadic, err := p.parseParamList()
+		if err != nil {
+			return nil, err
+		}
+		return &funDeclarator{params: params, variadic: variadic, inner: simple}, nil
+	}
+	return p.parseArraySuffixes(simple)
+}
+
+// parseArraySuffixes wraps inner in an array declarator for each "[" <exp> "]" that follows. The size
+// is an integer constant expression, which can't be evaluated until identifiers are resolved.
+func (p *Parser) parseArraySuffixes(inner declarator) (declarator, error) {
+	for p.peek().Type == lexer.TokenOpenBracket {
+		p.expect(lexer.TokenOpenBracket)
+		size, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		if exists, tok := p.expect(lexer.TokenCloseBracket); !exists {
+			return nil, errors.NewParseError("missing ] in array declarator", tok.Loc)
+		}
+		inner = &arrayDeclarator{inner: inner, size: size}
+	}
+	return inner, nil
+}
+
+// parseSimpleDeclarator parses <simple-declarator> ::= <identifier> | "(" <declarator> ")"
+func (p *Parser) parseSimpleDeclarator() (declarator, error) {
+	if p.peek().Type == lexer.TokenOpenParen {
+		p.expect(lexer.TokenOpenParen)
+		inner, err := p.parseDeclarator()
+		if err != nil {
+			return nil, err
+		}
+		if exists, tok := p.expect(lexer.TokenCloseParen); !exists {
+			return nil, errors.NewParseError("missing ) in declarator", tok.Loc)
+		}
+		return inner, nil
+	}
+
+	ident, err := p.parseIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	return &identDeclarator{name: ident}, nil
+}
+
+// parseAbstractDeclarator parses <abstract-declarator> ::= "*" [ <abstract-declarator> ] | <direct-abstract-declarator>
+func (p *Parser) parseAbstractDeclarator() (declarator, error) {
+	if p.peek().Type == lexer.TokenMultiplicationOp {
+		p.expect(lexer.TokenMultiplicationOp)
+		switch p.peek().Type {
+		case lexer.TokenMultiplicationOp, lexer.TokenOpenParen, lexer.TokenOpenBracket:
+		default:
+			return &pointerDeclarator{inner: &abstractDeclarator{}}, nil
+		}
+		inner, err := p.parseAbstractDeclarator()
+		if err != nil {
+			return nil, err
+		}
+		return &pointerDeclarator{inner: inner}, nil
+	}
+	return p.parseDirectAbstractDeclarator()
+}
+
+// parseDirectAbstractDeclarator parses
+// <direct-abstract-declarator> ::= "(" <abstract-declarator> ")" { "[" <exp> "]" } | { "[" <exp> "]" }+
+func (p *Parser) parseDirectAbstractDeclarator() (declarator, error) {
+	if p.peek().Type == lexer.TokenOpenBracket {
+		return p.parseArraySuffixes(&abstractDeclarator{})
+	}
+
+	if exists, tok := p.expect(lexer.TokenOpenParen); !exists {
+		return nil, errors.NewParseError("malformed abstract declarator", tok.Loc)
+	}
+	inner, err := p.parseAbstractDeclarator()
+	if err != nil {
+		return nil, err
+	}
+	if exists, tok := p.expect(lexer.TokenCloseParen); !exists {
+		return nil, errors.NewParseError("missing ) in abstract declarator", tok.Loc)
+	}
+	return p.parseArraySuffixes(inner)
+}
+
+// processDeclarator applies decl to baseType, giving the declared name and type, plus the parameter names if it declares a function
+func processDeclarator(decl declarator, baseType types.Type, loc errors.Location) (IdentifierFactor, types.Type, []IdentifierFactor, error) {
+	switch d := decl.(type) {
+	case *identDeclarator:
+		return d.name, baseType, nil, nil
+	case *abstractDeclarator:
+		// Only a parameter of a prototype can be unnamed
+		return IdentifierFactor{Loc: loc}, baseType, nil, nil
+	case *pointerDeclarator:
+		return processDeclarator(d.inner, types.Pointer{Referenced: baseType}, loc)
+	case *arrayDeclarator:
+		// Struct types aren't resolved yet, so arrays of incomplete structs are caught by the type checker
+		if _, isVoid := baseType.(types.Void); isVoid {
+			return IdentifierFactor{}, nil, nil, errors.NewParseError("array of incomplete type "+baseType.String(), loc)
+		}
+		return processDeclarator(d.inner, ArrayType{Element: baseType, Size: d.size}, loc)
+	case *funDeclarator:
+		ident, ok := d.inner.(*identDeclarator)
+		if !ok {
+			return IdentifierFactor{}, nil, nil, errors.NewParseError("function pointers are not supported", loc)
+		}
+		if _, isArray := baseType.(ArrayType); isArray {
+			return IdentifierFactor{}, nil, nil, errors.NewParseError("function can't return an array", loc)
+		}
+
+		params := []IdentifierFactor{}
+		paramTypes := []types.Type{}
+		for _, param := range d.params {
+			name, paramType, _, err := processDeclarator(param.decl, param.paramType, loc)
+			if err != nil {
+				return IdentifierFactor{}, nil, nil, err
+			}
+			if _, isFunction := paramType.(types.FunType); isFunction {
+				return IdentifierFactor{}, nil, nil, errors.NewParseError("function pointers in parameters are not supported", loc)
+			}
+			// An array parameter is really a pointer to the array's first element
+			if array, isArray := paramType.(ArrayType); isArray {
+				paramType = types.Pointer{Referenced: array.Element}
+			}
+			params = append(params, name)
+			paramTypes = append(paramTypes, paramType)
+		}
+		return ident.name, types.FunType{Params: paramTypes, Ret: baseType, Variadic: d.variadic}, params, nil
+	default:
+		panic("invalid declarator type")
+	}
+}
+
+// processAbstractDeclarator applies an abstract declarator to baseType
+func processAbstractDeclarator(decl declarator, baseType types.Type, loc errors.Location) (types.Type, error) {
+	switch d := decl.(type) {
+	case *abstractDeclarator:
+		return baseType, nil
+	case *pointerDeclarator:
+		return processAbstractDeclarator(d.inner, types.Pointer{Referenced: baseType}, loc)
+	case *arrayDeclarator:
+		if _, isVoid := baseType.(types.Void); isVoid {
+			return nil, errors.NewParseError("array of incomplete type "+baseType.String(), loc)
+		}
+		return processAbstractDeclarator(d.inner, ArrayType{Element: baseType, Size: d.size}, loc)
+	default:
+		panic("invalid abstract declarator type")
+	}
+}
@@ -2,21 +2,75 @@ package parser
 
 import (
 	"acc/internal/common/errors"
+	"acc/internal/common/types"
 	"acc/internal/lexer"
+	"fmt"
+	"math"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 type Parser struct {
 	tokens []lexer.Token
 	index  int
+	// scopes records, for each enclosing scope from the outermost in, whether each ordinary
+	// identifier declared there is a typedef name. An identifier can only be told apart from a type
+	// by looking it up, so this is needed to parse "T * x;" as a declaration rather than a product.
+	scopes []map[string]bool
+	// definitions holds the struct, union and enum definitions met in the specifiers of the declarations
+	// being parsed, each of which is emitted ahead of the declaration containing it
+	definitions   []Declaration
+	anonymousTags int
 }
 
 func NewParser(tokens []lexer.Token) *Parser {
 	return &Parser{
 		tokens: tokens,
+		// __builtin_va_list is predeclared, as <stdarg.h> expects
+		scopes: []map[string]bool{{"__builtin_va_list": true}},
 	}
 }
 
+func (p *Parser) enterScope() {
+	p.scopes = append(p.scopes, map[string]bool{})
+}
+
+func (p *Parser) exitScope() {
+	p.scopes = p.scopes[:len(p.scopes)-1]
+}
+
+// declareName records an ordinary identifier declared in the current scope, which hides any
+// typedef of the same name in an outer scope unless it's a typedef itself
+func (p *Parser) declareName(name string, isTypedef bool) {
+	p.scopes[len(p.scopes)-1][name] = isTypedef
+}
+
+// isTypedefName reports whether name refers to a typedef in the current scope
+func (p *Parser) isTypedefName(name string) bool {
+	for i := len(p.scopes) - 1; i >= 0; i-- {
+		if isTypedef, ok := p.scopes[i][name]; ok {
+			return isTypedef
+		}
+	}
+	return false
+}
+
+// isTypeName reports whether tok can start a type name, as in a cast or sizeof
+func (p *Parser) isTypeName(tok lexer.Token) bool {
+	return isTypeSpecifier(tok.Type) || (tok.Type == lexer.TokenIdentifier && p.isTypedefName(tok.Literal))
+}
+
+// startsDeclaration reports whether the next token starts a declaration rather than a statement.
+// A typedef name followed by a colon is a label, since labels have their own namespace.
+func (p *Parser) startsDeclaration() bool {
+	tok := p.peek()
+	if tok.Type == lexer.TokenIdentifier {
+		return p.isTypedefName(tok.Literal) && p.peekAhead(1).Type != lexer.TokenConditionalOpEnd
+	}
+	return isSpecifier(tok.Type) || tok.Type == lexer.TokenStaticAssert
+}
+
 func (p *Parser) isAtEnd() bool {
 	return p.index >= len(p.tokens)
 }
@@ -28,6 +82,14 @@ func (p *Parser) peek() lexer.Token {
 	return p.tokens[p.index]
 }
 
+// peekAhead returns the token n positions past the next one without consuming anything
+func (p *Parser) peekAhead(n int) lexer.Token {
+	if p.index+n >= len(p.tokens) {
+		return lexer.Token{}
+	}
+	return p.tokens[p.index+n]
+}
+
 func (p *Parser) expect(expected lexer.TokenType) (bool, lexer.Token) {
 	if p.isAtEnd() {
 		return false, lexer.Token{}
@@ -42,63 +104,109 @@ func (p *Parser) expect(expected lexer.TokenType) (bool, lexer.Token) {
 
 func (p *Parser) Parse() (*Program, error) {
 	program := &Program{}
-	function, err := p.parseFunction()
-	if err != nil {
-		return nil, err
-	}
-	program.Function = function
 
-	if !p.isAtEnd() {
-		return nil, errors.NewParseError("invalid chars outside of function", p.tokens[p.index].Loc)
+	for !p.isAtEnd() {
+		decls, err := p.parseDeclaration()
+		if err != nil {
+			return nil, err
+		}
+		program.Declarations = append(program.Declarations, decls...)
 	}
 
 	return program, nil
 }
 
-func (p *Parser) parseFunction() (*Function, error) {
-	if exists, tok := p.expect(lexer.TokenInt); !exists {
-		return nil, errors.NewParseError("missing int", tok.Loc)
+// parseParamList parses <param-list> ::= "(" "void" ")" | "(" <param> { "," <param> } [ "," "..." ] ")",
+// reporting whether the function is variadic
+func (p *Parser) parseParamList() ([]paramInfo, bool, error) {
+	if exists, tok := p.expect(lexer.TokenOpenParen); !exists {
+		return nil, false, errors.NewParseError("missing (", tok.Loc)
 	}
 
-	ident, err := p.parseIdentifier()
-	if err != nil {
-		return nil, err
+	if p.peek().Type == lexer.TokenVoid && p.peekAhead(1).Type == lexer.TokenCloseParen {
+		p.expect(lexer.TokenVoid)
+		p.expect(lexer.TokenCloseParen)
+		return []paramInfo{}, false, nil
 	}
 
-	if exists, tok := p.expect(lexer.TokenOpenParen); !exists {
-		return nil, errors.NewParseError("missing (", tok.Loc)
-	}
-	if exists, tok := p.expect(lexer.TokenVoid); !exists {
-		return nil, errors.NewParseError("missing void", tok.Loc)
+	params := []paramInfo{}
+	variadic := false
+	for {
+		paramType, err := p.parseTypeName()
+		if err != nil {
+			return nil, false, err
+		}
+
+		var decl declarator
+		if p.isUnnamedParam() {
+			decl, err = p.parseAbstractParam()
+		} else {
+			decl, err = p.parseDeclarator()
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		params = append(params, paramInfo{paramType: paramType, decl: decl})
+
+		if p.peek().Type != lexer.TokenComma {
+			break
+		}
+		p.expect(lexer.TokenComma)
+		// The ellipsis can only come last, after at least one named parameter
+		if p.peek().Type == lexer.TokenEllipsis {
+			p.expect(lexer.TokenEllipsis)
+			variadic = true
+			break
+		}
 	}
+
 	if exists, tok := p.expect(lexer.TokenCloseParen); !exists {
-		return nil, errors.NewParseError("missing )", tok.Loc)
+		return nil, false, errors.NewParseError("missing )", tok.Loc)
 	}
 
-	body, err := p.parseBlock()
-	if err != nil {
-		return nil, err
+	return params, variadic, nil
+}
+
+// isUnnamedParam reports whether the parameter whose type name has just been parsed leaves out its
+// name, as prototypes may: that's when the name's place, after any *s, is a , or ) or [
+func (p *Parser) isUnnamedParam() bool {
+	i := 0
+	for p.peekAhead(i).Type == lexer.TokenMultiplicationOp {
+		i++
+	}
+	switch p.peekAhead(i).Type {
+	case lexer.TokenComma, lexer.TokenCloseParen, lexer.TokenOpenBracket:
+		return true
+	default:
+		return false
 	}
+}
 
-	return &Function{
-		Name: ident,
-		Body: body,
-	}, nil
+// parseAbstractParam parses the abstract declarator of an unnamed parameter, which may be empty
+func (p *Parser) parseAbstractParam() (declarator, error) {
+	switch p.peek().Type {
+	case lexer.TokenComma, lexer.TokenCloseParen:
+		return &abstractDeclarator{}, nil
+	default:
+		return p.parseAbstractDeclarator()
+	}
 }
 
 func (p *Parser) parseBlock() (Block, error) {
 	if exists, tok := p.expect(lexer.TokenOpenBrace); !exists {
 		return Block{}, errors.NewParseError("missing {", tok.Loc)
 	}
+	p.enterScope()
+	defer p.exitScope()
 
 	body := []BlockItem{}
 
 	for p.peek().Type != lexer.TokenCloseBrace {
-		item, err := p.parseBlockItem()
+		items, err := p.parseBlockItem()
 		if err != nil {
 			return Block{}, err
 		}
-		body = append(body, item)
+		body = append(body, items...)
 	}
 
 	if exists, tok := p.expect(lexer.TokenCloseBrace); !exists {
@@ -108,13 +216,19 @@ func (p *Parser) parseBlock() (Block, error) {
 	return Block{Body: body}, nil
 }
 
-func (p *Parser) parseBlockItem() (BlockItem, error) {
-	if p.peek().Type == lexer.TokenInt {
-		decl, err := p.parseDeclaration()
+// parseBlockItem parses a statement, or a declaration along with any definitions in its specifiers
+func (p *Parser) parseBlockItem() ([]BlockItem, error) {
+	if p.startsDeclaration() {
+		loc := p.peek().Loc
+		decls, err := p.parseDeclaration()
 		if err != nil {
 			return nil, err
 		}
-		return &DeclarationBlock{Declaration: *decl}, err
+		items := make([]BlockItem, len(decls))
+		for i, decl := range decls {
+			items[i] = &DeclarationBlock{Loc: loc, Declaration: decl}
+		}
+		return items, nil
 
 	} else {
 		// Statement
@@ -122,23 +236,311 @@ func (p *Parser) parseBlockItem() (BlockItem, error) {
 		if err != nil {
 			return nil, err
 		}
-		return &StmtBlock{Statement: stmt}, nil
+		return []BlockItem{&StmtBlock{Statement: stmt}}, nil
+	}
+}
+
+func isTypeSpecifier(tokenType lexer.TokenType) bool {
+	switch tokenType {
+	case lexer.TokenInt, lexer.TokenLong, lexer.TokenSigned, lexer.TokenUnsigned, lexer.TokenDouble, lexer.TokenChar, lexer.TokenVoid, lexer.TokenStruct, lexer.TokenUnion, lexer.TokenEnum:
+		return true
+	default:
+		return false
+	}
+}
+
+func isSpecifier(tokenType lexer.TokenType) bool {
+	switch tokenType {
+	case lexer.TokenStatic, lexer.TokenExtern, lexer.TokenTypedef:
+		return true
+	default:
+		return isTypeSpecifier(tokenType)
+	}
+}
+
+// parseSpecifiers consumes the type and storage class specifiers at the start of a declaration
+func (p *Parser) parseSpecifiers() (types.Type, StorageClass, error) {
+	startTok := p.peek()
+	typeSpecifiers := []lexer.TokenType{}
+	storageClass := StorageClassNone
+	tag, tagName := "", ""
+	typedefName := ""
+
+	for {
+		tok := p.peek()
+		// A typedef name is only a specifier if there's no other type specifier; otherwise it's the
+		// identifier being declared, hiding the typedef
+		isTypedef := tok.Type == lexer.TokenIdentifier && len(typeSpecifiers) == 0 && typedefName == "" && p.isTypedefName(tok.Literal)
+		if !isSpecifier(tok.Type) && !isTypedef {
+			break
+		}
+		p.index++
+
+		switch tok.Type {
+		case lexer.TokenStatic, lexer.TokenExtern, lexer.TokenTypedef:
+			if storageClass != StorageClassNone {
+				return nil, StorageClassNone, errors.NewParseError("multiple storage classes", tok.Loc)
+			}
+			switch tok.Type {
+			case lexer.TokenStatic:
+				storageClass = StorageClassStatic
+			case lexer.TokenExtern:
+				storageClass = StorageClassExtern
+			default:
+				storageClass = StorageClassTypedef
+			}
+		case lexer.TokenIdentifier:
+			typedefName = tok.Literal
+		case lexer.TokenStruct, lexer.TokenUnion, lexer.TokenEnum:
+			var err error
+			tag, tagName, err = p.parseTagSpecifier(tok)
+			if err != nil {
+				return nil, StorageClassNone, err
+			}
+			typeSpecifiers = append(typeSpecifiers, tok.Type)
+		default:
+			typeSpecifiers = append(typeSpecifiers, tok.Type)
+		}
+	}
+
+	if typedefName != "" {
+		if len(typeSpecifiers) != 0 {
+			return nil, StorageClassNone, errors.NewParseError("invalid type specifier", startTok.Loc)
+		}
+		return types.Typedef{Name: typedefName}, storageClass, nil
+	}
+	t, err := typeFromSpecifiers(typeSpecifiers, tag, tagName, startTok.Loc)
+	if err != nil {
+		return nil, StorageClassNone, err
+	}
+	return t, storageClass, nil
+}
+
+// tagKeyword spells out the keyword that introduces a tag, for error messages; keyword tokens have no literal
+func tagKeyword(t lexer.TokenType) string {
+	switch t {
+	case lexer.TokenUnion:
+		return "union"
+	case lexer.TokenEnum:
+		return "enum"
+	default:
+		return "struct"
+	}
+}
+
+// parseTagSpecifier parses what follows a struct, union or enum keyword: a tag, a definition, or both. The
+// definition is queued to be emitted ahead of the declaration it appears in, and if it has no tag
+// it's given one that can't clash with any identifier. It returns that tag along with the tag as it
+// was written, which is empty for an anonymous definition.
+func (p *Parser) parseTagSpecifier(keyword lexer.Token) (string, string, error) {
+	name := ""
+	if p.peek().Type == lexer.TokenIdentifier {
+		ident, _ := p.parseIdentifier()
+		name = ident.Value
+	}
+	if p.peek().Type != lexer.TokenOpenBrace {
+		if name == "" {
+			return "", "", errors.NewParseError("missing tag after "+tagKeyword(keyword.Type), keyword.Loc)
+		}
+		return name, name, nil
+	}
+
+	tag := name
+	if tag == "" {
+		p.anonymousTags++
+		tag = fmt.Sprintf("anonymous.%d", p.anonymousTags)
+	}
+	var decl Declaration
+	var err error
+	if keyword.Type == lexer.TokenEnum {
+		decl, err = p.parseEnumBody(keyword, tag)
+	} else {
+		decl, err = p.parseStructBody(keyword, tag, name)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	p.definitions = append(p.definitions, decl)
+	return tag, name, nil
+}
+
+// parseTypeName consumes a list of type specifiers with no storage class, as in a cast or parameter
+func (p *Parser) parseTypeName() (types.Type, error) {
+	startTok := p.peek()
+	definitions := len(p.definitions)
+	t, storageClass, err := p.parseSpecifiers()
+	if err != nil {
+		return nil, err
+	}
+	if storageClass != StorageClassNone {
+		return nil, errors.NewParseError("storage class in type name", startTok.Loc)
+	}
+	// There's no declaration to put a definition ahead of
+	if len(p.definitions) > definitions {
+		return nil, errors.NewParseError("a type can't be defined in a type name", startTok.Loc)
+	}
+	return t, nil
+}
+
+// typeFromSpecifiers works out which type a list of type specifiers names; their order doesn't matter.
+// tag is the tag that followed the struct, union or enum keyword, if there was one, and name is how
+// it was written.
+func typeFromSpecifiers(specifiers []lexer.TokenType, tag, name string, loc errors.Location) (types.Type, error) {
+	counts := map[lexer.TokenType]int{}
+	for _, specifier := range specifiers {
+		counts[specifier]++
+		if counts[specifier] > 1 {
+			return nil, errors.NewParseError("duplicate type specifier", loc)
+		}
+	}
+
+	if len(specifiers) == 0 || counts[lexer.TokenSigned]+counts[lexer.TokenUnsigned] > 1 {
+		return nil, errors.NewParseError("invalid type specifier", loc)
+	}
+	if counts[lexer.TokenDouble] == 1 {
+		if len(specifiers) != 1 {
+			return nil, errors.NewParseError("invalid type specifier", loc)
+		}
+		return types.Double{}, nil
+	}
+	if counts[lexer.TokenVoid] == 1 {
+		if len(specifiers) != 1 {
+			return nil, errors.NewParseError("invalid type specifier", loc)
+		}
+		return types.Void{}, nil
+	}
+	if counts[lexer.TokenStruct]+counts[lexer.TokenUnion]+counts[lexer.TokenEnum] == 1 {
+		if len(specifiers) != 1 {
+			return nil, errors.NewParseError("invalid type specifier", loc)
+		}
+		if counts[lexer.TokenEnum] == 1 {
+			return types.Enum{Tag: tag}, nil
+		}
+		return types.Structure{Tag: tag, Name: name, Union: counts[lexer.TokenUnion] == 1}, nil
+	}
+	if counts[lexer.TokenChar] == 1 {
+		if counts[lexer.TokenInt]+counts[lexer.TokenLong] > 0 {
+			return nil, errors.NewParseError("invalid type specifier", loc)
+		}
+		switch {
+		case counts[lexer.TokenSigned] == 1:
+			return types.SChar{}, nil
+		case counts[lexer.TokenUnsigned] == 1:
+			return types.UChar{}, nil
+		default:
+			return types.Char{}, nil
+		}
+	}
+
+	switch {
+	case counts[lexer.TokenUnsigned] == 1 && counts[lexer.TokenLong] == 1:
+		return types.ULong{}, nil
+	case counts[lexer.TokenUnsigned] == 1:
+		return types.UInt{}, nil
+	case counts[lexer.TokenLong] == 1:
+		return types.Long{}, nil
+	default:
+		return types.Int{}, nil
 	}
 }
 
-func (p *Parser) parseDeclaration() (*Declaration, error) {
-	// Declaration
-	p.expect(lexer.TokenInt)
+// parseDeclaration parses a declaration, preceded by the definitions of any structs, unions or enums in its
+// specifiers. There's no declaration left over when the specifiers only define or declare a tag.
+func (p *Parser) parseDeclaration() ([]Declaration, error) {
+	start := len(p.definitions)
+	decl, err := p.parseOneDeclaration()
+	if err != nil {
+		return nil, err
+	}
+	decls := slices.Clone(p.definitions[start:])
+	p.definitions = p.definitions[:start]
+	if decl != nil {
+		decls = append(decls, decl)
+	}
+	return decls, nil
+}
+
+func (p *Parser) parseOneDeclaration() (Declaration, error) {
+	startTok := p.peek()
+	if startTok.Type == lexer.TokenStaticAssert {
+		return p.parseStaticAssert()
+	}
+
+	definitions := len(p.definitions)
+	declType, storageClass, err := p.parseSpecifiers()
+	if err != nil {
+		return nil, err
+	}
+
+	// Specifiers without a declarator only declare a tag, as in "struct s;", or define one
+	if p.peek().Type == lexer.TokenSemicolon {
+		p.expect(lexer.TokenSemicolon)
+		structure, isStruct := declType.(types.Structure)
+		switch {
+		case len(p.definitions) > definitions:
+			return nil, nil
+		case !isStruct:
+			// That includes "enum e;", since an enum can't be used before its enumerators are known
+			return nil, errors.NewParseError("declaration doesn't declare anything", startTok.Loc)
+		default:
+			// Without members this declares the tag in the current scope, hiding any outer one
+			return &StructDecl{Loc: startTok.Loc, Tag: structure.Tag, Name: structure.Name, Union: structure.Union}, nil
+		}
+	}
 
-	ident, err := p.parseIdentifier()
+	decl, err := p.parseDeclarator()
 	if err != nil {
 		return nil, err
 	}
+	ident, declType, params, err := processDeclarator(decl, declType, startTok.Loc)
+	if err != nil {
+		return nil, err
+	}
+	p.declareName(ident.Value, storageClass == StorageClassTypedef)
+
+	if storageClass == StorageClassTypedef {
+		if exists, tok := p.expect(lexer.TokenSemicolon); !exists {
+			return nil, errors.NewParseError("missing semicolon after typedef", tok.Loc)
+		}
+		return &TypedefDecl{Loc: startTok.Loc, Name: ident.Value, Type: declType}, nil
+	}
 
-	var expression Expression
+	// Function declaration
+	if funType, isFunction := declType.(types.FunType); isFunction {
+		function := &FunctionDecl{
+			Loc:          startTok.Loc,
+			Name:         ident,
+			Params:       params,
+			Type:         funType,
+			StorageClass: storageClass,
+		}
+		if p.peek().Type == lexer.TokenSemicolon {
+			p.expect(lexer.TokenSemicolon)
+			return function, nil
+		}
+
+		// Parameters are in scope in the body, where they hide typedefs of the same name
+		p.enterScope()
+		defer p.exitScope()
+		for _, param := range params {
+			if param.Value == "" {
+				return nil, errors.NewParseError("parameter name omitted in definition of "+ident.Value, startTok.Loc)
+			}
+			p.declareName(param.Value, false)
+		}
+		body, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		function.Body = &body
+		return function, nil
+	}
+
+	// Variable declaration
+	var init Initializer
 	if p.peek().Type == lexer.TokenAssignmentOp {
 		p.expect(lexer.TokenAssignmentOp)
-		expression, err = p.parseExpression(0)
+		init, err = p.parseInitializer()
 		if err != nil {
 			return nil, err
 		}
@@ -148,7 +550,171 @@ func (p *Parser) parseDeclaration() (*Declaration, error) {
 		return nil, errors.NewParseError("missing semicolon", tok.Loc)
 	}
 
-	return &Declaration{Name: ident, Init: expression}, nil
+	return &VarDecl{Loc: startTok.Loc, Name: ident, SourceName: ident.Value, Init: init, Type: declType, StorageClass: storageClass}, nil
+}
+
+// parseStructBody parses the member list of a struct or union definition,
+// "{" { <member-declaration> }+ "}", which follows the keyword structTok and the tag
+func (p *Parser) parseStructBody(structTok lexer.Token, tag, name string) (*StructDecl, error) {
+	decl := &StructDecl{Loc: structTok.Loc, Tag: tag, Name: name, Union: structTok.Type == lexer.TokenUnion, Members: []MemberDecl{}}
+
+	p.expect(lexer.TokenOpenBrace)
+	for p.peek().Type != lexer.TokenCloseBrace && !p.isAtEnd() {
+		member, err := p.parseMemberDecl()
+		if err != nil {
+			return nil, err
+		}
+		decl.Members = append(decl.Members, member)
+	}
+	if len(decl.Members) == 0 {
+		return nil, errors.NewParseError(tagKeyword(structTok.Type)+" declaration with no members", structTok.Loc)
+	}
+	if exists, tok := p.expect(lexer.TokenCloseBrace); !exists {
+		return nil, errors.NewParseError("missing } after member list", tok.Loc)
+	}
+	return decl, nil
+}
+
+// parseEnumBody parses the enumerator list of an enum definition, which follows the keyword enumTok and the tag
+// <enumerator-list> ::= "{" <enumerator> { "," <enumerator> } [ "," ] "}"
+// <enumerator> ::= <identifier> [ "=" <exp> ]
+func (p *Parser) parseEnumBody(enumTok lexer.Token, tag string) (*EnumDecl, error) {
+	decl := &EnumDecl{Loc: enumTok.Loc, Tag: tag}
+	p.expect(lexer.TokenOpenBrace)
+
+	for {
+		tok := p.peek()
+		name, err := p.parseIdentifier()
+		if err != nil {
+			return nil, errors.NewParseError("expected enumerator name", tok.Loc)
+		}
+		enumerator := Enumerator{Loc: name.Loc, Name: name.Value, SourceName: name.Value}
+		if p.peek().Type == lexer.TokenAssignmentOp {
+			p.expect(lexer.TokenAssignmentOp)
+			enumerator.Value, err = p.parseExpression(0)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// Enumerators are ordinary identifiers, so they hide typedefs of the same name
+		p.declareName(name.Value, false)
+		decl.Enumerators = append(decl.Enumerators, enumerator)
+
+		if p.peek().Type != lexer.TokenComma {
+			break
+		}
+		p.expect(lexer.TokenComma)
+		if p.peek().Type == lexer.TokenCloseBrace {
+			break
+		}
+	}
+
+	if exists, tok := p.expect(lexer.TokenCloseBrace); !exists {
+		return nil, errors.NewParseError("missing } after enumerator list", tok.Loc)
+	}
+	return decl, nil
+}
+
+// parseStaticAssert parses
+// <static-assert-declaration> ::= ( "_Static_assert" | "static_assert" ) "(" <exp> [ "," <string> ] ")" ";"
+func (p *Parser) parseStaticAssert() (*StaticAssertDecl, error) {
+	assertTok := p.peek()
+	p.index++
+	if exists, tok := p.expect(lexer.TokenOpenParen); !exists {
+		return nil, errors.NewParseError("missing ( after _Static_assert", tok.Loc)
+	}
+	condition, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+	decl := &StaticAssertDecl{Loc: assertTok.Loc, Condition: condition}
+
+	if p.peek().Type == lexer.TokenComma {
+		p.expect(lexer.TokenComma)
+		tok := p.peek()
+		if tok.Type != lexer.TokenStringLiteral {
+			return nil, errors.NewParseError("message of _Static_assert must be a string literal", tok.Loc)
+		}
+		// Adjacent string literals are concatenated
+		decl.Message = &StringLiteral{Loc: tok.Loc}
+		for p.peek().Type == lexer.TokenStringLiteral {
+			decl.Message.Value += p.peek().Literal
+			p.expect(lexer.TokenStringLiteral)
+		}
+	}
+
+	if exists, tok := p.expect(lexer.TokenCloseParen); !exists {
+		return nil, errors.NewParseError("missing ) in _Static_assert", tok.Loc)
+	}
+	if exists, tok := p.expect(lexer.TokenSemicolon); !exists {
+		return nil, errors.NewParseError("missing semicolon after _Static_assert", tok.Loc)
+	}
+	return decl, nil
+}
+
+// parseMemberDecl parses <member-declaration> ::= { <type-specifier> }+ <declarator> ";"
+func (p *Parser) parseMemberDecl() (MemberDecl, error) {
+	startTok := p.peek()
+	// A member's type can be defined in place; the definition goes ahead of the enclosing struct's
+	baseType, storageClass, err := p.parseSpecifiers()
+	if err != nil {
+		return MemberDecl{}, err
+	}
+	if storageClass != StorageClassNone {
+		return MemberDecl{}, errors.NewParseError("storage class on struct member", startTok.Loc)
+	}
+
+	decl, err := p.parseDeclarator()
+	if err != nil {
+		return MemberDecl{}, err
+	}
+	name, memberType, _, err := processDeclarator(decl, baseType, startTok.Loc)
+	if err != nil {
+		return MemberDecl{}, err
+	}
+	if _, isFunction := memberType.(types.FunType); isFunction {
+		return MemberDecl{}, errors.NewParseError("struct member can't be a function", startTok.Loc)
+	}
+
+	if exists, tok := p.expect(lexer.TokenSemicolon); !exists {
+		return MemberDecl{}, errors.NewParseError("missing semicolon after struct member", tok.Loc)
+	}
+	return MemberDecl{Loc: startTok.Loc, Name: name.Value, Type: memberType}, nil
+}
+
+// parseInitializer parses <initializer> ::= <exp> | "{" <initializer> { "," <initializer> } [ "," ] "}"
+func (p *Parser) parseInitializer() (Initializer, error) {
+	startTok := p.peek()
+	if startTok.Type != lexer.TokenOpenBrace {
+		expr, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		return &SingleInit{Loc: startTok.Loc, Expr: expr}, nil
+	}
+
+	p.expect(lexer.TokenOpenBrace)
+	inits := []Initializer{}
+	for {
+		init, err := p.parseInitializer()
+		if err != nil {
+			return nil, err
+		}
+		inits = append(inits, init)
+
+		if p.peek().Type != lexer.TokenComma {
+			break
+		}
+		p.expect(lexer.TokenComma)
+		if p.peek().Type == lexer.TokenCloseBrace {
+			break
+		}
+	}
+
+	if exists, tok := p.expect(lexer.TokenCloseBrace); !exists {
+		return nil, errors.NewParseError("missing } in initializer", tok.Loc)
+	}
+	return &CompoundInit{Loc: startTok.Loc, Inits: inits}, nil
 }
 
 func (p *Parser) parseStatement() (Statement, error) {
@@ -160,9 +726,14 @@ func (p *Parser) parseStatement() (Statement, error) {
 	case lexer.TokenReturn:
 		p.expect(lexer.TokenReturn)
 
-		expr, err := p.parseExpression(0)
-		if err != nil {
-			return nil, err
+		// The expression is left out when returning from a void function
+		var expr Expression
+		if p.peek().Type != lexer.TokenSemicolon {
+			var err error
+			expr, err = p.parseExpression(0)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		if exists, tok := p.expect(lexer.TokenSemicolon); !exists {
@@ -211,14 +782,72 @@ func (p *Parser) parseStatement() (Statement, error) {
 		if exists, tok := p.expect(lexer.TokenSemicolon); !exists {
 			return nil, errors.NewParseError("missing semicolon", tok.Loc)
 		}
-		return &BreakStmt{}, nil
+		return &BreakStmt{Loc: nextToken.Loc}, nil
 	case lexer.TokenContinue:
-		p.expect(lexer.TokenBreak)
+		p.expect(lexer.TokenContinue)
 
 		if exists, tok := p.expect(lexer.TokenSemicolon); !exists {
 			return nil, errors.NewParseError("missing semicolon", tok.Loc)
 		}
-		return &ContinueStmt{}, nil
+		return &ContinueStmt{Loc: nextToken.Loc}, nil
+	case lexer.TokenGoto:
+		p.expect(lexer.TokenGoto)
+
+		label, err := p.parseIdentifier()
+		if err != nil {
+			return nil, errors.NewParseError("missing label after goto", nextToken.Loc)
+		}
+		if exists, tok := p.expect(lexer.TokenSemicolon); !exists {
+			return nil, errors.NewParseError("missing semicolon", tok.Loc)
+		}
+		return &GotoStmt{Loc: nextToken.Loc, Label: label.Value}, nil
+	case lexer.TokenSwitch:
+		p.expect(lexer.TokenSwitch)
+
+		if exists, tok := p.expect(lexer.TokenOpenParen); !exists {
+			return nil, errors.NewParseError("missing open parenthesis", tok.Loc)
+		}
+		condition, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		if exists, tok := p.expect(lexer.TokenCloseParen); !exists {
+			return nil, errors.NewParseError("missing close parenthesis", tok.Loc)
+		}
+
+		body, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		return &SwitchStmt{Loc: nextToken.Loc, Condition: condition, Body: body}, nil
+	case lexer.TokenCase:
+		p.expect(lexer.TokenCase)
+
+		expr, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		if exists, tok := p.expect(lexer.TokenConditionalOpEnd); !exists {
+			return nil, errors.NewParseError("missing : after case", tok.Loc)
+		}
+
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		return &CaseStmt{Loc: nextToken.Loc, Expr: expr, Statement: stmt}, nil
+	case lexer.TokenDefault:
+		p.expect(lexer.TokenDefault)
+
+		if exists, tok := p.expect(lexer.TokenConditionalOpEnd); !exists {
+			return nil, errors.NewParseError("missing : after default", tok.Loc)
+		}
+
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		return &DefaultStmt{Loc: nextToken.Loc, Statement: stmt}, nil
 	case lexer.TokenWhile:
 		p.expect(lexer.TokenWhile)
 
@@ -240,7 +869,7 @@ func (p *Parser) parseStatement() (Statement, error) {
 			return nil, err
 		}
 
-		return &WhileStmt{Condition: exp, Body: stmt}, nil
+		return &WhileStmt{Loc: nextToken.Loc, Condition: exp, Body: stmt}, nil
 	case lexer.TokenDo:
 		p.expect(lexer.TokenDo)
 
@@ -269,9 +898,12 @@ func (p *Parser) parseStatement() (Statement, error) {
 			return nil, errors.NewParseError("missing semicolon", tok.Loc)
 		}
 
-		return &DoWhileStmt{Body: stmt, Condition: exp}, nil
+		return &DoWhileStmt{Loc: nextToken.Loc, Body: stmt, Condition: exp}, nil
 	case lexer.TokenFor:
 		p.expect(lexer.TokenFor)
+		// A declaration in the initializer is scoped to the loop
+		p.enterScope()
+		defer p.exitScope()
 		if exists, tok := p.expect(lexer.TokenOpenParen); !exists {
 			return nil, errors.NewParseError("missing open parenthesis", tok.Loc)
 		}
@@ -295,8 +927,17 @@ func (p *Parser) parseStatement() (Statement, error) {
 			return nil, err
 		}
 
-		return &ForStmt{Init: init, Condition: condition, Post: post, Body: stmt}, nil
+		return &ForStmt{Loc: nextToken.Loc, Init: init, Condition: condition, Post: post, Body: stmt}, nil
 	default:
+		if nextToken.Type == lexer.TokenIdentifier && p.peekAhead(1).Type == lexer.TokenConditionalOpEnd {
+			p.index += 2
+			stmt, err := p.parseStatement()
+			if err != nil {
+				return nil, err
+			}
+			return &LabeledStmt{Loc: nextToken.Loc, Label: nextToken.Literal, Statement: stmt}, nil
+		}
+
 		expr, err := p.parseExpression(0)
 		if err != nil {
 			return nil, err
@@ -327,12 +968,20 @@ func (p *Parser) parseForInit() (ForInit, error) {
 	if p.peek().Type == lexer.TokenSemicolon {
 		p.expect(lexer.TokenSemicolon)
 		return nil, nil
-	} else if p.peek().Type == lexer.TokenInt {
-		decl, err := p.parseDeclaration()
+	} else if p.startsDeclaration() {
+		loc := p.peek().Loc
+		decls, err := p.parseDeclaration()
 		if err != nil {
 			return nil, err
 		}
-		return &InitDecl{Declaration: *decl}, nil
+		var varDecl *VarDecl
+		if len(decls) == 1 {
+			varDecl, _ = decls[0].(*VarDecl)
+		}
+		if varDecl == nil {
+			return nil, errors.NewParseError("only variables can be declared in a for loop initializer", loc)
+		}
+		return &InitDecl{Declaration: *varDecl}, nil
 	}
 
 	exp, err := p.parseOptionalExpression(lexer.TokenSemicolon)
@@ -366,6 +1015,15 @@ func (p *Parser) parseExpression(minPrecedence int) (Expression, error) {
 
 			leftExpr = &AssignmentExp{Loc: nextToken.Loc, Left: leftExpr, Right: rightExpr}
 
+		} else if op, isCompound := compoundAssignmentOps[nextToken.Type]; isCompound {
+			p.expect(nextToken.Type)
+			rightExpr, err := p.parseExpression(precedence)
+			if err != nil {
+				return nil, err
+			}
+
+			leftExpr = &CompoundAssignmentExp{Loc: nextToken.Loc, Op: op, Left: leftExpr, Right: rightExpr}
+
 		} else if nextToken.Type == lexer.TokenConditionalOpFront {
 			middle, err := p.parseConditionalMiddle()
 			if err != nil {
@@ -408,21 +1066,46 @@ func (p *Parser) parseConditionalMiddle() (Expression, error) {
 func (p *Parser) parseFactor() (Factor, error) {
 	nextTok := p.peek()
 	switch nextTok.Type {
-	case lexer.TokenConstant:
+	case lexer.TokenConstant, lexer.TokenLongConstant, lexer.TokenUnsignedConstant, lexer.TokenUnsignedLongConstant, lexer.TokenCharConstant:
 		intNode, err := p.parseInt()
 		if err != nil {
 			return nil, err
 		}
-		return intNode, nil
+		return p.parsePostfix(intNode)
+	case lexer.TokenDoubleConstant:
+		doubleNode, err := p.parseDouble()
+		if err != nil {
+			return nil, err
+		}
+		return p.parsePostfix(doubleNode)
+	case lexer.TokenStringLiteral:
+		// Adjacent string literals are concatenated
+		value := ""
+		for p.peek().Type == lexer.TokenStringLiteral {
+			value += p.peek().Literal
+			p.expect(lexer.TokenStringLiteral)
+		}
+		return p.parsePostfix(&StringLiteral{Loc: nextTok.Loc, Value: value})
 
-	case lexer.TokenNegationOp, lexer.TokenBitwiseCompOp, lexer.TokenNotOp:
+	case lexer.TokenNegationOp, lexer.TokenBitwiseCompOp, lexer.TokenNotOp, lexer.TokenMultiplicationOp, lexer.TokenAmpersand,
+		lexer.TokenIncrementOp, lexer.TokenDecrementOp:
 		unopNode, err := p.parseUnaryOp()
 		if err != nil {
 			return nil, err
 		}
 		return unopNode, nil
 
+	case lexer.TokenSizeof:
+		return p.parseSizeof()
+
+	case lexer.TokenVaStart, lexer.TokenVaArg, lexer.TokenVaEnd, lexer.TokenVaCopy:
+		return p.parseVaBuiltin()
+
 	case lexer.TokenOpenParen:
+		if p.isTypeName(p.peekAhead(1)) {
+			return p.parseCast()
+		}
+
 		p.expect(lexer.TokenOpenParen)
 		expr, err := p.parseExpression(0)
 		if err != nil {
@@ -431,22 +1114,225 @@ func (p *Parser) parseFactor() (Factor, error) {
 		if exists, tok := p.expect(lexer.TokenCloseParen); !exists {
 			return nil, errors.NewParseError("missing )", tok.Loc)
 		}
-		return &NestedExp{Loc: nextTok.Loc, Expr: expr}, nil
+		return p.parsePostfix(&NestedExp{Loc: nextTok.Loc, Expr: expr})
 
 	default:
 		ident, err := p.parseIdentifier()
 		if err != nil {
 			return nil, err
 		}
-		return &ident, nil
+
+		if p.peek().Type == lexer.TokenOpenParen {
+			args, err := p.parseArgumentList()
+			if err != nil {
+				return nil, err
+			}
+			return p.parsePostfix(&FunctionCall{Loc: ident.Loc, Name: ident, Args: args})
+		}
+		return p.parsePostfix(&ident)
 	}
 }
 
-func (p *Parser) parseUnaryOp() (*UnaryFactor, error) {
+// parsePostfix applies any subscripts, member accesses and postfix increments or decrements that
+// follow a primary expression
+func (p *Parser) parsePostfix(primary Factor) (Factor, error) {
+	for {
+		opTok := p.peek()
+		switch opTok.Type {
+		case lexer.TokenOpenBracket:
+			p.expect(lexer.TokenOpenBracket)
+			index, err := p.parseExpression(0)
+			if err != nil {
+				return nil, err
+			}
+			if exists, tok := p.expect(lexer.TokenCloseBracket); !exists {
+				return nil, errors.NewParseError("missing ] after subscript", tok.Loc)
+			}
+			primary = &SubscriptFactor{Loc: opTok.Loc, Expr: primary, Index: index}
+		case lexer.TokenDot, lexer.TokenArrow:
+			p.index++
+			member, err := p.parseIdentifier()
+			if err != nil {
+				return nil, errors.NewParseError("missing member name after "+opTok.Literal, opTok.Loc)
+			}
+			if opTok.Type == lexer.TokenDot {
+				primary = &DotFactor{Loc: opTok.Loc, Expr: primary, Member: member.Value}
+			} else {
+				primary = &ArrowFactor{Loc: opTok.Loc, Expr: primary, Member: member.Value}
+			}
+		case lexer.TokenIncrementOp, lexer.TokenDecrementOp:
+			p.index++
+			primary = &IncDecFactor{Loc: opTok.Loc, Decrement: opTok.Type == lexer.TokenDecrementOp, Postfix: true, Expr: primary}
+		default:
+			return primary, nil
+		}
+	}
+}
+
+func (p *Parser) parseCast() (*CastFactor, error) {
+	openTok := p.peek()
+	targetType, err := p.parseParenthesizedType()
+	if err != nil {
+		return nil, err
+	}
+
+	operand, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CastFactor{Loc: openTok.Loc, TargetType: targetType, Expr: &FactorExp{Loc: openTok.Loc, Factor: operand}}, nil
+}
+
+// parseParenthesizedType parses "(" <type-name> [ <abstract-declarator> ] ")", as used by casts and sizeof
+func (p *Parser) parseParenthesizedType() (types.Type, error) {
+	p.expect(lexer.TokenOpenParen)
+
+	t, err := p.parseAbstractType()
+	if err != nil {
+		return nil, err
+	}
+
+	if exists, tok := p.expect(lexer.TokenCloseParen); !exists {
+		return nil, errors.NewParseError("missing ) after type name", tok.Loc)
+	}
+	return t, nil
+}
+
+// parseAbstractType parses <type-name> [ <abstract-declarator> ], which must be followed by a ")"
+func (p *Parser) parseAbstractType() (types.Type, error) {
+	startTok := p.peek()
+	t, err := p.parseTypeName()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().Type != lexer.TokenCloseParen {
+		decl, err := p.parseAbstractDeclarator()
+		if err != nil {
+			return nil, err
+		}
+		return processAbstractDeclarator(decl, t, startTok.Loc)
+	}
+	return t, nil
+}
+
+// parseSizeof parses "sizeof" "(" <type-name> ")" | "sizeof" <factor>
+func (p *Parser) parseSizeof() (Factor, error) {
+	sizeofTok := p.peek()
+	p.expect(lexer.TokenSizeof)
+
+	if p.peek().Type == lexer.TokenOpenParen && p.isTypeName(p.peekAhead(1)) {
+		t, err := p.parseParenthesizedType()
+		if err != nil {
+			return nil, err
+		}
+		return &SizeOfTypeFactor{Loc: sizeofTok.Loc, TargetType: t}, nil
+	}
+
+	operand, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	return &SizeOfExpFactor{Loc: sizeofTok.Loc, Expr: operand}, nil
+}
+
+var vaBuiltins = map[lexer.TokenType]VaBuiltin{
+	lexer.TokenVaStart: VaStart,
+	lexer.TokenVaArg:   VaArg,
+	lexer.TokenVaEnd:   VaEnd,
+	lexer.TokenVaCopy:  VaCopy,
+}
+
+// parseVaBuiltin parses a call to one of the va_list builtins: "(" <exp> ")" for va_end, and
+// "(" <exp> "," <exp> ")" for va_start and va_copy. The second argument of va_arg is a type name.
+func (p *Parser) parseVaBuiltin() (Factor, error) {
+	builtinTok := p.peek()
+	p.expect(builtinTok.Type)
+	builtin := &VaBuiltinFactor{Loc: builtinTok.Loc, Builtin: vaBuiltins[builtinTok.Type]}
+
+	if exists, tok := p.expect(lexer.TokenOpenParen); !exists {
+		return nil, errors.NewParseError("missing ( after builtin", tok.Loc)
+	}
+	list, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+	builtin.Args = []Expression{list}
+
+	if builtin.Builtin != VaEnd {
+		if exists, tok := p.expect(lexer.TokenComma); !exists {
+			return nil, errors.NewParseError("missing second argument to builtin", tok.Loc)
+		}
+		if builtin.Builtin == VaArg {
+			builtin.ArgType, err = p.parseAbstractType()
+		} else {
+			var arg Expression
+			arg, err = p.parseExpression(0)
+			builtin.Args = append(builtin.Args, arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if exists, tok := p.expect(lexer.TokenCloseParen); !exists {
+		return nil, errors.NewParseError("missing )", tok.Loc)
+	}
+	return p.parsePostfix(builtin)
+}
+
+func (p *Parser) parseArgumentList() ([]Expression, error) {
+	p.expect(lexer.TokenOpenParen)
+
+	args := []Expression{}
+	if p.peek().Type == lexer.TokenCloseParen {
+		p.expect(lexer.TokenCloseParen)
+		return args, nil
+	}
+
+	for {
+		arg, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if p.peek().Type != lexer.TokenComma {
+			break
+		}
+		p.expect(lexer.TokenComma)
+	}
+
+	if exists, tok := p.expect(lexer.TokenCloseParen); !exists {
+		return nil, errors.NewParseError("missing )", tok.Loc)
+	}
+
+	return args, nil
+}
+
+func (p *Parser) parseUnaryOp() (Factor, error) {
 	var opType UnopType
 
 	nextTok := p.peek()
 	switch nextTok.Type {
+	case lexer.TokenMultiplicationOp, lexer.TokenAmpersand:
+		p.index++
+		exp, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		if nextTok.Type == lexer.TokenMultiplicationOp {
+			return &DereferenceFactor{Loc: nextTok.Loc, Expr: exp}, nil
+		}
+		return &AddressOfFactor{Loc: nextTok.Loc, Expr: exp}, nil
+	case lexer.TokenIncrementOp, lexer.TokenDecrementOp:
+		p.index++
+		exp, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &IncDecFactor{Loc: nextTok.Loc, Decrement: nextTok.Type == lexer.TokenDecrementOp, Expr: exp}, nil
 	case lexer.TokenBitwiseCompOp:
 		p.expect(lexer.TokenBitwiseCompOp)
 		opType = UnopBitwiseComp
@@ -510,6 +1396,21 @@ func (p *Parser) parseBinaryOp() (BinopType, error) {
 	case lexer.TokenGreaterOrEqualOp:
 		p.expect(lexer.TokenGreaterOrEqualOp)
 		return BinopGreaterOrEqual, nil
+	case lexer.TokenAmpersand:
+		p.expect(lexer.TokenAmpersand)
+		return BinopBitwiseAnd, nil
+	case lexer.TokenBitwiseOrOp:
+		p.expect(lexer.TokenBitwiseOrOp)
+		return BinopBitwiseOr, nil
+	case lexer.TokenBitwiseXorOp:
+		p.expect(lexer.TokenBitwiseXorOp)
+		return BinopBitwiseXor, nil
+	case lexer.TokenShiftLeftOp:
+		p.expect(lexer.TokenShiftLeftOp)
+		return BinopShiftLeft, nil
+	case lexer.TokenShiftRightOp:
+		p.expect(lexer.TokenShiftRightOp)
+		return BinopShiftRight, nil
 
 	default:
 		return -1, errors.NewParseError("expected binary operator", nextTok.Loc)
@@ -524,16 +1425,80 @@ func (p *Parser) parseIdentifier() (IdentifierFactor, error) {
 	return IdentifierFactor{Loc: tok.Loc, Value: tok.Literal}, nil
 }
 
-func (p *Parser) parseInt() (*IntLiteral, error) {
-	exists, tok := p.expect(lexer.TokenConstant)
-	if !exists {
+func (p *Parser) parseInt() (*Constant, error) {
+	tok := p.peek()
+	switch tok.Type {
+	case lexer.TokenConstant, lexer.TokenLongConstant, lexer.TokenUnsignedConstant, lexer.TokenUnsignedLongConstant:
+	case lexer.TokenCharConstant:
+		p.index++
+		// Character constants have type int; char is signed, so bytes above 127 come out negative
+		return &Constant{Loc: tok.Loc, Value: types.ConstInt{Value: int32(int8(tok.Literal[0]))}}, nil
+	default:
 		return nil, errors.NewParseError("missing int constant", tok.Loc)
 	}
-	val, err := strconv.ParseInt(tok.Literal, 10, 0)
+	p.index++
+
+	digits, base := tok.Literal, 10
+	hex := strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X")
+	if hex {
+		digits, base = digits[2:], 16
+	}
+	val, err := strconv.ParseUint(digits, base, 64)
 	if err != nil {
-		return nil, err
+		return nil, errors.NewParseError("integer constant is too large", tok.Loc)
+	}
+
+	// A constant that doesn't fit in the type its suffix names is promoted to the long version of that
+	// type. A hex constant can also become unsigned, so 0xFFFFFFFF is an unsigned int rather than a long.
+	switch tok.Type {
+	case lexer.TokenConstant, lexer.TokenLongConstant:
+		if tok.Type == lexer.TokenConstant && val <= math.MaxInt32 {
+			return &Constant{Loc: tok.Loc, Value: types.ConstInt{Value: int32(val)}}, nil
+		}
+		if hex && tok.Type == lexer.TokenConstant && val <= math.MaxUint32 {
+			return &Constant{Loc: tok.Loc, Value: types.ConstUInt{Value: uint32(val)}}, nil
+		}
+		if val <= math.MaxInt64 {
+			return &Constant{Loc: tok.Loc, Value: types.ConstLong{Value: int64(val)}}, nil
+		}
+		if !hex {
+			return nil, errors.NewParseError("integer constant is too large", tok.Loc)
+		}
+	case lexer.TokenUnsignedConstant:
+		if val <= math.MaxUint32 {
+			return &Constant{Loc: tok.Loc, Value: types.ConstUInt{Value: uint32(val)}}, nil
+		}
 	}
-	return &IntLiteral{Loc: tok.Loc, Value: int(val)}, nil
+	return &Constant{Loc: tok.Loc, Value: types.ConstULong{Value: val}}, nil
+}
+
+func (p *Parser) parseDouble() (*Constant, error) {
+	tok := p.peek()
+	if tok.Type != lexer.TokenDoubleConstant {
+		return nil, errors.NewParseError("missing floating constant", tok.Loc)
+	}
+	p.index++
+
+	// ParseFloat rounds to the nearest double; out of range values come back as infinity along with an error
+	val, err := strconv.ParseFloat(tok.Literal, 64)
+	if numErr, ok := err.(*strconv.NumError); ok && numErr.Err != strconv.ErrRange {
+		return nil, errors.NewParseError("invalid floating constant", tok.Loc)
+	}
+	return &Constant{Loc: tok.Loc, Value: types.ConstDouble{Value: val}}, nil
+}
+
+// compoundAssignmentOps maps each compound assignment operator to the binary operation it performs
+var compoundAssignmentOps = map[lexer.TokenType]BinopType{
+	lexer.TokenAddAssignOp:        BinopAdd,
+	lexer.TokenSubtractAssignOp:   BinopSubtract,
+	lexer.TokenMultiplyAssignOp:   BinopMultiply,
+	lexer.TokenDivideAssignOp:     BinopDivide,
+	lexer.TokenRemainderAssignOp:  BinopRemainder,
+	lexer.TokenBitwiseAndAssignOp: BinopBitwiseAnd,
+	lexer.TokenBitwiseOrAssignOp:  BinopBitwiseOr,
+	lexer.TokenBitwiseXorAssignOp: BinopBitwiseXor,
+	lexer.TokenShiftLeftAssignOp:  BinopShiftLeft,
+	lexer.TokenShiftRightAssignOp: BinopShiftRight,
 }
 
 func binopPrecedence(tok lexer.Token) int {
@@ -542,17 +1507,27 @@ func binopPrecedence(tok lexer.Token) int {
 		return 50
 	case lexer.TokenAdditionOp, lexer.TokenNegationOp:
 		return 45
+	case lexer.TokenShiftLeftOp, lexer.TokenShiftRightOp:
+		return 40
 	case lexer.TokenLessThanOp, lexer.TokenLessOrEqualOp, lexer.TokenGreaterThanOp, lexer.TokenGreaterOrEqualOp:
 		return 35
 	case lexer.TokenEqualOp, lexer.TokenNotEqualOp:
 		return 30
+	case lexer.TokenAmpersand:
+		return 25
+	case lexer.TokenBitwiseXorOp:
+		return 20
+	case lexer.TokenBitwiseOrOp:
+		return 15
 	case lexer.TokenAndOp:
 		return 10
 	case lexer.TokenOrOp:
 		return 5
 	case lexer.TokenConditionalOpFront:
 		return 3
-	case lexer.TokenAssignmentOp:
+	case lexer.TokenAssignmentOp, lexer.TokenAddAssignOp, lexer.TokenSubtractAssignOp, lexer.TokenMultiplyAssignOp,
+		lexer.TokenDivideAssignOp, lexer.TokenRemainderAssignOp, lexer.TokenBitwiseAndAssignOp, lexer.TokenBitwiseOrAssignOp,
+		lexer.TokenBitwiseXorAssignOp, lexer.TokenShiftLeftAssignOp, lexer.TokenShiftRightAssignOp:
 		return 1
 	default:
 		return -1
@@ -0,0 +1,304 @@
+package semanticanalysis
+
+import (
+	"acc/internal/common/errors"
+	"acc/internal/common/symbols"
+	"acc/internal/common/types"
+	"acc/internal/constexpr"
+	"acc/internal/parser"
+)
+
+// declareFunction checks a function declaration against every earlier declaration of the same name
+func (a *SemanticAnalyzer) declareFunction(function *parser.FunctionDecl) error {
+	name := function.Name.Value
+	evaluated, err := a.evaluateType(function.Type, function.Loc)
+	if err != nil {
+		return err
+	}
+	funType := evaluated.(types.FunType)
+	function.Type = funType
+	defined := function.Body != nil
+	global := function.StorageClass != parser.StorageClassStatic
+
+	// Only a definition needs complete parameter and return types; a declaration can
+	// mention structs that haven't been defined yet
+	for _, param := range funType.Params {
+		if isVoid(param) {
+			return errors.NewAnalysisError("parameter of "+name+" has type void", function.Loc)
+		}
+	}
+	err = validateType(funType, function.Loc)
+	if err != nil {
+		return err
+	}
+
+	if existing, ok := a.Symbols.Get(name); ok {
+		if !types.Equal(existing.Type, funType) {
+			return errors.NewAnalysisError("conflicting declarations of "+name, function.Loc)
+		}
+
+		attrs := existing.Attrs.(symbols.FunAttrs)
+		if attrs.Defined && defined {
+			return errors.NewAnalysisError("function "+name+" is defined more than once", function.Loc)
+		}
+		if attrs.Global && !global {
+			return errors.NewAnalysisError("static declaration of "+name+" follows non-static declaration", function.Loc)
+		}
+		global = attrs.Global
+		defined = defined || attrs.Defined
+	}
+
+	a.Symbols.Add(name, &symbols.Symbol{Type: funType, Attrs: symbols.FunAttrs{Defined: defined, Global: global}})
+	return nil
+}
+
+// declareFileScopeVar merges a file-scope variable declaration with any earlier declarations,
+// resolving tentative definitions and linkage
+func (a *SemanticAnalyzer) declareFileScopeVar(declaration *parser.VarDecl) error {
+	name := declaration.Name.Value
+	err := a.validateVarType(declaration)
+	if err != nil {
+		return err
+	}
+
+	var init symbols.InitialValue
+	if declaration.Init == nil {
+		if declaration.StorageClass == parser.StorageClassExtern {
+			init = symbols.NoInitializer{}
+		} else {
+			init = symbols.Tentative{}
+		}
+	} else {
+		values, err := a.staticInitializer(declaration.Init, declaration.Type, declaration.SourceName, declaration.Loc)
+		if err != nil {
+			return err
+		}
+		init = symbols.Initial{Values: values}
+	}
+
+	global := declaration.StorageClass != parser.StorageClassStatic
+
+	if existing, ok := a.Symbols.Get(name); ok {
+		if _, isFunction := existing.Type.(types.FunType); isFunction {
+			return errors.NewAnalysisError("function "+name+" redeclared as variable", declaration.Loc)
+		}
+		if !types.Equal(existing.Type, declaration.Type) {
+			return errors.NewAnalysisError("conflicting types for "+name, declaration.Loc)
+		}
+
+		attrs := existing.Attrs.(symbols.StaticAttrs)
+		if declaration.StorageClass == parser.StorageClassExtern {
+			global = attrs.Global
+		} else if attrs.Global != global {
+			return errors.NewAnalysisError("conflicting linkage for "+name, declaration.Loc)
+		}
+
+		if _, isInitial := attrs.Init.(symbols.Initial); isInitial {
+			if _, newIsInitial := init.(symbols.Initial); newIsInitial {
+				return errors.NewAnalysisError("conflicting definitions of "+name, declaration.Loc)
+			}
+			init = attrs.Init
+		} else if _, isTentative := attrs.Init.(symbols.Tentative); isTentative {
+			if _, newIsInitial := init.(symbols.Initial); !newIsInitial {
+				init = symbols.Tentative{}
+			}
+		}
+	}
+
+	a.Symbols.Add(name, &symbols.Symbol{Type: declaration.Type, Attrs: symbols.StaticAttrs{Init: init, Global: global}})
+	return nil
+}
+
+// declareLocalVar records a block-scope variable; name must already be resolved
+func (a *SemanticAnalyzer) declareLocalVar(declaration *parser.VarDecl) error {
+	name := declaration.Name.Value
+	err := a.validateVarType(declaration)
+	if err != nil {
+		return err
+	}
+
+	switch declaration.StorageClass {
+	case parser.StorageClassExtern:
+		if declaration.Init != nil {
+			return errors.NewAnalysisError("initializer on local extern declaration of "+name, declaration.Loc)
+		}
+		if existing, ok := a.Symbols.Get(name); ok {
+			if _, isFunction := existing.Type.(types.FunType); isFunction {
+				return errors.NewAnalysisError("function "+name+" redeclared as variable", declaration.Loc)
+			}
+			if !types.Equal(existing.Type, declaration.Type) {
+				return errors.NewAnalysisError("conflicting types for "+name, declaration.Loc)
+			}
+			return nil
+		}
+		a.Symbols.Add(name, &symbols.Symbol{Type: declaration.Type, Attrs: symbols.StaticAttrs{Init: symbols.NoInitializer{}, Global: true}})
+	case parser.StorageClassStatic:
+		values := []symbols.StaticInit{symbols.ZeroInit{Bytes: types.Size(declaration.Type)}}
+		if declaration.Init != nil {
+			values, err = a.staticInitializer(declaration.Init, declaration.Type, declaration.SourceName, declaration.Loc)
+			if err != nil {
+				return err
+			}
+		}
+		init := symbols.Initial{Values: values}
+		a.Symbols.Add(name, &symbols.Symbol{Type: declaration.Type, Attrs: symbols.StaticAttrs{Init: init, Global: false}})
+	default:
+		a.Symbols.Add(name, &symbols.Symbol{Type: declaration.Type, Attrs: symbols.LocalAttrs{}})
+	}
+	return nil
+}
+
+// validateVarType evaluates the array sizes in the type of a variable declaration and checks it.
+// Only an extern declaration without an initializer can have an incomplete struct type, since it
+// doesn't allocate any storage.
+func (a *SemanticAnalyzer) validateVarType(declaration *parser.VarDecl) error {
+	var err error
+	declaration.Type, err = a.evaluateType(declaration.Type, declaration.Loc)
+	if err != nil {
+		return err
+	}
+	err = validateType(declaration.Type, declaration.Loc)
+	if err != nil {
+		return err
+	}
+	name, t := declaration.SourceName, declaration.Type
+	if isVoid(t) {
+		return errors.NewAnalysisError("variable "+name+" has type void", declaration.Loc)
+	}
+	if !types.IsComplete(t) && (declaration.StorageClass != parser.StorageClassExtern || declaration.Init != nil) {
+		return errors.NewAnalysisError("variable "+name+" has incomplete type "+t.String(), declaration.Loc)
+	}
+	return nil
+}
+
+// staticInitializer converts the initializer of a variable with static storage duration into the
+// values it stores, with any elements the initializer leaves out filled with zeros
+func (a *SemanticAnalyzer) staticInitializer(init parser.Initializer, t types.Type, name string, loc errors.Location) ([]symbols.StaticInit, error) {
+	switch init := init.(type) {
+	case *parser.SingleInit:
+		if str, isString := stringLiteral(init.Expr); isString {
+			return a.staticStringInitializer(str, t, name, loc)
+		}
+		if _, isArray := t.(types.Array); isArray {
+			return nil, errors.NewAnalysisError("array "+name+" must be initialized with a brace-enclosed list", loc)
+		}
+		// Type checking gives sizeof expressions in the initializer the types they need to be evaluated
+		err := a.typecheckExpression(&init.Expr)
+		if err != nil {
+			return nil, err
+		}
+		if isPointer(t) {
+			if !isNullPointerConstant(init.Expr) {
+				return nil, errors.NewAnalysisError("pointer "+name+" can only be statically initialized to null", loc)
+			}
+			return []symbols.StaticInit{symbols.ConstInit{Value: types.ConstULong{Value: 0}}}, nil
+		}
+		if !types.IsArithmetic(t) {
+			return nil, errors.NewAnalysisError(name+" must be initialized with a brace-enclosed list", loc)
+		}
+		value, err := constexpr.EvaluateArithmetic(init.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return []symbols.StaticInit{symbols.ConstInit{Value: types.ConvertConst(value, t)}}, nil
+	case *parser.CompoundInit:
+		if structure, isStruct := t.(types.Structure); isStruct {
+			return a.staticStructInitializer(init, structure, name, loc)
+		}
+		array, isArray := t.(types.Array)
+		if !isArray {
+			return nil, errors.NewAnalysisError("brace-enclosed initializer for scalar "+name, loc)
+		}
+		if len(init.Inits) > array.Length {
+			return nil, errors.NewAnalysisError("too many elements in initializer for "+name, loc)
+		}
+
+		values := []symbols.StaticInit{}
+		for _, item := range init.Inits {
+			itemValues, err := a.staticInitializer(item, array.Element, name, loc)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, itemValues...)
+		}
+		if remaining := array.Length - len(init.Inits); remaining > 0 {
+			values = append(values, symbols.ZeroInit{Bytes: remaining * types.Size(array.Element)})
+		}
+		return values, nil
+	default:
+		panic("invalid initializer type")
+	}
+}
+
+// staticStructInitializer initializes the members of a struct in order, zeroing the padding between
+// them and any members the initializer leaves out. A union is initialized through its first member.
+func (a *SemanticAnalyzer) staticStructInitializer(init *parser.CompoundInit, structure types.Structure, name string, loc errors.Location) ([]symbols.StaticInit, error) {
+	members := initializedMembers(structure)
+	if len(init.Inits) > len(members) {
+		return nil, errors.NewAnalysisError("too many elements in initializer for "+name, loc)
+	}
+
+	values := []symbols.StaticInit{}
+	offset := 0
+	for i, item := range init.Inits {
+		member := members[i]
+		if member.Offset > offset {
+			values = append(values, symbols.ZeroInit{Bytes: member.Offset - offset})
+		}
+		memberValues, err := a.staticInitializer(item, member.Type, name, loc)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, memberValues...)
+		offset = member.Offset + types.Size(member.Type)
+	}
+	if structure.Def.Size > offset {
+		values = append(values, symbols.ZeroInit{Bytes: structure.Def.Size - offset})
+	}
+	return values, nil
+}
+
+// staticStringInitializer initializes either a char array with the contents of a string literal, or a
+// char pointer with the address of a read-only copy of it
+func (a *SemanticAnalyzer) staticStringInitializer(str *parser.StringLiteral, t types.Type, name string, loc errors.Location) ([]symbols.StaticInit, error) {
+	switch t := t.(type) {
+	case types.Array:
+		if !types.IsCharacter(t.Element) {
+			return nil, errors.NewAnalysisError("string literal can only initialize a character array", loc)
+		}
+		if len(str.Value) > t.Length {
+			return nil, errors.NewAnalysisError("string literal is too long to initialize "+name, loc)
+		}
+
+		values := []symbols.StaticInit{symbols.StringInit{Value: str.Value, NullTerminated: len(str.Value) < t.Length}}
+		if padding := t.Length - len(str.Value) - 1; padding > 0 {
+			values = append(values, symbols.ZeroInit{Bytes: padding})
+		}
+		return values, nil
+	case types.Pointer:
+		if _, isChar := t.Referenced.(types.Char); !isChar {
+			return nil, errors.NewAnalysisError("string literal can only initialize a char pointer", loc)
+		}
+		return []symbols.StaticInit{symbols.PointerInit{Name: a.stringConstant(str.Value)}}, nil
+	default:
+		return nil, errors.NewAnalysisError("string literal can't initialize "+name, loc)
+	}
+}
+
+// stringConstant records a null-terminated read-only copy of value and returns its name
+func (a *SemanticAnalyzer) stringConstant(value string) string {
+	name := a.makeTemporaryVar("string")
+	stringType := types.Array{Element: types.Char{}, Length: len(value) + 1}
+	a.Symbols.Add(name, &symbols.Symbol{Type: stringType, Attrs: symbols.ConstantAttrs{Init: symbols.StringInit{Value: value, NullTerminated: true}}})
+	return name
+}
+
+// stringLiteral returns the string literal exp consists of, if it is one
+func stringLiteral(exp parser.Expression) (*parser.StringLiteral, bool) {
+	factor, ok := exp.(*parser.FactorExp)
+	if !ok {
+		return nil, false
+	}
+	str, ok := factor.Factor.(*parser.StringLiteral)
+	return str, ok
+}
@@ -2,23 +2,44 @@ package semanticanalysis
 
 import (
 	"acc/internal/common/errors"
+	"acc/internal/common/types"
+	"acc/internal/constexpr"
 	"acc/internal/parser"
 	"fmt"
 )
 
-func (a *SemanticAnalyzer) makeLabel() string {
+func (a *SemanticAnalyzer) makeLabel(prefix string) string {
 	a.TempVarCounter++
-	return fmt.Sprintf("loop.%d", a.TempVarCounter)
+	return fmt.Sprintf("%s.%d", prefix, a.TempVarCounter)
+}
+
+// labelContext records what break, continue, case and default refer to at a point in a function.
+// break goes to the innermost loop or switch, while continue skips over switches to the innermost loop.
+type labelContext struct {
+	breakLabel    string
+	continueLabel string
+	switchStmt    *parser.SwitchStmt
+	caseValues    map[types.Const]bool
 }
 
 func (a *SemanticAnalyzer) LabelLoops() error {
-	return a.labelBlock(a.program.Function.Body, "")
+	for _, declaration := range a.program.Declarations {
+		function, ok := declaration.(*parser.FunctionDecl)
+		if !ok || function.Body == nil {
+			continue
+		}
+		err := a.labelBlock(*function.Body, labelContext{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (a *SemanticAnalyzer) labelBlock(block parser.Block, currentLabel string) error {
+func (a *SemanticAnalyzer) labelBlock(block parser.Block, ctx labelContext) error {
 	for _, v := range block.Body {
 		if stmt, ok := v.(*parser.StmtBlock); ok {
-			err := a.labelStatement(stmt.Statement, currentLabel)
+			err := a.labelStatement(stmt.Statement, ctx)
 			if err != nil {
 				return err
 			}
@@ -27,58 +48,171 @@ func (a *SemanticAnalyzer) labelBlock(block parser.Block, currentLabel string) e
 	return nil
 }
 
-func (a *SemanticAnalyzer) labelStatement(stmt parser.Statement, currentLabel string) error {
+// loopContext is the context inside the body of a loop labeled label
+func (ctx labelContext) loopContext(label string) labelContext {
+	ctx.breakLabel = label
+	ctx.continueLabel = label
+	return ctx
+}
+
+func (a *SemanticAnalyzer) labelStatement(stmt parser.Statement, ctx labelContext) error {
 	switch stmt := stmt.(type) {
 	case *parser.BreakStmt:
-		if currentLabel == "" {
-			return errors.NewAnalysisError("break statement outside of loop", errors.Location{})
+		if ctx.breakLabel == "" {
+			return errors.NewAnalysisError("break statement outside of loop or switch", stmt.Loc)
 		}
-		stmt.Label = currentLabel
+		stmt.Label = ctx.breakLabel
 		return nil
 	case *parser.ContinueStmt:
-		if currentLabel == "" {
-			return errors.NewAnalysisError("continue statement outside of loop", errors.Location{})
+		if ctx.continueLabel == "" {
+			return errors.NewAnalysisError("continue statement outside of loop", stmt.Loc)
 		}
-		stmt.Label = currentLabel
+		stmt.Label = ctx.continueLabel
 		return nil
 	case *parser.WhileStmt:
-		newLabel := a.makeLabel()
-		err := a.labelStatement(stmt.Body, newLabel)
+		stmt.Label = a.makeLabel("loop")
+		return a.labelStatement(stmt.Body, ctx.loopContext(stmt.Label))
+	case *parser.DoWhileStmt:
+		stmt.Label = a.makeLabel("loop")
+		return a.labelStatement(stmt.Body, ctx.loopContext(stmt.Label))
+	case *parser.ForStmt:
+		stmt.Label = a.makeLabel("loop")
+		return a.labelStatement(stmt.Body, ctx.loopContext(stmt.Label))
+	case *parser.SwitchStmt:
+		stmt.Label = a.makeLabel("switch")
+		ctx.breakLabel = stmt.Label
+		ctx.switchStmt = stmt
+		ctx.caseValues = map[types.Const]bool{}
+		return a.labelStatement(stmt.Body, ctx)
+	case *parser.CaseStmt:
+		if ctx.switchStmt == nil {
+			return errors.NewAnalysisError("case statement outside of switch", stmt.Loc)
+		}
+		value, err := constexpr.EvaluateInteger(stmt.Expr)
 		if err != nil {
 			return err
 		}
-		stmt.Label = newLabel
-		return nil
-	case *parser.DoWhileStmt:
-		newLabel := a.makeLabel()
-		err := a.labelStatement(stmt.Body, newLabel)
+		stmt.Value = types.ConvertConst(value, ctx.switchStmt.Condition.GetType())
+		if ctx.caseValues[stmt.Value] {
+			return errors.NewAnalysisError("duplicate case value "+stmt.Value.String(), stmt.Loc)
+		}
+		ctx.caseValues[stmt.Value] = true
+		stmt.Label = a.makeLabel("case")
+		ctx.switchStmt.Cases = append(ctx.switchStmt.Cases, stmt)
+		return a.labelStatement(stmt.Statement, ctx)
+	case *parser.DefaultStmt:
+		if ctx.switchStmt == nil {
+			return errors.NewAnalysisError("default statement outside of switch", stmt.Loc)
+		}
+		if ctx.switchStmt.Default != nil {
+			return errors.NewAnalysisError("multiple default labels in one switch", stmt.Loc)
+		}
+		stmt.Label = "default_" + ctx.switchStmt.Label
+		ctx.switchStmt.Default = stmt
+		return a.labelStatement(stmt.Statement, ctx)
+	case *parser.IfStmt:
+		err := a.labelStatement(stmt.Then, ctx)
 		if err != nil {
 			return err
 		}
-		stmt.Label = newLabel
+		if stmt.Else != nil {
+			err := a.labelStatement(stmt.Else, ctx)
+			if err != nil {
+				return err
+			}
+		}
 		return nil
-	case *parser.ForStmt:
-		newLabel := a.makeLabel()
-		err := a.labelStatement(stmt.Body, newLabel)
+	case *parser.CompoundStmt:
+		return a.labelBlock(stmt.Block, ctx)
+	case *parser.LabeledStmt:
+		return a.labelStatement(stmt.Statement, ctx)
+	default:
+		return nil
+	}
+}
+
+// functionLabels holds the labels defined in one function and the goto statements that jump to them
+type functionLabels struct {
+	defined map[string]*parser.LabeledStmt
+	gotos   []*parser.GotoStmt
+}
+
+// ResolveGotoLabels checks that every label is defined once in its function and that every goto jumps
+// to a label in the same function. Labels are then renamed to <function>.<label>, which keeps them
+// apart across functions and from the labels the compiler generates: those all end in .<number>, and
+// a label is an identifier, so it can't be a number.
+func (a *SemanticAnalyzer) ResolveGotoLabels() error {
+	for _, declaration := range a.program.Declarations {
+		function, ok := declaration.(*parser.FunctionDecl)
+		if !ok || function.Body == nil {
+			continue
+		}
+
+		labels := functionLabels{defined: map[string]*parser.LabeledStmt{}}
+		err := labels.collectBlock(*function.Body)
 		if err != nil {
 			return err
 		}
-		stmt.Label = newLabel
+
+		for _, stmt := range labels.gotos {
+			if _, ok := labels.defined[stmt.Label]; !ok {
+				return errors.NewAnalysisError("goto to undefined label "+stmt.Label, stmt.Loc)
+			}
+			stmt.Label = function.Name.Value + "." + stmt.Label
+		}
+		for name, stmt := range labels.defined {
+			stmt.Label = function.Name.Value + "." + name
+		}
+	}
+	return nil
+}
+
+func (l *functionLabels) collectBlock(block parser.Block) error {
+	for _, v := range block.Body {
+		if stmt, ok := v.(*parser.StmtBlock); ok {
+			err := l.collectStatement(stmt.Statement)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (l *functionLabels) collectStatement(stmt parser.Statement) error {
+	switch stmt := stmt.(type) {
+	case *parser.LabeledStmt:
+		if _, exists := l.defined[stmt.Label]; exists {
+			return errors.NewAnalysisError("duplicate label "+stmt.Label, stmt.Loc)
+		}
+		l.defined[stmt.Label] = stmt
+		return l.collectStatement(stmt.Statement)
+	case *parser.GotoStmt:
+		l.gotos = append(l.gotos, stmt)
 		return nil
 	case *parser.IfStmt:
-		err := a.labelStatement(stmt.Then, currentLabel)
+		err := l.collectStatement(stmt.Then)
 		if err != nil {
 			return err
 		}
 		if stmt.Else != nil {
-			err := a.labelStatement(stmt.Else, currentLabel)
-			if err != nil {
-				return err
-			}
+			return l.collectStatement(stmt.Else)
 		}
 		return nil
 	case *parser.CompoundStmt:
-		return a.labelBlock(stmt.Block, currentLabel)
+		return l.collectBlock(stmt.Block)
+	case *parser.WhileStmt:
+		return l.collectStatement(stmt.Body)
+	case *parser.DoWhileStmt:
+		return l.collectStatement(stmt.Body)
+	case *parser.ForStmt:
+		return l.collectStatement(stmt.Body)
+	case *parser.SwitchStmt:
+		return l.collectStatement(stmt.Body)
+	case *parser.CaseStmt:
+		return l.collectStatement(stmt.Statement)
+	case *parser.DefaultStmt:
+		return l.collectStatement(stmt.Statement)
 	default:
 		return nil
 	}
@@ -2,52 +2,328 @@ package semanticanalysis
 
 import (
 	"acc/internal/common/errors"
+	"acc/internal/common/symbols"
+	"acc/internal/common/types"
 	"acc/internal/parser"
 	"fmt"
 )
 
 type SemanticAnalyzer struct {
 	variables      map[string]Variable
+	structs        map[string]StructTag
+	Symbols        *symbols.Table
 	TempVarCounter int
+	returnType     types.Type
+	variadic       bool
 	program        parser.Program
+	enumerators    map[string]types.Const
 }
 
+// Variable is what an ordinary identifier resolves to. Typedef names and enumerators share the
+// namespace with variables and functions; for a typedef name, Typedef is the type it stands for.
+// An enumerator gets a unique name like a local variable, and its value is worked out during type
+// checking.
 type Variable struct {
 	NewName          string
 	FromCurrentBlock bool
+	HasLinkage       bool
+	Typedef          types.Type
+	Enumerator       bool
+}
+
+// StructTag is what a struct, union or enum tag resolves to; tags live in their own namespace,
+// separate from variables. An enum tag has no definition to share, since every enum is an int.
+type StructTag struct {
+	NewTag           string
+	Union            bool
+	Enum             bool
+	Def              *types.StructDef
+	FromCurrentBlock bool
 }
 
 func (a *SemanticAnalyzer) copyVars() map[string]Variable {
 	newVar := make(map[string]Variable, len(a.variables))
 	for k, v := range a.variables {
-		newVar[k] = Variable{NewName: v.NewName, FromCurrentBlock: false}
+		newVar[k] = Variable{NewName: v.NewName, FromCurrentBlock: false, HasLinkage: v.HasLinkage, Typedef: v.Typedef, Enumerator: v.Enumerator}
 	}
 	return newVar
 }
 
+func (a *SemanticAnalyzer) copyStructs() map[string]StructTag {
+	newStructs := make(map[string]StructTag, len(a.structs))
+	for k, v := range a.structs {
+		newStructs[k] = StructTag{NewTag: v.NewTag, Union: v.Union, Enum: v.Enum, Def: v.Def, FromCurrentBlock: false}
+	}
+	return newStructs
+}
+
 func NewSemanticAnalyzer(program parser.Program) SemanticAnalyzer {
-	return SemanticAnalyzer{program: program, variables: make(map[string]Variable)}
+	return SemanticAnalyzer{
+		program: program,
+		// The parser treats __builtin_va_list as a predeclared typedef name, so it needs a type here too
+		variables:   map[string]Variable{"__builtin_va_list": {Typedef: types.VaList()}},
+		structs:     make(map[string]StructTag),
+		Symbols:     symbols.NewTable(),
+		enumerators: make(map[string]types.Const),
+	}
 }
 
 func (a *SemanticAnalyzer) makeTemporaryVar(prefix string) string {
 	a.TempVarCounter++
-	return fmt.Sprintf("%d.%s", a.TempVarCounter, prefix)
+	return fmt.Sprintf("%s.%d", prefix, a.TempVarCounter)
 }
 
 func (a *SemanticAnalyzer) ResolveVariables() error {
-	return a.resolveBlock(&a.program.Function.Body)
+	for _, declaration := range a.program.Declarations {
+		err := a.resolveFileScopeDeclaration(declaration)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (a *SemanticAnalyzer) resolveDeclaration(declaration *parser.Declaration) error {
+// resolveFileScopeDeclaration handles declarations that appear outside of any function
+func (a *SemanticAnalyzer) resolveFileScopeDeclaration(declaration parser.Declaration) error {
+	switch decl := declaration.(type) {
+	case *parser.FunctionDecl:
+		return a.resolveFunctionDecl(decl)
+	case *parser.VarDecl:
+		var err error
+		decl.Type, err = a.resolveType(decl.Type, decl.Loc)
+		if err != nil {
+			return err
+		}
+		if variable, ok := a.variables[decl.Name.Value]; ok && (variable.Typedef != nil || variable.Enumerator) {
+			return errors.NewAnalysisError("conflicting declaration of "+decl.Name.Value, decl.Loc)
+		}
+		// File-scope variables keep their names so they can be linked against
+		a.variables[decl.Name.Value] = Variable{NewName: decl.Name.Value, FromCurrentBlock: true, HasLinkage: true}
+		if decl.Init != nil {
+			// The initializer must be constant, but it can name enumerators
+			return a.resolveInitializer(decl.Init)
+		}
+		return nil
+	case *parser.StructDecl:
+		return a.resolveStructDecl(decl)
+	case *parser.TypedefDecl:
+		return a.resolveTypedefDecl(decl)
+	case *parser.EnumDecl:
+		return a.resolveEnumDecl(decl)
+	case *parser.StaticAssertDecl:
+		return a.resolveExpression(&decl.Condition)
+	default:
+		panic("invalid declaration type")
+	}
+}
+
+// resolveDeclaration handles declarations that appear inside a block
+func (a *SemanticAnalyzer) resolveDeclaration(declaration parser.Declaration) error {
+	switch decl := declaration.(type) {
+	case *parser.VarDecl:
+		return a.resolveVarDecl(decl)
+	case *parser.FunctionDecl:
+		if decl.Body != nil {
+			return errors.NewAnalysisError("nested function definition", decl.Loc)
+		}
+		if decl.StorageClass == parser.StorageClassStatic {
+			return errors.NewAnalysisError("static storage class on block-scope function declaration", decl.Loc)
+		}
+		return a.resolveFunctionDecl(decl)
+	case *parser.StructDecl:
+		return a.resolveStructDecl(decl)
+	case *parser.TypedefDecl:
+		return a.resolveTypedefDecl(decl)
+	case *parser.EnumDecl:
+		return a.resolveEnumDecl(decl)
+	case *parser.StaticAssertDecl:
+		return a.resolveExpression(&decl.Condition)
+	default:
+		panic("invalid declaration type")
+	}
+}
+
+// resolveTypedefDecl records what a typedef name stands for, resolving struct tags in the scope of
+// the typedef rather than wherever the name is used. Repeating a typedef in the same scope is
+// allowed as long as it names the same type, which type checking confirms once array sizes are known.
+func (a *SemanticAnalyzer) resolveTypedefDecl(declaration *parser.TypedefDecl) error {
+	t, err := a.resolveType(declaration.Type, declaration.Loc)
+	if err != nil {
+		return err
+	}
+	if _, isFunction := t.(types.FunType); isFunction {
+		return errors.NewAnalysisError("typedef of a function type isn't supported", declaration.Loc)
+	}
+
+	if variable, ok := a.variables[declaration.Name]; ok && variable.FromCurrentBlock {
+		if variable.Typedef == nil {
+			return errors.NewAnalysisError("conflicting declaration of "+declaration.Name, declaration.Loc)
+		}
+		declaration.Previous = variable.Typedef
+	}
+	a.variables[declaration.Name] = Variable{FromCurrentBlock: true, Typedef: t}
+	declaration.Type = t
+	return nil
+}
+
+// resolveEnumDecl declares the enum's tag and gives each enumerator a unique name. Each enumerator is
+// in scope from the end of its own definition, so later values can refer to it.
+func (a *SemanticAnalyzer) resolveEnumDecl(declaration *parser.EnumDecl) error {
+	if tag, ok := a.structs[declaration.Tag]; ok && tag.FromCurrentBlock {
+		if !tag.Enum {
+			return errors.NewAnalysisError("tag "+declaration.Tag+" redeclared as a different kind of type", declaration.Loc)
+		}
+		return errors.NewAnalysisError("redefinition of enum "+declaration.Tag, declaration.Loc)
+	}
+	a.structs[declaration.Tag] = StructTag{Enum: true, FromCurrentBlock: true}
+
+	for i := range declaration.Enumerators {
+		enumerator := &declaration.Enumerators[i]
+		if enumerator.Value != nil {
+			err := a.resolveExpression(&enumerator.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+		if variable, ok := a.variables[enumerator.Name]; ok && variable.FromCurrentBlock {
+			if variable.Enumerator {
+				return errors.NewAnalysisError("redefinition of enumerator "+enumerator.Name, enumerator.Loc)
+			}
+			return errors.NewAnalysisError("conflicting declaration of "+enumerator.Name, enumerator.Loc)
+		}
+		a.variables[enumerator.Name] = Variable{NewName: a.makeTemporaryVar(enumerator.Name), FromCurrentBlock: true, Enumerator: true}
+		enumerator.Name = a.variables[enumerator.Name].NewName
+	}
+	return nil
+}
+
+// resolveStructDecl gives a struct tag a unique name. Redeclaring a tag in the same scope refers
+// to the same type, while declaring it in an inner scope introduces a new one that hides the outer.
+func (a *SemanticAnalyzer) resolveStructDecl(declaration *parser.StructDecl) error {
+	tag, ok := a.structs[declaration.Tag]
+	if !ok || !tag.FromCurrentBlock {
+		tag = StructTag{NewTag: a.makeTemporaryVar(declaration.Tag), Union: declaration.Union, Def: &types.StructDef{}, FromCurrentBlock: true}
+		a.structs[declaration.Tag] = tag
+	} else if tag.Enum || tag.Union != declaration.Union {
+		// Structs and unions share a namespace, so one tag can't name both in the same scope
+		return errors.NewAnalysisError("tag "+declaration.Tag+" redeclared as a different kind of type", declaration.Loc)
+	}
+	declaration.Tag = tag.NewTag
+	declaration.Def = tag.Def
+
+	for i := range declaration.Members {
+		member := &declaration.Members[i]
+		var err error
+		member.Type, err = a.resolveType(member.Type, member.Loc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveType replaces every struct tag in t with the unique tag it refers to, every enum type
+// with int, and every typedef name with the type it stands for. Array sizes are only resolved here;
+// they're evaluated during type checking, once the structs they can take the size of are laid out.
+func (a *SemanticAnalyzer) resolveType(t types.Type, loc errors.Location) (types.Type, error) {
+	switch t := t.(type) {
+	case types.Typedef:
+		variable, ok := a.variables[t.Name]
+		if !ok || variable.Typedef == nil {
+			return nil, errors.NewAnalysisError(t.Name+" is not a type name", loc)
+		}
+		return variable.Typedef, nil
+	case types.Enum:
+		tag, ok := a.structs[t.Tag]
+		if !ok {
+			return nil, errors.NewAnalysisError("undeclared "+t.String(), loc)
+		}
+		if !tag.Enum {
+			return nil, errors.NewAnalysisError(t.String()+" refers to a tag of a different kind", loc)
+		}
+		return types.Int{}, nil
+	case types.Structure:
+		tag, ok := a.structs[t.Tag]
+		if !ok {
+			return nil, errors.NewAnalysisError("undeclared "+t.String(), loc)
+		}
+		if tag.Enum || tag.Union != t.Union {
+			return nil, errors.NewAnalysisError(t.String()+" refers to a tag of a different kind", loc)
+		}
+		return types.Structure{Tag: tag.NewTag, Name: t.Name, Union: tag.Union, Def: tag.Def}, nil
+	case types.Pointer:
+		referenced, err := a.resolveType(t.Referenced, loc)
+		if err != nil {
+			return nil, err
+		}
+		return types.Pointer{Referenced: referenced}, nil
+	case types.Array:
+		element, err := a.resolveType(t.Element, loc)
+		if err != nil {
+			return nil, err
+		}
+		return types.Array{Element: element, Length: t.Length}, nil
+	case parser.ArrayType:
+		element, err := a.resolveType(t.Element, loc)
+		if err != nil {
+			return nil, err
+		}
+		err = a.resolveExpression(&t.Size)
+		if err != nil {
+			return nil, err
+		}
+		return parser.ArrayType{Element: element, Size: t.Size}, nil
+	case types.FunType:
+		params := make([]types.Type, len(t.Params))
+		for i, param := range t.Params {
+			var err error
+			params[i], err = a.resolveType(param, loc)
+			if err != nil {
+				return nil, err
+			}
+			// The parser adjusts array parameters to pointers, but can't see through typedefs like va_list
+			switch array := params[i].(type) {
+			case types.Array:
+				params[i] = types.Pointer{Referenced: array.Element}
+			case parser.ArrayType:
+				params[i] = types.Pointer{Referenced: array.Element}
+			}
+		}
+		ret, err := a.resolveType(t.Ret, loc)
+		if err != nil {
+			return nil, err
+		}
+		return types.FunType{Params: params, Ret: ret, Variadic: t.Variadic}, nil
+	default:
+		return t, nil
+	}
+}
+
+func (a *SemanticAnalyzer) resolveVarDecl(declaration *parser.VarDecl) error {
+	var err error
+	declaration.Type, err = a.resolveType(declaration.Type, declaration.Loc)
+	if err != nil {
+		return err
+	}
+
 	variable, ok := a.variables[declaration.Name.Value]
 	if ok && variable.FromCurrentBlock {
-		return errors.NewAnalysisError("duplicate variable declaration", declaration.Loc)
+		// Repeated extern declarations all refer to the same object
+		if !(variable.HasLinkage && declaration.StorageClass == parser.StorageClassExtern) {
+			return errors.NewAnalysisError("duplicate variable declaration", declaration.Loc)
+		}
+	}
+
+	if declaration.StorageClass == parser.StorageClassExtern {
+		a.variables[declaration.Name.Value] = Variable{NewName: declaration.Name.Value, FromCurrentBlock: true, HasLinkage: true}
+		return nil
 	}
 
 	a.variables[declaration.Name.Value] = Variable{NewName: a.makeTemporaryVar(declaration.Name.Value), FromCurrentBlock: true}
 	declaration.Name.Value = a.variables[declaration.Name.Value].NewName
 	if declaration.Init != nil {
-		err := a.resolveExpression(&declaration.Init)
+		err := a.resolveInitializer(declaration.Init)
 		if err != nil {
 			return err
 		}
@@ -55,11 +331,65 @@ func (a *SemanticAnalyzer) resolveDeclaration(declaration *parser.Declaration) e
 	return nil
 }
 
+func (a *SemanticAnalyzer) resolveInitializer(init parser.Initializer) error {
+	switch init := init.(type) {
+	case *parser.SingleInit:
+		return a.resolveExpression(&init.Expr)
+	case *parser.CompoundInit:
+		for _, item := range init.Inits {
+			err := a.resolveInitializer(item)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		panic("invalid initializer type")
+	}
+}
+
+func (a *SemanticAnalyzer) resolveFunctionDecl(function *parser.FunctionDecl) error {
+	name := function.Name.Value
+	if variable, ok := a.variables[name]; ok && variable.FromCurrentBlock && !variable.HasLinkage {
+		return errors.NewAnalysisError("duplicate declaration", function.Loc)
+	}
+	a.variables[name] = Variable{NewName: name, FromCurrentBlock: true, HasLinkage: true}
+
+	resolved, err := a.resolveType(function.Type, function.Loc)
+	if err != nil {
+		return err
+	}
+	function.Type = resolved.(types.FunType)
+
+	// Parameters and the function body share a single scope
+	oldVars, oldStructs := a.variables, a.structs
+	a.variables, a.structs = a.copyVars(), a.copyStructs()
+	defer func() { a.variables, a.structs = oldVars, oldStructs }()
+
+	for i := range function.Params {
+		param := &function.Params[i]
+		// Only a prototype can leave a parameter unnamed
+		if param.Value == "" {
+			continue
+		}
+		if variable, ok := a.variables[param.Value]; ok && variable.FromCurrentBlock {
+			return errors.NewAnalysisError("duplicate parameter name", param.Loc)
+		}
+		a.variables[param.Value] = Variable{NewName: a.makeTemporaryVar(param.Value), FromCurrentBlock: true}
+		param.Value = a.variables[param.Value].NewName
+	}
+
+	if function.Body != nil {
+		return a.resolveBlock(function.Body)
+	}
+	return nil
+}
+
 func (a *SemanticAnalyzer) resolveBlock(block *parser.Block) error {
 	for _, item := range block.Body {
 		switch item := item.(type) {
 		case *parser.DeclarationBlock:
-			err := a.resolveDeclaration(&item.Declaration)
+			err := a.resolveDeclaration(item.Declaration)
 			if err != nil {
 				return err
 			}
@@ -78,10 +408,17 @@ func (a *SemanticAnalyzer) resolveBlock(block *parser.Block) error {
 func (a *SemanticAnalyzer) resolveExpression(expression *parser.Expression) error {
 	switch item := (*expression).(type) {
 	case *parser.AssignmentExp:
-		_, ok := item.Left.(*parser.FactorExp).Factor.(*parser.IdentifierFactor)
-		if !ok {
-			return errors.NewAnalysisError("invalid lvalue", item.Loc)
+		err := a.resolveExpression(&item.Left)
+		if err != nil {
+			return err
+		}
+
+		err = a.resolveExpression(&item.Right)
+		if err != nil {
+			return err
 		}
+		return nil
+	case *parser.CompoundAssignmentExp:
 		err := a.resolveExpression(&item.Left)
 		if err != nil {
 			return err
@@ -130,7 +467,7 @@ func (a *SemanticAnalyzer) resolveExpression(expression *parser.Expression) erro
 func (a *SemanticAnalyzer) resolveStatement(statement *parser.Statement) error {
 	switch item := (*statement).(type) {
 	case *parser.ReturnStmt:
-		return a.resolveExpression(&item.Expression)
+		return a.resolveOptionalExpression(item.Expression)
 	case *parser.ExpressionStmt:
 		return a.resolveExpression(&item.Expression)
 	case *parser.NullStmt:
@@ -152,10 +489,10 @@ func (a *SemanticAnalyzer) resolveStatement(statement *parser.Statement) error {
 		}
 		return nil
 	case *parser.CompoundStmt:
-		oldVars := a.variables
-		a.variables = a.copyVars()
+		oldVars, oldStructs := a.variables, a.structs
+		a.variables, a.structs = a.copyVars(), a.copyStructs()
 		err := a.resolveBlock(&item.Block)
-		a.variables = oldVars
+		a.variables, a.structs = oldVars, oldStructs
 		return err
 	case *parser.WhileStmt:
 		err := a.resolveExpression(&item.Condition)
@@ -178,8 +515,8 @@ func (a *SemanticAnalyzer) resolveStatement(statement *parser.Statement) error {
 		}
 		return nil
 	case *parser.ForStmt:
-		oldVars := a.variables
-		a.variables = a.copyVars()
+		oldVars, oldStructs := a.variables, a.structs
+		a.variables, a.structs = a.copyVars(), a.copyStructs()
 
 		err := a.resolveForInit(item.Init)
 		if err != nil {
@@ -201,12 +538,30 @@ func (a *SemanticAnalyzer) resolveStatement(statement *parser.Statement) error {
 			return err
 		}
 
-		a.variables = oldVars
+		a.variables, a.structs = oldVars, oldStructs
 		return nil
 	case *parser.BreakStmt:
 		return nil
 	case *parser.ContinueStmt:
 		return nil
+	case *parser.LabeledStmt:
+		return a.resolveStatement(&item.Statement)
+	case *parser.GotoStmt:
+		return nil
+	case *parser.SwitchStmt:
+		err := a.resolveExpression(&item.Condition)
+		if err != nil {
+			return err
+		}
+		return a.resolveStatement(&item.Body)
+	case *parser.CaseStmt:
+		err := a.resolveExpression(&item.Expr)
+		if err != nil {
+			return err
+		}
+		return a.resolveStatement(&item.Statement)
+	case *parser.DefaultStmt:
+		return a.resolveStatement(&item.Statement)
 	default:
 		panic("invalid statement type")
 
@@ -221,7 +576,10 @@ func (a *SemanticAnalyzer) resolveForInit(forInit parser.ForInit) error {
 	case *parser.InitExp:
 		return a.resolveOptionalExpression(i.Expression)
 	case *parser.InitDecl:
-		return a.resolveDeclaration(&i.Declaration)
+		if i.Declaration.StorageClass != parser.StorageClassNone {
+			return errors.NewAnalysisError("storage class in for loop initializer", i.Declaration.Loc)
+		}
+		return a.resolveVarDecl(&i.Declaration)
 
 	default:
 		panic("invalid for init type")
@@ -238,19 +596,84 @@ func (a *SemanticAnalyzer) resolveOptionalExpression(exp parser.Expression) erro
 
 func (a *SemanticAnalyzer) resolveFactor(factor *parser.Factor) error {
 	switch item := (*factor).(type) {
-	case *parser.IntLiteral:
+	case *parser.Constant, *parser.StringLiteral:
 		return nil
+	case *parser.SizeOfTypeFactor:
+		var err error
+		item.TargetType, err = a.resolveType(item.TargetType, item.Loc)
+		return err
 	case *parser.UnaryFactor:
 		return a.resolveFactor(&item.Value)
+	case *parser.IncDecFactor:
+		return a.resolveFactor(&item.Expr)
 	case *parser.NestedExp:
 		return a.resolveExpression(&item.Expr)
 	case *parser.IdentifierFactor:
-		if variable, ok := a.variables[item.Value]; ok {
-			item.Value = variable.NewName
-		} else {
+		variable, ok := a.variables[item.Value]
+		if !ok {
 			return errors.NewAnalysisError("undeclared variable", item.Loc)
 		}
+		if variable.Typedef != nil {
+			return errors.NewAnalysisError("typedef name "+item.Value+" used as a variable", item.Loc)
+		}
+		item.Value = variable.NewName
+		return nil
+	case *parser.FunctionCall:
+		variable, ok := a.variables[item.Name.Value]
+		if !ok {
+			return errors.NewAnalysisError("undeclared function", item.Loc)
+		}
+		if variable.Typedef != nil {
+			return errors.NewAnalysisError("typedef name "+item.Name.Value+" used as a function", item.Loc)
+		}
+		if variable.Enumerator {
+			return errors.NewAnalysisError("enumerator "+item.Name.Value+" used as a function", item.Loc)
+		}
+		item.Name.Value = variable.NewName
+
+		for i := range item.Args {
+			err := a.resolveExpression(&item.Args[i])
+			if err != nil {
+				return err
+			}
+		}
 		return nil
+	case *parser.CastFactor:
+		var err error
+		item.TargetType, err = a.resolveType(item.TargetType, item.Loc)
+		if err != nil {
+			return err
+		}
+		return a.resolveExpression(&item.Expr)
+	case *parser.DereferenceFactor:
+		return a.resolveFactor(&item.Expr)
+	case *parser.AddressOfFactor:
+		return a.resolveFactor(&item.Expr)
+	case *parser.SubscriptFactor:
+		err := a.resolveFactor(&item.Expr)
+		if err != nil {
+			return err
+		}
+		return a.resolveExpression(&item.Index)
+	case *parser.SizeOfExpFactor:
+		return a.resolveFactor(&item.Expr)
+	case *parser.DotFactor:
+		return a.resolveFactor(&item.Expr)
+	case *parser.ArrowFactor:
+		return a.resolveFactor(&item.Expr)
+	case *parser.VaBuiltinFactor:
+		for i := range item.Args {
+			err := a.resolveExpression(&item.Args[i])
+			if err != nil {
+				return err
+			}
+		}
+		if item.ArgType == nil {
+			return nil
+		}
+		var err error
+		item.ArgType, err = a.resolveType(item.ArgType, item.Loc)
+		return err
 	default:
 		panic("invalid factor type")
 
@@ -0,0 +1,1272 @@
+package semanticanalysis
+
+import (
+	"acc/internal/common/errors"
+	"acc/internal/common/symbols"
+	"acc/internal/common/types"
+	"acc/internal/constexpr"
+	"acc/internal/parser"
+	"math"
+)
+
+// TypeCheck annotates every expression with its type, records every declaration in the
+// symbol table, and inserts explicit casts wherever C performs an implicit conversion
+func (a *SemanticAnalyzer) TypeCheck() error {
+	for _, declaration := range a.program.Declarations {
+		switch decl := declaration.(type) {
+		case *parser.FunctionDecl:
+			err := a.typecheckFunctionDecl(decl)
+			if err != nil {
+				return err
+			}
+		case *parser.VarDecl:
+			err := a.declareFileScopeVar(decl)
+			if err != nil {
+				return err
+			}
+		case *parser.StructDecl:
+			err := a.typecheckStructDecl(decl)
+			if err != nil {
+				return err
+			}
+		case *parser.StaticAssertDecl:
+			err := a.checkStaticAssert(decl)
+			if err != nil {
+				return err
+			}
+		case *parser.TypedefDecl:
+			err := a.typecheckTypedefDecl(decl)
+			if err != nil {
+				return err
+			}
+		case *parser.EnumDecl:
+			err := a.typecheckEnumDecl(decl)
+			if err != nil {
+				return err
+			}
+		default:
+			panic("invalid declaration type")
+		}
+	}
+	return nil
+}
+
+// checkStaticAssert evaluates the condition of a static assertion. That happens during type checking
+// rather than identifier resolution so that the sizes of structs defined before it are known.
+func (a *SemanticAnalyzer) checkStaticAssert(assertion *parser.StaticAssertDecl) error {
+	err := a.typecheckExpression(&assertion.Condition)
+	if err != nil {
+		return err
+	}
+	value, err := constexpr.EvaluateInteger(assertion.Condition)
+	if err != nil {
+		return err
+	}
+	if !types.IsZero(value) {
+		return nil
+	}
+	if assertion.Message == nil {
+		return errors.NewAnalysisError("static assertion failed", assertion.Loc)
+	}
+	return errors.NewAnalysisError("static assertion failed: \""+assertion.Message.Value+"\"", assertion.Loc)
+}
+
+// typecheckTypedefDecl evaluates the array sizes in the type a typedef stands for, and checks that a
+// repeated typedef names the same type as before
+func (a *SemanticAnalyzer) typecheckTypedefDecl(declaration *parser.TypedefDecl) error {
+	t, err := a.evaluateType(declaration.Type, declaration.Loc)
+	if err != nil {
+		return err
+	}
+	declaration.Type = t
+	if declaration.Previous == nil {
+		return nil
+	}
+	previous, err := a.evaluateType(declaration.Previous, declaration.Loc)
+	if err != nil {
+		return err
+	}
+	if !types.Equal(previous, t) {
+		return errors.NewAnalysisError("conflicting declaration of "+declaration.Name, declaration.Loc)
+	}
+	return nil
+}
+
+// typecheckEnumDecl gives each enumerator its value: either the constant it's set to or one more
+// than the enumerator before it, starting from zero
+func (a *SemanticAnalyzer) typecheckEnumDecl(declaration *parser.EnumDecl) error {
+	next := int64(0)
+	for i := range declaration.Enumerators {
+		enumerator := &declaration.Enumerators[i]
+		if enumerator.Value != nil {
+			err := a.typecheckExpression(&enumerator.Value)
+			if err != nil {
+				return err
+			}
+			value, err := constexpr.EvaluateInteger(enumerator.Value)
+			if err != nil {
+				return err
+			}
+			next = types.Int64(value)
+			if _, isULong := value.(types.ConstULong); isULong && next < 0 {
+				next = math.MaxInt64
+			}
+		}
+		if next < math.MinInt32 || next > math.MaxInt32 {
+			return errors.NewAnalysisError("value of enumerator "+enumerator.SourceName+" doesn't fit in an int", enumerator.Loc)
+		}
+		a.enumerators[enumerator.Name] = types.ConstInt{Value: int32(next)}
+		next++
+	}
+	return nil
+}
+
+func (a *SemanticAnalyzer) typecheckFunctionDecl(function *parser.FunctionDecl) error {
+	err := a.declareFunction(function)
+	if err != nil {
+		return err
+	}
+
+	if function.Body == nil {
+		return nil
+	}
+
+	symbol, _ := a.Symbols.Get(function.Name.Value)
+	funType := symbol.Type.(types.FunType)
+	if !isVoid(funType.Ret) && !types.IsComplete(funType.Ret) {
+		return errors.NewAnalysisError("function "+function.Name.Value+" returns incomplete type "+funType.Ret.String(), function.Loc)
+	}
+	for _, param := range funType.Params {
+		if !types.IsComplete(param) {
+			return errors.NewAnalysisError("parameter of "+function.Name.Value+" has incomplete type "+param.String(), function.Loc)
+		}
+	}
+	for i, param := range function.Params {
+		a.Symbols.Add(param.Value, &symbols.Symbol{Type: funType.Params[i], Attrs: symbols.LocalAttrs{}})
+	}
+
+	a.returnType = funType.Ret
+	a.variadic = funType.Variadic
+	return a.typecheckBlock(function.Body)
+}
+
+// typecheckStructDecl lays out a struct or union definition; a declaration without members doesn't change anything
+func (a *SemanticAnalyzer) typecheckStructDecl(declaration *parser.StructDecl) error {
+	if declaration.Members == nil {
+		return nil
+	}
+	structure := types.Structure{Tag: declaration.Tag, Name: declaration.Name, Union: declaration.Union, Def: declaration.Def}
+	if declaration.Def.Complete {
+		return errors.NewAnalysisError(structure.String()+" is defined more than once", declaration.Loc)
+	}
+
+	names := make([]string, len(declaration.Members))
+	memberTypes := make([]types.Type, len(declaration.Members))
+	for i := range declaration.Members {
+		member := &declaration.Members[i]
+		for _, name := range names[:i] {
+			if name == member.Name {
+				return errors.NewAnalysisError("duplicate member "+member.Name, member.Loc)
+			}
+		}
+		var err error
+		member.Type, err = a.evaluateType(member.Type, member.Loc)
+		if err != nil {
+			return err
+		}
+		err = validateType(member.Type, member.Loc)
+		if err != nil {
+			return err
+		}
+		if !types.IsComplete(member.Type) {
+			return errors.NewAnalysisError("member "+member.Name+" has incomplete type "+member.Type.String(), member.Loc)
+		}
+		names[i] = member.Name
+		memberTypes[i] = member.Type
+	}
+	if declaration.Union {
+		declaration.Def.DefineUnion(names, memberTypes)
+	} else {
+		declaration.Def.Define(names, memberTypes)
+	}
+	return nil
+}
+
+// initializedMembers returns the members a brace-enclosed initializer can give values to, in order;
+// only the first member of a union can be initialized
+func initializedMembers(structure types.Structure) []types.Member {
+	if structure.Union {
+		return structure.Def.Members[:1]
+	}
+	return structure.Def.Members
+}
+
+// evaluateType replaces every array type in t whose size is still an expression with a types.Array
+func (a *SemanticAnalyzer) evaluateType(t types.Type, loc errors.Location) (types.Type, error) {
+	switch t := t.(type) {
+	case parser.ArrayType:
+		element, err := a.evaluateType(t.Element, loc)
+		if err != nil {
+			return nil, err
+		}
+		length, err := a.arraySize(t.Size, loc)
+		if err != nil {
+			return nil, err
+		}
+		return types.Array{Element: element, Length: length}, nil
+	case types.Array:
+		element, err := a.evaluateType(t.Element, loc)
+		if err != nil {
+			return nil, err
+		}
+		return types.Array{Element: element, Length: t.Length}, nil
+	case types.Pointer:
+		referenced, err := a.evaluateType(t.Referenced, loc)
+		if err != nil {
+			return nil, err
+		}
+		return types.Pointer{Referenced: referenced}, nil
+	case types.FunType:
+		params := make([]types.Type, len(t.Params))
+		for i, param := range t.Params {
+			var err error
+			params[i], err = a.evaluateType(param, loc)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ret, err := a.evaluateType(t.Ret, loc)
+		if err != nil {
+			return nil, err
+		}
+		return types.FunType{Params: params, Ret: ret, Variadic: t.Variadic}, nil
+	default:
+		return t, nil
+	}
+}
+
+// arraySize evaluates the size of an array, which must be a positive integer constant
+func (a *SemanticAnalyzer) arraySize(size parser.Expression, loc errors.Location) (int, error) {
+	err := a.typecheckExpression(&size)
+	if err != nil {
+		return 0, err
+	}
+	value, err := constexpr.EvaluateInteger(size)
+	if err != nil {
+		return 0, err
+	}
+
+	length := types.Int64(value)
+	if _, isULong := value.(types.ConstULong); isULong && length < 0 {
+		return 0, errors.NewAnalysisError("array size "+value.String()+" is too large", loc)
+	}
+	if length <= 0 {
+		return 0, errors.NewAnalysisError("array size must be positive", loc)
+	}
+	return int(length), nil
+}
+
+// validateType rejects arrays of incomplete types anywhere within t
+func validateType(t types.Type, loc errors.Location) error {
+	switch t := t.(type) {
+	case types.Array:
+		if !types.IsComplete(t.Element) {
+			return errors.NewAnalysisError("array of incomplete type "+t.Element.String(), loc)
+		}
+		return validateType(t.Element, loc)
+	case types.Pointer:
+		return validateType(t.Referenced, loc)
+	case types.FunType:
+		for _, param := range t.Params {
+			err := validateType(param, loc)
+			if err != nil {
+				return err
+			}
+		}
+		return validateType(t.Ret, loc)
+	default:
+		return nil
+	}
+}
+
+func (a *SemanticAnalyzer) typecheckBlock(block *parser.Block) error {
+	for _, item := range block.Body {
+		switch item := item.(type) {
+		case *parser.DeclarationBlock:
+			switch decl := item.Declaration.(type) {
+			case *parser.VarDecl:
+				err := a.typecheckLocalVarDecl(decl)
+				if err != nil {
+					return err
+				}
+			case *parser.FunctionDecl:
+				err := a.typecheckFunctionDecl(decl)
+				if err != nil {
+					return err
+				}
+			case *parser.StructDecl:
+				err := a.typecheckStructDecl(decl)
+				if err != nil {
+					return err
+				}
+			case *parser.StaticAssertDecl:
+				err := a.checkStaticAssert(decl)
+				if err != nil {
+					return err
+				}
+			case *parser.TypedefDecl:
+				err := a.typecheckTypedefDecl(decl)
+				if err != nil {
+					return err
+				}
+			case *parser.EnumDecl:
+				err := a.typecheckEnumDecl(decl)
+				if err != nil {
+					return err
+				}
+			default:
+				panic("invalid declaration type")
+			}
+		case *parser.StmtBlock:
+			err := a.typecheckStatement(item.Statement)
+			if err != nil {
+				return err
+			}
+		default:
+			panic("invalid block item type")
+		}
+	}
+	return nil
+}
+
+func (a *SemanticAnalyzer) typecheckLocalVarDecl(declaration *parser.VarDecl) error {
+	err := a.declareLocalVar(declaration)
+	if err != nil {
+		return err
+	}
+
+	// Static and extern initializers were already checked when the variable was declared
+	if declaration.StorageClass != parser.StorageClassNone || declaration.Init == nil {
+		return nil
+	}
+
+	return a.typecheckInitializer(&declaration.Init, declaration.Type, declaration.Loc)
+}
+
+// typecheckInitializer converts every expression in init to the type of the object it initializes,
+// padding compound initializers with zeros out to the full length of the array or struct
+func (a *SemanticAnalyzer) typecheckInitializer(init *parser.Initializer, t types.Type, loc errors.Location) error {
+	switch item := (*init).(type) {
+	case *parser.SingleInit:
+		if array, isArray := t.(types.Array); isArray {
+			str, isString := stringLiteral(item.Expr)
+			if !isString {
+				return errors.NewAnalysisError("array must be initialized with a brace-enclosed list", loc)
+			}
+			if !types.IsCharacter(array.Element) {
+				return errors.NewAnalysisError("string literal can only initialize a character array", loc)
+			}
+			if len(str.Value) > array.Length {
+				return errors.NewAnalysisError("string literal is too long for the array it initializes", loc)
+			}
+			// The array is copied from the literal rather than decaying to a pointer
+			str.SetType(t)
+			item.Expr.SetType(t)
+			item.SetType(t)
+			return nil
+		}
+		err := a.typecheckExpression(&item.Expr)
+		if err != nil {
+			return err
+		}
+		item.Expr, err = convertByAssignment(item.Expr, t, loc)
+		if err != nil {
+			return err
+		}
+		item.SetType(t)
+		return nil
+	case *parser.CompoundInit:
+		if structure, isStruct := t.(types.Structure); isStruct {
+			members := initializedMembers(structure)
+			if len(item.Inits) > len(members) {
+				return errors.NewAnalysisError("too many elements in initializer", loc)
+			}
+			for i := range item.Inits {
+				err := a.typecheckInitializer(&item.Inits[i], members[i].Type, loc)
+				if err != nil {
+					return err
+				}
+			}
+			for _, member := range members[len(item.Inits):] {
+				item.Inits = append(item.Inits, zeroInitializer(member.Type))
+			}
+			item.SetType(t)
+			return nil
+		}
+
+		array, isArray := t.(types.Array)
+		if !isArray {
+			return errors.NewAnalysisError("brace-enclosed initializer for a scalar", loc)
+		}
+		if len(item.Inits) > array.Length {
+			return errors.NewAnalysisError("too many elements in initializer", loc)
+		}
+
+		for i := range item.Inits {
+			err := a.typecheckInitializer(&item.Inits[i], array.Element, loc)
+			if err != nil {
+				return err
+			}
+		}
+		for len(item.Inits) < array.Length {
+			item.Inits = append(item.Inits, zeroInitializer(array.Element))
+		}
+		item.SetType(t)
+		return nil
+	default:
+		panic("invalid initializer type")
+	}
+}
+
+// zeroInitializer builds an already type-checked initializer that sets an object of type t to zero
+func zeroInitializer(t types.Type) parser.Initializer {
+	switch t := t.(type) {
+	case types.Array:
+		inits := make([]parser.Initializer, t.Length)
+		for i := range inits {
+			inits[i] = zeroInitializer(t.Element)
+		}
+		init := &parser.CompoundInit{Inits: inits}
+		init.SetType(t)
+		return init
+	case types.Structure:
+		members := initializedMembers(t)
+		inits := make([]parser.Initializer, len(members))
+		for i, member := range members {
+			inits[i] = zeroInitializer(member.Type)
+		}
+		init := &parser.CompoundInit{Inits: inits}
+		init.SetType(t)
+		return init
+	}
+
+	zero := &parser.Constant{Value: types.ConvertConst(types.ConstInt{Value: 0}, t)}
+	zero.SetType(zero.Value.Type())
+	exp := &parser.FactorExp{Factor: zero}
+	exp.SetType(zero.Value.Type())
+	init := &parser.SingleInit{Expr: exp}
+	init.SetType(t)
+	return init
+}
+
+func (a *SemanticAnalyzer) typecheckStatement(statement parser.Statement) error {
+	switch item := statement.(type) {
+	case *parser.ReturnStmt:
+		if _, returnsVoid := a.returnType.(types.Void); returnsVoid {
+			if item.Expression != nil {
+				return errors.NewAnalysisError("return with a value in a void function", item.Loc)
+			}
+			return nil
+		}
+		if item.Expression == nil {
+			return errors.NewAnalysisError("return without a value in a non-void function", item.Loc)
+		}
+
+		err := a.typecheckExpression(&item.Expression)
+		if err != nil {
+			return err
+		}
+		item.Expression, err = convertByAssignment(item.Expression, a.returnType, item.Loc)
+		if err != nil {
+			return err
+		}
+		return nil
+	case *parser.ExpressionStmt:
+		return a.typecheckExpression(&item.Expression)
+	case *parser.IfStmt:
+		err := a.typecheckCondition(&item.Condition, item.Loc)
+		if err != nil {
+			return err
+		}
+		err = a.typecheckStatement(item.Then)
+		if err != nil {
+			return err
+		}
+		if item.Else != nil {
+			return a.typecheckStatement(item.Else)
+		}
+		return nil
+	case *parser.CompoundStmt:
+		return a.typecheckBlock(&item.Block)
+	case *parser.WhileStmt:
+		err := a.typecheckCondition(&item.Condition, item.Loc)
+		if err != nil {
+			return err
+		}
+		return a.typecheckStatement(item.Body)
+	case *parser.DoWhileStmt:
+		err := a.typecheckStatement(item.Body)
+		if err != nil {
+			return err
+		}
+		return a.typecheckCondition(&item.Condition, item.Loc)
+	case *parser.ForStmt:
+		switch init := item.Init.(type) {
+		case *parser.InitDecl:
+			err := a.typecheckLocalVarDecl(&init.Declaration)
+			if err != nil {
+				return err
+			}
+		case *parser.InitExp:
+			if init.Expression != nil {
+				err := a.typecheckExpression(&init.Expression)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if item.Condition != nil {
+			err := a.typecheckCondition(&item.Condition, item.Loc)
+			if err != nil {
+				return err
+			}
+		}
+		if item.Post != nil {
+			err := a.typecheckExpression(&item.Post)
+			if err != nil {
+				return err
+			}
+		}
+		return a.typecheckStatement(item.Body)
+	case *parser.LabeledStmt:
+		return a.typecheckStatement(item.Statement)
+	case *parser.SwitchStmt:
+		err := a.typecheckExpression(&item.Condition)
+		if err != nil {
+			return err
+		}
+		if !types.IsInteger(item.Condition.GetType()) {
+			return errors.NewAnalysisError("switch condition must have integer type", item.Loc)
+		}
+		item.Condition = convertTo(item.Condition, types.Promote(item.Condition.GetType()))
+		return a.typecheckStatement(item.Body)
+	case *parser.CaseStmt:
+		// The value is checked and converted to the type of the switch once the case is matched up with its switch
+		err := a.typecheckExpression(&item.Expr)
+		if err != nil {
+			return err
+		}
+		return a.typecheckStatement(item.Statement)
+	case *parser.DefaultStmt:
+		return a.typecheckStatement(item.Statement)
+	case *parser.NullStmt, *parser.BreakStmt, *parser.ContinueStmt, *parser.GotoStmt:
+		return nil
+	default:
+		panic("invalid statement type")
+	}
+}
+
+// typecheckCondition checks an expression that is only tested against zero, which needs a scalar type
+func (a *SemanticAnalyzer) typecheckCondition(condition *parser.Expression, loc errors.Location) error {
+	err := a.typecheckExpression(condition)
+	if err != nil {
+		return err
+	}
+	if !types.IsScalar((*condition).GetType()) {
+		return errors.NewAnalysisError("condition must have scalar type", loc)
+	}
+	return nil
+}
+
+func (a *SemanticAnalyzer) typecheckExpression(expression *parser.Expression) error {
+	switch item := (*expression).(type) {
+	case *parser.AssignmentExp:
+		err := a.typecheckExpression(&item.Left)
+		if err != nil {
+			return err
+		}
+		err = a.typecheckExpression(&item.Right)
+		if err != nil {
+			return err
+		}
+
+		err = checkAssignable(isLvalue(item.Left), item.Left.GetType(), item.Loc)
+		if err != nil {
+			return err
+		}
+		item.Right, err = convertByAssignment(item.Right, item.Left.GetType(), item.Loc)
+		if err != nil {
+			return err
+		}
+		item.SetType(item.Left.GetType())
+		return nil
+	case *parser.CompoundAssignmentExp:
+		err := a.typecheckExpression(&item.Left)
+		if err != nil {
+			return err
+		}
+		err = a.typecheckExpression(&item.Right)
+		if err != nil {
+			return err
+		}
+
+		leftType, rightType := item.Left.GetType(), item.Right.GetType()
+		err = checkAssignable(isLvalue(item.Left), leftType, item.Loc)
+		if err != nil {
+			return err
+		}
+
+		// The operation is done in ResultType, and only the right operand is converted here; the left
+		// operand is converted when the value is read, and the result converted back when it's stored
+		switch {
+		case isPointer(leftType):
+			if (item.Op != parser.BinopAdd && item.Op != parser.BinopSubtract) || !isPointerToComplete(leftType) || !types.IsInteger(rightType) {
+				return errors.NewAnalysisError("invalid operands to compound assignment on pointer type", item.Loc)
+			}
+			item.Right = convertTo(item.Right, types.Long{})
+			item.ResultType = leftType
+		case !types.IsArithmetic(leftType) || !types.IsArithmetic(rightType):
+			return errors.NewAnalysisError("invalid operands to compound assignment", item.Loc)
+		case item.Op == parser.BinopShiftLeft || item.Op == parser.BinopShiftRight:
+			if !types.IsInteger(leftType) || !types.IsInteger(rightType) {
+				return errors.NewAnalysisError("operands of shift must have integer type", item.Loc)
+			}
+			item.Right = convertTo(item.Right, types.Promote(rightType))
+			item.ResultType = types.Promote(leftType)
+		default:
+			common := types.CommonType(leftType, rightType)
+			if _, isDouble := common.(types.Double); isDouble && item.Op == parser.BinopRemainder {
+				return errors.NewAnalysisError("can't take the remainder of a double", item.Loc)
+			}
+			if _, isDouble := common.(types.Double); isDouble && isBitwise(item.Op) {
+				return errors.NewAnalysisError("operands of bitwise operator must have integer type", item.Loc)
+			}
+			item.Right = convertTo(item.Right, common)
+			item.ResultType = common
+		}
+		item.SetType(leftType)
+		return nil
+	case *parser.BinaryExp:
+		err := a.typecheckExpression(&item.Left)
+		if err != nil {
+			return err
+		}
+		err = a.typecheckExpression(&item.Right)
+		if err != nil {
+			return err
+		}
+
+		// Logical operators don't convert their operands, they only test them against zero
+		if item.Op == parser.BinopAnd || item.Op == parser.BinopOr {
+			if !types.IsScalar(item.Left.GetType()) || !types.IsScalar(item.Right.GetType()) {
+				return errors.NewAnalysisError("operands of logical operator must have scalar type", item.Loc)
+			}
+			item.SetType(types.Int{})
+			return nil
+		}
+
+		// Pointers can only be compared for equality, with each other or with a null pointer constant
+		if item.Op == parser.BinopEqual || item.Op == parser.BinopNotEqual {
+			var common types.Type
+			if isPointer(item.Left.GetType()) || isPointer(item.Right.GetType()) {
+				common, err = commonPointerType(item.Left, item.Right, item.Loc)
+				if err != nil {
+					return err
+				}
+			} else if types.IsArithmetic(item.Left.GetType()) && types.IsArithmetic(item.Right.GetType()) {
+				common = types.CommonType(item.Left.GetType(), item.Right.GetType())
+			} else {
+				return errors.NewAnalysisError("invalid operands to equality operator", item.Loc)
+			}
+			item.Left = convertTo(item.Left, common)
+			item.Right = convertTo(item.Right, common)
+			item.SetType(types.Int{})
+			return nil
+		}
+
+		// Each operand of a shift is promoted on its own, and the result has the left operand's type
+		if item.Op == parser.BinopShiftLeft || item.Op == parser.BinopShiftRight {
+			if !types.IsInteger(item.Left.GetType()) || !types.IsInteger(item.Right.GetType()) {
+				return errors.NewAnalysisError("operands of shift must have integer type", item.Loc)
+			}
+			item.Left = convertTo(item.Left, types.Promote(item.Left.GetType()))
+			item.Right = convertTo(item.Right, types.Promote(item.Right.GetType()))
+			item.SetType(item.Left.GetType())
+			return nil
+		}
+
+		if isPointer(item.Left.GetType()) || isPointer(item.Right.GetType()) {
+			return typecheckPointerArithmetic(item)
+		}
+		if !types.IsArithmetic(item.Left.GetType()) || !types.IsArithmetic(item.Right.GetType()) {
+			return errors.NewAnalysisError("invalid operands to binary operator", item.Loc)
+		}
+
+		common := types.CommonType(item.Left.GetType(), item.Right.GetType())
+		if _, isDouble := common.(types.Double); isDouble && item.Op == parser.BinopRemainder {
+			return errors.NewAnalysisError("can't take the remainder of a double", item.Loc)
+		}
+		if _, isDouble := common.(types.Double); isDouble && isBitwise(item.Op) {
+			return errors.NewAnalysisError("operands of bitwise operator must have integer type", item.Loc)
+		}
+		item.Left = convertTo(item.Left, common)
+		item.Right = convertTo(item.Right, common)
+
+		if isRelational(item.Op) {
+			item.SetType(types.Int{})
+		} else {
+			item.SetType(common)
+		}
+		return nil
+	case *parser.FactorExp:
+		err := a.typecheckFactor(&item.Factor)
+		if err != nil {
+			return err
+		}
+		// An incomplete struct can only be used through a pointer, never as a value
+		if t := item.Factor.GetType(); isStruct(t) && !types.IsComplete(t) {
+			return errors.NewAnalysisError("use of incomplete type "+t.String(), item.Loc)
+		}
+		item.SetType(item.Factor.GetType())
+		return nil
+	case *parser.ConditionalExp:
+		err := a.typecheckCondition(&item.Condition, item.Loc)
+		if err != nil {
+			return err
+		}
+		err = a.typecheckExpression(&item.Expression1)
+		if err != nil {
+			return err
+		}
+		err = a.typecheckExpression(&item.Expression2)
+		if err != nil {
+			return err
+		}
+
+		var common types.Type
+		t1, t2 := item.Expression1.GetType(), item.Expression2.GetType()
+		switch {
+		case isVoid(t1) && isVoid(t2):
+			common = types.Void{}
+		case isStruct(t1) && types.Equal(t1, t2):
+			common = t1
+		case isPointer(t1) || isPointer(t2):
+			common, err = commonPointerType(item.Expression1, item.Expression2, item.Loc)
+			if err != nil {
+				return err
+			}
+		case types.IsArithmetic(t1) && types.IsArithmetic(t2):
+			common = types.CommonType(t1, t2)
+		default:
+			return errors.NewAnalysisError("incompatible types "+t1.String()+" and "+t2.String()+" in conditional expression", item.Loc)
+		}
+		item.Expression1 = convertTo(item.Expression1, common)
+		item.Expression2 = convertTo(item.Expression2, common)
+		item.SetType(common)
+		return nil
+	default:
+		panic("invalid expression type")
+	}
+}
+
+// typecheckFactor annotates factor with its type; an array is converted to a pointer to its first element
+func (a *SemanticAnalyzer) typecheckFactor(factor *parser.Factor) error {
+	err := a.typecheckFactorNoDecay(factor)
+	if err != nil {
+		return err
+	}
+
+	if array, isArray := (*factor).GetType().(types.Array); isArray {
+		decayed := &parser.AddressOfFactor{Expr: *factor}
+		decayed.SetType(types.Pointer{Referenced: array.Element})
+		*factor = decayed
+	}
+	return nil
+}
+
+// typecheckFactorNoDecay annotates factor with its type, leaving arrays as they are for operators like & that need the array itself
+func (a *SemanticAnalyzer) typecheckFactorNoDecay(factor *parser.Factor) error {
+	switch item := (*factor).(type) {
+	case *parser.Constant:
+		item.SetType(item.Value.Type())
+		return nil
+	case *parser.StringLiteral:
+		item.SetType(types.Array{Element: types.Char{}, Length: len(item.Value) + 1})
+		return nil
+	case *parser.IdentifierFactor:
+		// An enumerator is a constant, so it's replaced with its value wherever it appears
+		if value, ok := a.enumerators[item.Value]; ok {
+			constant := &parser.Constant{Loc: item.Loc, Value: value}
+			constant.SetType(value.Type())
+			*factor = constant
+			return nil
+		}
+		symbol, _ := a.Symbols.Get(item.Value)
+		if _, isFunction := symbol.Type.(types.FunType); isFunction {
+			return errors.NewAnalysisError("function name used as variable", item.Loc)
+		}
+		item.SetType(symbol.Type)
+		return nil
+	case *parser.FunctionCall:
+		symbol, _ := a.Symbols.Get(item.Name.Value)
+		funType, isFunction := symbol.Type.(types.FunType)
+		if !isFunction {
+			return errors.NewAnalysisError("variable used as function", item.Loc)
+		}
+		if len(item.Args) < len(funType.Params) || (len(item.Args) > len(funType.Params) && !funType.Variadic) {
+			return errors.NewAnalysisError("function called with the wrong number of arguments", item.Loc)
+		}
+		if !isVoid(funType.Ret) && !types.IsComplete(funType.Ret) {
+			return errors.NewAnalysisError("call to "+item.Name.Value+" returns incomplete type "+funType.Ret.String(), item.Loc)
+		}
+
+		for i := range item.Args {
+			err := a.typecheckExpression(&item.Args[i])
+			if err != nil {
+				return err
+			}
+			if i >= len(funType.Params) {
+				// Arguments matching the ellipsis get the default argument promotions instead of a
+				// conversion to a parameter type; there's no float, so that just promotes characters
+				argType := item.Args[i].GetType()
+				if !types.IsComplete(argType) {
+					return errors.NewAnalysisError("argument of incomplete type "+argType.String()+" passed to "+item.Name.Value, item.Loc)
+				}
+				item.Args[i] = convertTo(item.Args[i], types.Promote(argType))
+				continue
+			}
+			item.Args[i], err = convertByAssignment(item.Args[i], funType.Params[i], item.Loc)
+			if err != nil {
+				return err
+			}
+		}
+		item.SetType(funType.Ret)
+		return nil
+	case *parser.UnaryFactor:
+		err := a.typecheckFactor(&item.Value)
+		if err != nil {
+			return err
+		}
+
+		valueType := item.Value.GetType()
+		switch {
+		case item.Op == parser.UnopNot && !types.IsScalar(valueType):
+			return errors.NewAnalysisError("operand of ! must have scalar type", item.Loc)
+		case item.Op == parser.UnopNegate && !types.IsArithmetic(valueType):
+			return errors.NewAnalysisError("operand of - must have arithmetic type", item.Loc)
+		case item.Op == parser.UnopBitwiseComp && !types.IsInteger(valueType):
+			return errors.NewAnalysisError("operand of ~ must have integer type", item.Loc)
+		}
+
+		// Character operands are promoted to int
+		if item.Op != parser.UnopNot && types.IsCharacter(item.Value.GetType()) {
+			item.Value = convertFactorTo(item.Value, types.Int{})
+		}
+
+		if item.Op == parser.UnopNot {
+			item.SetType(types.Int{})
+		} else {
+			item.SetType(item.Value.GetType())
+		}
+		return nil
+	case *parser.IncDecFactor:
+		err := a.typecheckFactor(&item.Expr)
+		if err != nil {
+			return err
+		}
+
+		valueType := item.Expr.GetType()
+		err = checkAssignable(isLvalueFactor(item.Expr), valueType, item.Loc)
+		if err != nil {
+			return err
+		}
+		if !types.IsArithmetic(valueType) && !isPointerToComplete(valueType) {
+			return errors.NewAnalysisError("operand of ++ or -- must have arithmetic or pointer type", item.Loc)
+		}
+		item.SetType(valueType)
+		return nil
+	case *parser.NestedExp:
+		// Parentheses don't make an array decay, so a nested factor is checked the same way as this one
+		if inner, isFactor := item.Expr.(*parser.FactorExp); isFactor {
+			err := a.typecheckFactorNoDecay(&inner.Factor)
+			if err != nil {
+				return err
+			}
+			inner.SetType(inner.Factor.GetType())
+		} else {
+			err := a.typecheckExpression(&item.Expr)
+			if err != nil {
+				return err
+			}
+		}
+		item.SetType(item.Expr.GetType())
+		return nil
+	case *parser.CastFactor:
+		var err error
+		item.TargetType, err = a.evaluateType(item.TargetType, item.Loc)
+		if err != nil {
+			return err
+		}
+		err = validateType(item.TargetType, item.Loc)
+		if err != nil {
+			return err
+		}
+		err = a.typecheckExpression(&item.Expr)
+		if err != nil {
+			return err
+		}
+		// Anything can be cast to void to discard it
+		if isVoid(item.TargetType) {
+			item.SetType(item.TargetType)
+			return nil
+		}
+		if !types.IsScalar(item.TargetType) {
+			return errors.NewAnalysisError("can't cast to "+item.TargetType.String(), item.Loc)
+		}
+		if !types.IsScalar(item.Expr.GetType()) {
+			return errors.NewAnalysisError("can't cast "+item.Expr.GetType().String()+" to "+item.TargetType.String(), item.Loc)
+		}
+		_, fromDouble := item.Expr.GetType().(types.Double)
+		_, toDouble := item.TargetType.(types.Double)
+		if (fromDouble && isPointer(item.TargetType)) || (toDouble && isPointer(item.Expr.GetType())) {
+			return errors.NewAnalysisError("can't cast between a pointer and a double", item.Loc)
+		}
+		item.SetType(item.TargetType)
+		return nil
+	case *parser.DereferenceFactor:
+		err := a.typecheckFactor(&item.Expr)
+		if err != nil {
+			return err
+		}
+		pointer, ok := item.Expr.GetType().(types.Pointer)
+		if !ok {
+			return errors.NewAnalysisError("dereference of non-pointer", item.Loc)
+		}
+		if isVoid(pointer.Referenced) {
+			return errors.NewAnalysisError("dereference of pointer to void", item.Loc)
+		}
+		item.SetType(pointer.Referenced)
+		return nil
+	case *parser.AddressOfFactor:
+		err := a.typecheckFactorNoDecay(&item.Expr)
+		if err != nil {
+			return err
+		}
+		if !isLvalueFactor(item.Expr) {
+			return errors.NewAnalysisError("can't take the address of a non-lvalue", item.Loc)
+		}
+		item.SetType(types.Pointer{Referenced: item.Expr.GetType()})
+		return nil
+	case *parser.SubscriptFactor:
+		err := a.typecheckFactor(&item.Expr)
+		if err != nil {
+			return err
+		}
+		err = a.typecheckExpression(&item.Index)
+		if err != nil {
+			return err
+		}
+
+		// Normalize i[p] to p[i] so the pointer is always on the left
+		if isPointer(item.Index.GetType()) && types.IsInteger(item.Expr.GetType()) {
+			pointer := &parser.NestedExp{Loc: item.Loc, Expr: item.Index}
+			pointer.SetType(item.Index.GetType())
+			index := &parser.FactorExp{Loc: item.Loc, Factor: item.Expr}
+			index.SetType(item.Expr.GetType())
+			item.Expr, item.Index = pointer, index
+		}
+
+		pointer, ok := item.Expr.GetType().(types.Pointer)
+		if !ok || !types.IsInteger(item.Index.GetType()) {
+			return errors.NewAnalysisError("subscript requires a pointer and an integer", item.Loc)
+		}
+		if !types.IsComplete(pointer.Referenced) {
+			return errors.NewAnalysisError("subscript of pointer to incomplete type", item.Loc)
+		}
+		item.Index = convertTo(item.Index, types.Long{})
+		item.SetType(pointer.Referenced)
+		return nil
+	case *parser.DotFactor:
+		err := a.typecheckFactor(&item.Expr)
+		if err != nil {
+			return err
+		}
+		structure, ok := item.Expr.GetType().(types.Structure)
+		if !ok {
+			return errors.NewAnalysisError("member access on non-struct type "+item.Expr.GetType().String(), item.Loc)
+		}
+		return typecheckMember(item, structure, item.Member, item.Loc)
+	case *parser.ArrowFactor:
+		err := a.typecheckFactor(&item.Expr)
+		if err != nil {
+			return err
+		}
+		pointer, ok := item.Expr.GetType().(types.Pointer)
+		if !ok {
+			return errors.NewAnalysisError("-> applied to non-pointer type "+item.Expr.GetType().String(), item.Loc)
+		}
+		structure, ok := pointer.Referenced.(types.Structure)
+		if !ok {
+			return errors.NewAnalysisError("-> applied to pointer to non-struct type "+pointer.Referenced.String(), item.Loc)
+		}
+		return typecheckMember(item, structure, item.Member, item.Loc)
+	case *parser.SizeOfTypeFactor:
+		var err error
+		item.TargetType, err = a.evaluateType(item.TargetType, item.Loc)
+		if err != nil {
+			return err
+		}
+		err = validateType(item.TargetType, item.Loc)
+		if err != nil {
+			return err
+		}
+		if !types.IsComplete(item.TargetType) {
+			return errors.NewAnalysisError("can't take the size of an incomplete type", item.Loc)
+		}
+		item.SetType(types.ULong{})
+		return nil
+	case *parser.SizeOfExpFactor:
+		// The operand of sizeof doesn't decay, so sizeof an array is the size of the whole array
+		err := a.typecheckFactorNoDecay(&item.Expr)
+		if err != nil {
+			return err
+		}
+		if !types.IsComplete(item.Expr.GetType()) {
+			return errors.NewAnalysisError("can't take the size of an incomplete type", item.Loc)
+		}
+		item.SetType(types.ULong{})
+		return nil
+	case *parser.VaBuiltinFactor:
+		return a.typecheckVaBuiltin(item)
+	default:
+		panic("invalid factor type")
+	}
+}
+
+// typecheckVaBuiltin checks a call to one of the va_list builtins. A va_list argument decays to a
+// pointer to the structure it's an array of, which is also what a va_list parameter is adjusted to.
+func (a *SemanticAnalyzer) typecheckVaBuiltin(builtin *parser.VaBuiltinFactor) error {
+	for i := range builtin.Args {
+		err := a.typecheckExpression(&builtin.Args[i])
+		if err != nil {
+			return err
+		}
+	}
+	// The second argument of va_start names the last named parameter, which isn't needed to find the rest
+	lists := builtin.Args
+	if builtin.Builtin == parser.VaStart {
+		lists = lists[:1]
+	}
+	for _, list := range lists {
+		if !types.Equal(list.GetType(), types.Pointer{Referenced: types.VaListTag()}) {
+			return errors.NewAnalysisError("argument of type "+list.GetType().String()+" is not a va_list", builtin.Loc)
+		}
+	}
+
+	switch builtin.Builtin {
+	case parser.VaStart:
+		if !a.variadic {
+			return errors.NewAnalysisError("va_start used in function with fixed arguments", builtin.Loc)
+		}
+	case parser.VaArg:
+		t, err := a.evaluateType(builtin.ArgType, builtin.Loc)
+		if err != nil {
+			return err
+		}
+		builtin.ArgType = t
+		err = validateType(t, builtin.Loc)
+		if err != nil {
+			return err
+		}
+		if !types.IsArithmetic(t) && !isPointer(t) {
+			return errors.NewAnalysisError("va_arg of type "+t.String()+" isn't supported", builtin.Loc)
+		}
+		builtin.SetType(t)
+		return nil
+	}
+	builtin.SetType(types.Void{})
+	return nil
+}
+
+// typecheckMember gives a member access the type of the member it names
+func typecheckMember(factor parser.Factor, structure types.Structure, name string, loc errors.Location) error {
+	if !types.IsComplete(structure) {
+		return errors.NewAnalysisError("member access on incomplete type "+structure.String(), loc)
+	}
+	member, ok := structure.Def.Member(name)
+	if !ok {
+		return errors.NewAnalysisError(structure.String()+" has no member named "+name, loc)
+	}
+	factor.SetType(member.Type)
+	return nil
+}
+
+// isLvalue reports whether exp designates an object that can be assigned to
+// checkAssignable checks that the target of an assignment, compound assignment, ++ or -- is an
+// lvalue of complete type
+func checkAssignable(lvalue bool, t types.Type, loc errors.Location) error {
+	if !lvalue {
+		return errors.NewAnalysisError("invalid lvalue", loc)
+	}
+	if !types.IsComplete(t) {
+		return errors.NewAnalysisError("can't assign to an object of incomplete type", loc)
+	}
+	return nil
+}
+
+func isLvalue(exp parser.Expression) bool {
+	factor, ok := exp.(*parser.FactorExp)
+	if !ok {
+		return false
+	}
+	return isLvalueFactor(factor.Factor)
+}
+
+func isLvalueFactor(factor parser.Factor) bool {
+	switch item := factor.(type) {
+	case *parser.IdentifierFactor, *parser.DereferenceFactor, *parser.SubscriptFactor, *parser.StringLiteral, *parser.ArrowFactor:
+		return true
+	case *parser.NestedExp:
+		return isLvalue(item.Expr)
+	case *parser.DotFactor:
+		// A member of a struct that isn't an lvalue, like one returned by a function, isn't one either
+		return isLvalueFactor(item.Expr)
+	default:
+		return false
+	}
+}
+
+func isPointer(t types.Type) bool {
+	_, ok := t.(types.Pointer)
+	return ok
+}
+
+func isStruct(t types.Type) bool {
+	_, ok := t.(types.Structure)
+	return ok
+}
+
+func isVoid(t types.Type) bool {
+	_, ok := t.(types.Void)
+	return ok
+}
+
+func isVoidPointer(t types.Type) bool {
+	pointer, ok := t.(types.Pointer)
+	return ok && isVoid(pointer.Referenced)
+}
+
+// isPointerToComplete reports whether t is a pointer that arithmetic can be done on
+func isPointerToComplete(t types.Type) bool {
+	pointer, ok := t.(types.Pointer)
+	return ok && types.IsComplete(pointer.Referenced)
+}
+
+// isNullPointerConstant reports whether exp is an integer constant with the value zero
+func isNullPointerConstant(exp parser.Expression) bool {
+	value, err := constexpr.EvaluateInteger(exp)
+	return err == nil && types.IsZero(value)
+}
+
+// typecheckPointerArithmetic handles a binary expression with at least one pointer operand, other than == and !=
+func typecheckPointerArithmetic(item *parser.BinaryExp) error {
+	leftType, rightType := item.Left.GetType(), item.Right.GetType()
+	switch {
+	case item.Op == parser.BinopAdd && isPointerToComplete(leftType) && types.IsInteger(rightType):
+		item.Right = convertTo(item.Right, types.Long{})
+		item.SetType(leftType)
+	case item.Op == parser.BinopAdd && types.IsInteger(leftType) && isPointerToComplete(rightType):
+		item.Left = convertTo(item.Left, types.Long{})
+		item.SetType(rightType)
+	case item.Op == parser.BinopSubtract && isPointerToComplete(leftType) && types.IsInteger(rightType):
+		item.Right = convertTo(item.Right, types.Long{})
+		item.SetType(leftType)
+	case item.Op == parser.BinopSubtract && isPointerToComplete(leftType) && types.Equal(leftType, rightType):
+		// The difference between two pointers is a count of elements
+		item.SetType(types.Long{})
+	case isRelational(item.Op) && isPointer(leftType) && types.Equal(leftType, rightType):
+		item.SetType(types.Int{})
+	default:
+		return errors.NewAnalysisError("invalid operands to binary operator on pointer type", item.Loc)
+	}
+	return nil
+}
+
+func isBitwise(op parser.BinopType) bool {
+	switch op {
+	case parser.BinopBitwiseAnd, parser.BinopBitwiseOr, parser.BinopBitwiseXor:
+		return true
+	default:
+		return false
+	}
+}
+
+func isRelational(op parser.BinopType) bool {
+	switch op {
+	case parser.BinopLessThan, parser.BinopLessOrEqual, parser.BinopGreaterThan, parser.BinopGreaterOrEqual:
+		return true
+	default:
+		return false
+	}
+}
+
+// commonPointerType returns the type two operands are converted to when at least one of them is a pointer
+func commonPointerType(e1, e2 parser.Expression, loc errors.Location) (types.Type, error) {
+	t1, t2 := e1.GetType(), e2.GetType()
+	switch {
+	case types.Equal(t1, t2):
+		return t1, nil
+	case isNullPointerConstant(e1):
+		return t2, nil
+	case isNullPointerConstant(e2):
+		return t1, nil
+	case isVoidPointer(t1) && isPointer(t2):
+		return t1, nil
+	case isPointer(t1) && isVoidPointer(t2):
+		return t2, nil
+	default:
+		return nil, errors.NewAnalysisError("incompatible types "+t1.String()+" and "+t2.String(), loc)
+	}
+}
+
+// convertByAssignment converts exp to t as if by assignment, which permits fewer conversions than a cast
+func convertByAssignment(exp parser.Expression, t types.Type, loc errors.Location) (parser.Expression, error) {
+	switch {
+	case types.Equal(exp.GetType(), t):
+		return exp, nil
+	case types.IsArithmetic(exp.GetType()) && types.IsArithmetic(t):
+		return convertTo(exp, t), nil
+	case isNullPointerConstant(exp) && isPointer(t):
+		return convertTo(exp, t), nil
+	case isVoidPointer(exp.GetType()) && isPointer(t), isPointer(exp.GetType()) && isVoidPointer(t):
+		return convertTo(exp, t), nil
+	default:
+		return nil, errors.NewAnalysisError("can't convert "+exp.GetType().String()+" to "+t.String(), loc)
+	}
+}
+
+// convertTo wraps exp in a cast to t unless it already has that type
+func convertTo(exp parser.Expression, t types.Type) parser.Expression {
+	if types.Equal(exp.GetType(), t) {
+		return exp
+	}
+
+	cast := &parser.CastFactor{TargetType: t, Expr: exp}
+	cast.SetType(t)
+	wrapped := &parser.FactorExp{Factor: cast}
+	wrapped.SetType(t)
+	return wrapped
+}
+
+// convertFactorTo is convertTo for a factor
+func convertFactorTo(factor parser.Factor, t types.Type) parser.Factor {
+	if types.Equal(factor.GetType(), t) {
+		return factor
+	}
+
+	wrapped := &parser.FactorExp{Factor: factor}
+	wrapped.SetType(factor.GetType())
+	cast := &parser.CastFactor{TargetType: t, Expr: wrapped}
+	cast.SetType(t)
+	return cast
+}